@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/config"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/duration"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/logging"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/notify"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/prom"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/resilience"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/usage"
+	"github.com/rs/zerolog/log"
+	"github.com/sashabaranov/go-openai"
+)
+
+// newProviderClient creates the client of the configured AI provider, or returns nil if none is configured. Azure is
+// used over OpenAI when both are set. In local-only mode, providers that aren't local are refused.
+func newProviderClient(cfg *config.MasterConfig) *openai.Client {
+	if cli.AzureAIAPIKey != "" && cli.AzureEndpoint == "" {
+		log.Error().Msg("Azure Endpoint is required if Azure API Key is provided")
+	}
+
+	var oaiConfig openai.ClientConfig
+	switch {
+	case aiProvider() == "azure":
+		oaiConfig = openai.DefaultAzureConfig(cli.AzureAIAPIKey, cli.AzureEndpoint)
+	case cli.OpenAIAPIKey != "":
+		oaiConfig = openai.DefaultConfig(cli.OpenAIAPIKey)
+		if cfg.AI.OpenAI.BaseURL != "" {
+			oaiConfig.BaseURL = cfg.AI.OpenAI.BaseURL
+		}
+	default:
+		return nil
+	}
+
+	if cfg.Redaction.LocalOnly && !isLocalEndpoint(oaiConfig.BaseURL) {
+		log.Error().Str("Endpoint", oaiConfig.BaseURL).Msg("Local-only mode refuses AI providers that aren't local, AI categorization is disabled")
+		return nil
+	}
+	oaiConfig.HTTPClient = prom.NewClient("openai", 0) // Requests have their own timeouts
+	return openai.NewClientWithConfig(oaiConfig)
+}
+
+// compatibleClient creates a client of the OpenAI-compatible server at baseURL, such as a local model.
+func compatibleClient(baseURL string) *openai.Client {
+	oaiConfig := openai.DefaultConfig(cli.OpenAIAPIKey)
+	oaiConfig.BaseURL = baseURL
+	oaiConfig.HTTPClient = prom.NewClient("openai", 0)
+	return openai.NewClientWithConfig(oaiConfig)
+}
+
+const (
+	defaultAIRetries       = 2
+	defaultAIBackoff       = time.Second
+	defaultBreakerFailures = 5
+	defaultBreakerCooldown = time.Minute
+)
+
+// AIClient sends requests to the configured models in turn until one answers. Requests failing with a rate limit,
+// server or network error are retried with backoff first. Each model has its own rate limit, and a circuit breaker
+// that skips it for a cool-down once it keeps failing, so a provider that is down doesn't hold up every transaction.
+type AIClient struct {
+	models          []*aiModel
+	backoff         resilience.Backoff
+	breakerFailures int // Threshold and cool-down of each model's circuit breaker
+	breakerCooldown time.Duration
+}
+
+// aiModel is a model in the fallback list.
+type aiModel struct {
+	name            string
+	client          *openai.Client
+	limiter         *resilience.Limiter
+	breaker         *resilience.Breaker
+	promptPrice     float64 // Dollars per million tokens
+	completionPrice float64
+}
+
+// NewAIClient creates an AIClient for the configured model list, or the provider's model when there is none.
+// Models are asked through provider, the client of the configured provider (nil if there is none), unless they
+// have a base_url of their own. It returns nil if no model can be reached.
+func NewAIClient(cfg *config.MasterConfig, provider *openai.Client) *AIClient {
+	limits := cfg.AI.Limits
+	a := &AIClient{backoff: resilience.Backoff{
+		Retries: limits.Retries,
+		Initial: limitDuration("backoff", limits.Backoff, defaultAIBackoff),
+	}}
+	if a.backoff.Retries == 0 {
+		a.backoff.Retries = defaultAIRetries
+	}
+	a.breakerFailures = limits.BreakerFailures
+	if a.breakerFailures == 0 {
+		a.breakerFailures = defaultBreakerFailures
+	}
+	a.breakerCooldown = limitDuration("breaker_cooldown", limits.BreakerCooldown, defaultBreakerCooldown)
+
+	specs := cfg.AI.Models
+	if len(specs) == 0 {
+		specs = []config.AIModelConfig{{}}
+	}
+	opts := providerOptions(cfg)
+	for _, spec := range specs {
+		m := &aiModel{
+			name:            spec.Model,
+			client:          provider,
+			limiter:         resilience.NewLimiter(limits.RequestsPerMinute, limits.Burst),
+			breaker:         resilience.NewBreaker(a.breakerFailures, a.breakerCooldown),
+			promptPrice:     spec.PromptPrice,
+			completionPrice: spec.CompletionPrice,
+		}
+		if m.name == "" {
+			m.name = defaultModel(cfg)
+		}
+		if m.promptPrice == 0 && m.completionPrice == 0 {
+			m.promptPrice, m.completionPrice = opts.PromptPrice, opts.CompletionPrice
+		}
+		if spec.BaseURL != "" {
+			if cfg.Redaction.LocalOnly && !isLocalEndpoint(spec.BaseURL) {
+				log.Error().Str("Model", m.name).Str("Endpoint", spec.BaseURL).Msg("Local-only mode refuses AI providers that aren't local, skipping the model")
+				continue
+			}
+			m.client = compatibleClient(spec.BaseURL)
+		}
+		if m.client == nil {
+			continue
+		}
+		a.models = append(a.models, m)
+		setCircuitMetric(m)
+	}
+
+	if len(a.models) == 0 {
+		return nil
+	}
+	return a
+}
+
+// limitDuration parses a duration from the limits config, or returns def.
+func limitDuration(name, value string, def time.Duration) time.Duration {
+	if value == "" {
+		return def
+	}
+	d, err := duration.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Error().Err(err).Str(name, value).Msg("Invalid AI limit, using the default")
+		return def
+	}
+	return d
+}
+
+// Model returns the first model, the one asked unless it fails.
+func (a *AIClient) Model() string {
+	if a == nil {
+		return ""
+	}
+	return a.models[0].name
+}
+
+// withModel returns a client asking only model through the provider and rate limit of the first model, or the same
+// models if model is empty. Its models have circuit breakers of their own, so its failures don't stop requests
+// made through a.
+func (a *AIClient) withModel(model string) *AIClient {
+	if a == nil {
+		return nil
+	}
+	models := a.models
+	if model != "" {
+		models = models[:1]
+	}
+
+	c := &AIClient{backoff: a.backoff, breakerFailures: a.breakerFailures, breakerCooldown: a.breakerCooldown}
+	for _, m := range models {
+		cm := *m
+		if model != "" {
+			cm.name = model
+		}
+		cm.breaker = resilience.NewBreaker(a.breakerFailures, a.breakerCooldown)
+		c.models = append(c.models, &cm)
+	}
+	return c
+}
+
+// chat sends a chat request to each model in turn until one answers, each attempt limited to timeout. record is
+// called for every request sent.
+func (a *AIClient) chat(ctx context.Context, req openai.ChatCompletionRequest, timeout time.Duration, record func(m *aiModel, start time.Time, u openai.Usage, err error)) (openai.ChatCompletionResponse, error) {
+	var resp openai.ChatCompletionResponse
+	var err error
+	for i, m := range a.models {
+		err = m.breaker.Do(func() error {
+			return a.backoff.Retry(ctx, func() error {
+				if err := m.limiter.Wait(ctx); err != nil {
+					return err
+				}
+				r, err := m.send(ctx, req, timeout, record)
+				resp = r
+				return err
+			}, retryable, func(attempt int, err error) {
+				prom.AI.Retries.Add(1)
+				logging.Ctx(ctx).Warn().Err(err).Str("Model", m.name).Int("Attempt", attempt).Msg("Retrying AI request")
+			})
+		}, breakerFailure)
+
+		setCircuitMetric(m)
+		switch {
+		case err == nil:
+			if i > 0 {
+				prom.AI.Fallbacks.Add(1)
+			}
+			return resp, nil
+		case errors.Is(err, resilience.ErrOpen):
+			prom.AI.Failures.Add(failureCircuitOpen, 1)
+			logging.Ctx(ctx).Debug().Str("Model", m.name).Msg("Circuit breaker open, skipping the model")
+		case m.breaker.State() == resilience.Open:
+			logging.Ctx(ctx).Error().Err(err).Str("Model", m.name).Msg("⚡ AI model keeps failing, skipping it for a while")
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if i+1 < len(a.models) {
+			logging.Ctx(ctx).Warn().Err(err).Str("Model", m.name).Str("Next", a.models[i+1].name).Msg("AI model failed, falling back")
+		}
+	}
+	return resp, err
+}
+
+// setCircuitMetric exports whether the circuit breaker of m is open.
+func setCircuitMetric(m *aiModel) {
+	open := 0.0
+	if m.breaker.State() == resilience.Open {
+		open = 1
+	}
+	prom.AI.CircuitOpen.Set(m.name, open)
+}
+
+// send sends a chat request to the model. Models that only do completions (gpt-3.5-turbo-instruct) are sent the
+// messages joined into one prompt.
+func (m *aiModel) send(ctx context.Context, req openai.ChatCompletionRequest, timeout time.Duration, record func(m *aiModel, start time.Time, u openai.Usage, err error)) (openai.ChatCompletionResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req.Model = m.name
+	start := time.Now()
+
+	if m.name != openai.GPT3Dot5TurboInstruct {
+		resp, err := m.client.CreateChatCompletion(ctx, req)
+		record(m, start, resp.Usage, err)
+		return resp, err
+	}
+
+	cresp, err := m.client.CreateCompletion(ctx, openai.CompletionRequest{
+		Model:       m.name,
+		Prompt:      completionPrompt(req.Messages),
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+	})
+	var u openai.Usage
+	if cresp.Usage != nil {
+		u = *cresp.Usage
+	}
+	record(m, start, u, err)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+
+	resp := openai.ChatCompletionResponse{ID: cresp.ID, Model: cresp.Model, Usage: u}
+	for _, choice := range cresp.Choices {
+		resp.Choices = append(resp.Choices, openai.ChatCompletionChoice{
+			Index:        choice.Index,
+			Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: choice.Text},
+			FinishReason: openai.FinishReason(choice.FinishReason),
+		})
+	}
+	return resp, nil
+}
+
+// retryable reports whether a failed AI request is worth sending again: rate limits, server and network errors.
+func retryable(err error) bool {
+	switch failureType(err) {
+	case failureRateLimit, failureServer, failureNetwork:
+		return true
+	}
+	return false
+}
+
+// breakerFailure reports whether a failed AI request counts against the model's circuit breaker. Only failures of
+// the provider do, not client errors such as a bad request, or errors that never reached it.
+func breakerFailure(err error) bool {
+	switch failureType(err) {
+	case failureTimeout, failureRateLimit, failureServer, failureNetwork:
+		return true
+	}
+	return false
+}
+
+// Types of failed AI requests, as counted in the openai_response_failure metric
+const (
+	failureTimeout     = "timeout"
+	failureRateLimit   = "rate_limit"
+	failureServer      = "server"
+	failureClient      = "client"
+	failureNetwork     = "network"
+	failureUnknown     = "unknown" // Not sent, or canceled, such as an unsupported model or shutting down
+	failureInvalidJSON = "invalid_json"
+	failureBudget      = "budget"
+	failureCircuitOpen = "circuit_open"
+)
+
+// errBudgetExceeded is returned instead of sending a request once the monthly budget is used up.
+var errBudgetExceeded = errors.New("monthly AI budget exceeded")
+
+// createChatCompletion sends a chat request to the AI provider, trying each model in turn, and accounts for its
+// usage. Each attempt is limited to timeout.
+func (c *Categorizer) createChatCompletion(ctx context.Context, req openai.ChatCompletionRequest, timeout time.Duration) (openai.ChatCompletionResponse, error) {
+	if c.overBudget() {
+		prom.AI.Failures.Add(failureBudget, 1)
+		return openai.ChatCompletionResponse{}, errBudgetExceeded
+	}
+	return c.oai.chat(ctx, req, timeout, c.recordUsage)
+}
+
+// overBudget reports whether this month's AI budget is used up, keeping the budget gauge current. Skipped requests
+// are counted by the caller that skips them.
+func (c *Categorizer) overBudget() bool {
+	if c.usage == nil {
+		return false
+	}
+	exceeded := c.usage.Exceeded()
+	prom.AI.BudgetExceeded.Store(exceeded)
+	return exceeded
+}
+
+// recordUsage counts a finished request to m, its tokens and estimated cost. Crossing the monthly budget is
+// reported once, when it happens. Requests of a dry run copy (see WithChain) aren't counted against the budget.
+func (c *Categorizer) recordUsage(m *aiModel, start time.Time, u openai.Usage, err error) {
+	cost := (float64(u.PromptTokens)*m.promptPrice + float64(u.CompletionTokens)*m.completionPrice) / 1e6
+	tracker := c.usage
+	if c.dryRun {
+		tracker = nil
+	}
+	accountUsage(tracker, c.notifier, c.config, start, u, cost, err)
+}
+
+// accountUsage counts a finished request to the AI provider against tracker, which may be nil. Using up the budget
+// is sent to notifier.
+func accountUsage(tracker *usage.Tracker, notifier *notify.Notifier, cfg *config.MasterConfig, start time.Time, u openai.Usage, cost float64, err error) {
+	prom.AI.Observe(time.Since(start), cost)
+	if err != nil {
+		prom.AI.Failures.Add(failureType(err), 1)
+		return
+	}
+	prom.AI.PromptTokens.Add(uint64(u.PromptTokens))
+	prom.AI.CompletionTokens.Add(uint64(u.CompletionTokens))
+
+	if tracker == nil {
+		return
+	}
+	if tracker.Record(usage.Usage{PromptTokens: u.PromptTokens, CompletionTokens: u.CompletionTokens, Cost: cost}) {
+		month := tracker.Month()
+		prom.AI.BudgetExceeded.Store(true)
+		log.Error().
+			Int64("Tokens", month.Tokens()).
+			Float64("Cost", month.Cost).
+			Int64("BudgetTokens", cfg.AI.Budget.MonthlyTokens).
+			Float64("BudgetDollars", cfg.AI.Budget.MonthlyDollars).
+			Msg("💸 Monthly AI budget used up, categorizing without the AI provider until next month")
+		notifyBudgetExceeded(notifier, month, cfg.AI.Budget)
+	}
+}
+
+// failureType classifies a failed AI request.
+func failureType(err error) string {
+	var apiErr *openai.APIError
+	var reqErr *openai.RequestError
+	var netErr net.Error
+	var urlErr *url.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return failureTimeout
+	case errors.Is(err, context.Canceled):
+		return failureUnknown
+	case errors.As(err, &apiErr):
+		return statusFailure(apiErr.HTTPStatusCode)
+	case errors.As(err, &reqErr):
+		return statusFailure(reqErr.HTTPStatusCode)
+	case errors.As(err, &netErr), errors.As(err, &urlErr):
+		return failureNetwork
+	}
+	return failureUnknown
+}
+
+func statusFailure(status int) string {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return failureRateLimit
+	case status >= 500:
+		return failureServer
+	}
+	return failureClient
+}
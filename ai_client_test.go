@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/config"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/eval/evaltest"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/notify"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/prom"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/resilience"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/usage"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestAIClientFallsBack(t *testing.T) {
+	var broken atomic.Int64
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		broken.Add(1)
+		http.Error(w, `{"error":{"message":"overloaded"}}`, http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	up := evaltest.NewServer(map[string]evaltest.Answer{"NETFLIX": {Merchant: "Netflix", Category: "Entertainment"}})
+	defer up.Close()
+
+	cfg := &config.MasterConfig{AI: config.AIConfig{
+		Models: []config.AIModelConfig{
+			{Model: "gpt-4o-mini", BaseURL: down.URL + "/v1"},
+			{Model: "llama3.1", BaseURL: up.URL + "/v1"},
+		},
+		Limits: config.AILimitsConfig{Retries: 1, Backoff: "1ms", BreakerFailures: 1, BreakerCooldown: "1h"},
+	}}
+	c := NewCategorizer(nil, cfg, NewAIClient(cfg, nil), nil, nil, nil, nil, nil, nil)
+	req := openai.ChatCompletionRequest{Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "NETFLIX.COM"}}}
+
+	for i := 0; i < 2; i++ {
+		resp, err := c.createChatCompletion(context.Background(), req, time.Second)
+		if err != nil {
+			t.Fatalf("Got error %v, wanted the fallback model to answer", err)
+		}
+		if !strings.Contains(resp.Choices[0].Message.Content, "Netflix") {
+			t.Fatalf("Got reply %q, wanted Netflix", resp.Choices[0].Message.Content)
+		}
+	}
+	if broken.Load() != 2 {
+		t.Fatalf("Got %d requests to the failing model, wanted 2 (one retry, then its circuit breaker open)", broken.Load())
+	}
+	if up.Requests.Load() != 2 {
+		t.Fatalf("Got %d requests to the fallback model, wanted 2", up.Requests.Load())
+	}
+}
+
+func TestWithModelBreaker(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"message":"overloaded"}}`, http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	cfg := &config.MasterConfig{AI: config.AIConfig{
+		Models: []config.AIModelConfig{{Model: "gpt-4o-mini", BaseURL: down.URL + "/v1"}},
+		Limits: config.AILimitsConfig{Retries: 1, Backoff: "1ms", BreakerFailures: 1, BreakerCooldown: "1h"},
+	}}
+	a := NewAIClient(cfg, nil)
+	req := openai.ChatCompletionRequest{Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "NETFLIX.COM"}}}
+
+	for _, shadow := range []*AIClient{a.withModel("gpt-4o"), a.withModel("")} {
+		if _, err := shadow.chat(context.Background(), req, time.Second, func(*aiModel, time.Time, openai.Usage, error) {}); err == nil {
+			t.Fatalf("Got no error, wanted the failing model to fail")
+		}
+		if got := shadow.models[0].breaker.State(); got != resilience.Open {
+			t.Fatalf("Got breaker %s, wanted the copy's breaker open", got)
+		}
+	}
+	if got := a.models[0].breaker.State(); got != resilience.Closed {
+		t.Fatalf("Got breaker %s, wanted the first model's breaker left closed", got)
+	}
+}
+
+func TestFailureType(t *testing.T) {
+	tests := []struct {
+		err       error
+		want      string
+		retryable bool
+	}{
+		{&openai.APIError{HTTPStatusCode: http.StatusTooManyRequests}, failureRateLimit, true},
+		{&openai.APIError{HTTPStatusCode: http.StatusBadGateway}, failureServer, true},
+		{&openai.APIError{HTTPStatusCode: http.StatusBadRequest}, failureClient, false},
+		{&url.Error{Op: "Post", URL: "http://ai", Err: errors.New("connection refused")}, failureNetwork, true},
+		{fmt.Errorf("sending: %w", context.DeadlineExceeded), failureTimeout, false},
+		{&url.Error{Op: "Post", URL: "http://ai", Err: context.Canceled}, failureUnknown, false},
+		{openai.ErrCompletionUnsupportedModel, failureUnknown, false},
+	}
+	for _, tt := range tests {
+		if got := failureType(tt.err); got != tt.want {
+			t.Fatalf("Got %s for %v, wanted %s", got, tt.err, tt.want)
+		}
+		if got := retryable(tt.err); got != tt.retryable {
+			t.Fatalf("Got retryable %t for %v, wanted %t", got, tt.err, tt.retryable)
+		}
+	}
+	if breakerFailure(openai.ErrCompletionUnsupportedModel) {
+		t.Fatalf("Got an error that never reached the provider counted against the circuit breaker")
+	}
+}
+
+// recordingSink keeps the messages sent to it.
+type recordingSink struct {
+	messages chan notify.Message
+}
+
+func (r *recordingSink) Send(_ context.Context, m notify.Message) error {
+	r.messages <- m
+	return nil
+}
+
+func TestBudgetExceededNotifies(t *testing.T) {
+	server := evaltest.NewServer(map[string]evaltest.Answer{"NETFLIX": {Merchant: "Netflix", Category: "Entertainment"}})
+	defer server.Close()
+
+	sink := &recordingSink{messages: make(chan notify.Message, 10)}
+	notifier, err := notify.New(map[string]notify.Sink{"test": sink}, notify.Options{Routes: map[string][]string{notify.BudgetExceeded: {"test"}}})
+	if err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+	tracker, _ := usage.Open("", usage.Limits{Tokens: 150}) // Each reply uses 110 tokens
+
+	oaiConfig := openai.DefaultConfig("test")
+	oaiConfig.BaseURL = server.URL + "/v1"
+	cfg := &config.MasterConfig{AI: config.AIConfig{Budget: config.AIBudgetConfig{MonthlyTokens: 150}}}
+	c := NewCategorizer(nil, cfg, NewAIClient(cfg, openai.NewClientWithConfig(oaiConfig)), nil, nil, nil, nil, tracker, notifier)
+	req := openai.ChatCompletionRequest{Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "NETFLIX.COM"}}}
+
+	skipped := prom.AI.Failures.Snapshot()[failureBudget]
+	for i := 0; i < 3; i++ {
+		_, err = c.createChatCompletion(context.Background(), req, time.Second)
+	}
+	if err != errBudgetExceeded {
+		t.Fatalf("Got error %v, wanted the budget exceeded", err)
+	}
+	if !c.overBudget() {
+		t.Fatalf("Got the budget available, wanted it used up")
+	}
+	if n := prom.AI.Failures.Snapshot()[failureBudget] - skipped; n != 1 {
+		t.Fatalf("Got %d budget failures, wanted 1 for the one skipped request", n)
+	}
+
+	notifier.Wait()
+	select {
+	case m := <-sink.messages:
+		if m.Type != notify.BudgetExceeded || m.Fields["tokens"] != "220" || m.Fields["budget_tokens"] != "150" {
+			t.Fatalf("Got %+v, wanted a budget_exceeded notification for 220 of 150 tokens", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Got no notification, wanted budget_exceeded")
+	}
+	if len(sink.messages) != 0 {
+		t.Fatalf("Got %d more notifications, wanted budget_exceeded sent once", len(sink.messages))
+	}
+}
+
+func TestShadowUsageNotCounted(t *testing.T) {
+	server := evaltest.NewServer(map[string]evaltest.Answer{"NETFLIX": {Merchant: "Netflix", Category: "Entertainment"}})
+	defer server.Close()
+
+	tracker, _ := usage.Open("", usage.Limits{Tokens: 150}) // Each reply uses 110 tokens
+	oaiConfig := openai.DefaultConfig("test")
+	oaiConfig.BaseURL = server.URL + "/v1"
+	cfg := &config.MasterConfig{AI: config.AIConfig{Budget: config.AIBudgetConfig{MonthlyTokens: 150}}}
+	c := NewCategorizer(nil, cfg, NewAIClient(cfg, openai.NewClientWithConfig(oaiConfig)), nil, nil, nil, nil, tracker, nil)
+	shadow := c.WithChain(config.ChainConfig{Model: "gpt-4o"})
+	req := openai.ChatCompletionRequest{Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "NETFLIX.COM"}}}
+
+	for i := 0; i < 3; i++ {
+		if _, err := shadow.createChatCompletion(context.Background(), req, time.Second); err != nil {
+			t.Fatalf("Got error %v, wanted the shadow's requests outside the budget", err)
+		}
+	}
+	if tokens := tracker.Month().Tokens(); tokens != 0 {
+		t.Fatalf("Got %d tokens counted, wanted the shadow's requests left out", tokens)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, _ = c.createChatCompletion(context.Background(), req, time.Second)
+	}
+	if _, err := shadow.createChatCompletion(context.Background(), req, time.Second); err != errBudgetExceeded {
+		t.Fatalf("Got error %v, wanted the shadow stopped once the budget is used up", err)
+	}
+}
@@ -0,0 +1,256 @@
+// Package catcache remembers categorization results so the same description is not sent to an AI provider twice
+package catcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/firefly"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/internal/jsonfile"
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+// Entry is a cached categorization result.
+type Entry struct {
+	Key         string    `json:"key"`
+	Description string    `json:"description"`
+	Merchant    string    `json:"merchant"`
+	MerchantID  string    `json:"merchant_id,omitempty"`
+	CategoryID  string    `json:"category_id"`
+	Provider    string    `json:"provider"`
+	Model       string    `json:"model"`
+	Confidence  float64   `json:"confidence,omitempty"`
+	Created     time.Time `json:"created"`
+	LastUsed    time.Time `json:"last_used"`
+	Hits        int       `json:"hits"`
+}
+
+// Options controls how cache keys are built and how long entries live.
+type Options struct {
+	TTL       time.Duration // Entries older than this are ignored and removed (0 = never expire)
+	ByAmount  bool          // Include an amount bucket in the key
+	ByAccount bool          // Include the account in the key
+}
+
+// Cache is a persistent categorization cache keyed by normalized description.
+type Cache struct {
+	path        string
+	opts        Options
+	Entries     map[string]Entry `json:"entries"`
+	Fingerprint string           `json:"categories_fingerprint"`
+	dirty       bool             // Changed since the last save
+	mu          sync.Mutex
+}
+
+// Open loads the categorizations cached at path. Without a file the cache starts cold.
+func Open(path string, opts Options) (*Cache, error) {
+	c := &Cache{
+		path:    path,
+		opts:    opts,
+		Entries: make(map[string]Entry),
+	}
+
+	if err := jsonfile.Load(path, c); err != nil {
+		return c, fmt.Errorf("could not load categorization cache: %w", err)
+	}
+	if c.Entries == nil {
+		c.Entries = make(map[string]Entry)
+	}
+	return c, nil
+}
+
+// Key builds the cache key for a transaction from its normalized description, and optionally its amount
+// bucket and account. Deposits are always cached apart from withdrawals, as they are categorized differently.
+func (c *Cache) Key(description string, amount decimal.Decimal, accountID string) string {
+	key := NormalizeDescription(description)
+	if c.opts.ByAmount {
+		key += "|" + AmountBucket(amount)
+	} else if amount.IsPositive() {
+		key += "|deposit"
+	}
+	if c.opts.ByAccount {
+		key += "|" + accountID
+	}
+	return key
+}
+
+// Get returns the entry for key. Expired entries are removed and reported as a miss. Hit counts are kept in memory
+// until the next Flush.
+func (c *Cache) Get(key string) (Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.Entries[key]
+	if !ok {
+		return Entry{}, false
+	}
+	if c.expired(e) {
+		delete(c.Entries, key)
+		c.dirty = true
+		return Entry{}, false
+	}
+
+	e.Hits++
+	e.LastUsed = time.Now()
+	c.Entries[key] = e
+	c.dirty = true
+	return e, true
+}
+
+// Put stores a result in the cache. It is written to disk by the next Flush.
+func (c *Cache) Put(e Entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e.Created.IsZero() {
+		e.Created = time.Now()
+	}
+	e.LastUsed = e.Created
+	c.Entries[e.Key] = e
+	c.dirty = true
+}
+
+// Flush writes the cache to disk if it changed since it was last written. A nil cache has nothing to flush.
+func (c *Cache) Flush() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dirty {
+		c.save()
+	}
+}
+
+// CheckCategories compares the fingerprint of the current Firefly categories with the one the cache was built
+// against. When the categories changed, every entry is dropped since cached category IDs may no longer apply.
+// Returns true if the cache was invalidated.
+func (c *Cache) CheckCategories(fingerprint string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.Fingerprint == fingerprint {
+		return false
+	}
+
+	invalidated := c.Fingerprint != "" && len(c.Entries) > 0
+	if invalidated {
+		log.Info().Int("Entries", len(c.Entries)).Msg("Categories changed in Firefly, clearing the categorization cache")
+		c.Entries = make(map[string]Entry)
+	}
+	c.Fingerprint = fingerprint
+	c.save()
+	return invalidated
+}
+
+// List returns the entries whose key, description, merchant, or category contains filter (case-insensitive),
+// sorted by key. An empty filter lists everything.
+func (c *Cache) List(filter string) []Entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var entries []Entry
+	for _, e := range c.Entries {
+		if matches(e, filter) {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries
+}
+
+// Purge removes the entries matching filter (see List) and, if olderThan is set, created before then.
+// Expired entries are always removed. Returns how many entries were removed.
+func (c *Cache) Purge(filter string, olderThan time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for key, e := range c.Entries {
+		if c.expired(e) || (matches(e, filter) && (olderThan.IsZero() || e.Created.Before(olderThan))) {
+			delete(c.Entries, key)
+			removed++
+		}
+	}
+	if removed > 0 {
+		c.save()
+	}
+	return removed
+}
+
+// expired reports whether an entry is older than the TTL.
+func (c *Cache) expired(e Entry) bool {
+	return c.opts.TTL > 0 && time.Since(e.Created) > c.opts.TTL
+}
+
+// save persists the cache, compactly as it can hold thousands of entries. c.mu must be held.
+func (c *Cache) save() {
+	if err := jsonfile.SaveCompact(c.path, c); err != nil {
+		log.Error().Err(err).Msg("Could not save categorization cache")
+		return
+	}
+	c.dirty = false
+}
+
+func matches(e Entry, filter string) bool {
+	if filter == "" {
+		return true
+	}
+	filter = strings.ToLower(filter)
+	for _, field := range []string{e.Key, e.Description, e.Merchant, e.CategoryID} {
+		if strings.Contains(strings.ToLower(field), filter) {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeDescription lowercases a description, collapses punctuation and whitespace, and drops tokens that
+// are only digits (store numbers, reference numbers) so repeated purchases share a key.
+func NormalizeDescription(description string) string {
+	fields := strings.FieldsFunc(strings.ToLower(description), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	tokens := fields[:0]
+	for _, f := range fields {
+		if _, err := strconv.Atoi(f); err == nil {
+			continue
+		}
+		tokens = append(tokens, f)
+	}
+	return strings.Join(tokens, " ")
+}
+
+// AmountBucket groups amounts by direction and order of magnitude, e.g. "-10" for a 12.34 withdrawal.
+func AmountBucket(amount decimal.Decimal) string {
+	sign := "+"
+	if amount.IsNegative() {
+		sign = "-"
+	}
+	bucket := int64(1)
+	for whole := amount.Abs().IntPart(); whole >= 10; whole /= 10 {
+		bucket *= 10
+	}
+	return sign + strconv.FormatInt(bucket, 10)
+}
+
+// Fingerprint identifies a set of Firefly categories, so a change to them can be detected.
+func Fingerprint(categories []firefly.Category) string {
+	names := make([]string, 0, len(categories))
+	for _, cat := range categories {
+		names = append(names, strconv.Itoa(cat.ID)+":"+cat.Name)
+	}
+	sort.Strings(names)
+
+	sum := sha256.Sum256([]byte(strings.Join(names, "\n")))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,71 @@
+package catcache_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/catcache"
+	"github.com/shopspring/decimal"
+)
+
+func TestKey(t *testing.T) {
+	c, _ := catcache.Open("", catcache.Options{ByAmount: true})
+
+	a := c.Key("NETFLIX.COM 866-579-7172 CA", decimal.RequireFromString("-15.49"), "25")
+	b := c.Key("Netflix.com  8665797172 CA", decimal.RequireFromString("-17.99"), "26")
+	if a != b {
+		t.Fatalf("Got keys %q and %q, wanted them to match", a, b)
+	}
+	if a != "netflix com ca|-10" {
+		t.Fatalf("Got key %q, wanted netflix com ca|-10", a)
+	}
+
+	c, _ = catcache.Open("", catcache.Options{})
+	if k := c.Key("NETFLIX.COM", decimal.RequireFromString("15.49"), "25"); k != "netflix com|deposit" {
+		t.Fatalf("Got key %q for a deposit, wanted netflix com|deposit", k)
+	}
+}
+
+func TestExpiryAndInvalidation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	c, err := catcache.Open(path, catcache.Options{TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s\n", err)
+	}
+	c.CheckCategories("v1")
+
+	c.Put(catcache.Entry{Key: "fresh", Merchant: "Netflix", CategoryID: "4"})
+	c.Put(catcache.Entry{Key: "stale", Merchant: "Hulu", CategoryID: "4", Created: time.Now().Add(-2 * time.Hour)})
+
+	if _, ok := c.Get("stale"); ok {
+		t.Fatalf("Got an expired entry, wanted a miss")
+	}
+
+	// Reload from disk
+	c.Flush()
+	c, err = catcache.Open(path, catcache.Options{TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s\n", err)
+	}
+	saved, _ := os.ReadFile(path)
+	if e, ok := c.Get("fresh"); !ok || e.Merchant != "Netflix" || e.Hits != 1 {
+		t.Fatalf("Got %v, %t for a fresh entry, wanted Netflix with a hit", e, ok)
+	}
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, saved) {
+		t.Fatalf("Got the cache file rewritten on a hit, wanted it left until Flush")
+	}
+	c.Flush()
+	if data, _ := os.ReadFile(path); bytes.Equal(data, saved) {
+		t.Fatalf("Got the cache file unchanged after Flush, wanted the hit saved")
+	}
+
+	if !c.CheckCategories("v2") {
+		t.Fatalf("Changing categories did not invalidate the cache")
+	}
+	if _, ok := c.Get("fresh"); ok {
+		t.Fatalf("Got an entry after the categories changed, wanted a miss")
+	}
+}
@@ -5,146 +5,230 @@ import (
 	"encoding/json"
 	"strconv"
 	"strings"
-	"time"
+	"sync"
 
 	"github.com/forPelevin/gomoji"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/catcache"
 	"github.com/helpcomp/firefly-iii-simplefin-importer/config"
 	"github.com/helpcomp/firefly-iii-simplefin-importer/firefly"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/learn"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/logging"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/match"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/merchant"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/notify"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/prom"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/redact"
 	"github.com/helpcomp/firefly-iii-simplefin-importer/simplefin"
-	"github.com/rs/zerolog/log"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/usage"
 	"github.com/sashabaranov/go-openai"
+	"github.com/shopspring/decimal"
 )
 
+// Categorizer extracts the company and category of transactions, remembering AI results in a cache.
+type Categorizer struct {
+	firefly    *firefly.Firefly
+	config     *config.MasterConfig
+	oai        *AIClient
+	cache      *catcache.Cache
+	learner    *Learner
+	embedder   *Embedder
+	aliases    *merchant.Table
+	prompts    *Prompts
+	redactor   *redact.Redactor
+	usage      *usage.Tracker
+	notifier   *notify.Notifier
+	prefetched map[string]ExtractedData // Batch results by SimpleFIN transaction ID, used once
+	mu         sync.Mutex
+
+	// Set on chain copies, see WithChain
+	steps         map[string]bool // Categorization steps used (nil = all)
+	modelOverride string
+	dryRun        bool // Don't store results in the cache
+}
+
+// NewCategorizer creates a Categorizer. cache may be nil to always ask the AI provider, and learner and embedder
+// may be nil when the learned categorizer and embeddings are disabled. aliases is the learned merchant alias table, used with the configured aliases.
+// Prompts are loaded from the configured templates, and personal information is redacted from what they are given.
+// AI usage is counted against the monthly budget in tracker, which may be nil when there is no budget, and using it
+// up is sent to notifier.
+func NewCategorizer(ff *firefly.Firefly, cfg *config.MasterConfig, oai *AIClient, cache *catcache.Cache, learner *Learner, embedder *Embedder, aliases *merchant.Table, tracker *usage.Tracker, notifier *notify.Notifier) *Categorizer {
+	return &Categorizer{
+		firefly:    ff,
+		config:     cfg,
+		oai:        oai,
+		cache:      cache,
+		learner:    learner,
+		embedder:   embedder,
+		aliases:    aliases,
+		prompts:    loadPrompts(cfg.Prompts),
+		redactor:   buildRedactor(cfg),
+		usage:      tracker,
+		notifier:   notifier,
+		prefetched: make(map[string]ExtractedData),
+	}
+}
+
+// WithChain returns a copy of the categorizer using only the steps of chain. The copy shares the cache and learned
+// categorizer but doesn't store anything, so it can be run next to the real one. It stops asking the AI provider
+// once the budget is used up, but its own requests don't count against the budget.
+func (c *Categorizer) WithChain(chain config.ChainConfig) *Categorizer {
+	cc := NewCategorizer(c.firefly, c.config, c.oai, c.cache, c.learner, c.embedder, c.aliases, c.usage, c.notifier)
+	cc.prompts, cc.redactor = c.prompts, c.redactor
+	cc.oai = c.oai.withModel(chain.Model)
+	cc.modelOverride = chain.Model
+	cc.dryRun = true
+	if len(chain.Steps) > 0 {
+		cc.steps = make(map[string]bool, len(chain.Steps))
+		for _, step := range chain.Steps {
+			cc.steps[step] = true
+		}
+	}
+	return cc
+}
+
+// uses reports whether a categorization step (see the source constants) is part of the chain.
+func (c *Categorizer) uses(step string) bool {
+	return c.steps == nil || c.steps[step]
+}
+
 // ExtractCompanyAndCategory processes a transaction to extract the company and category details for classification.
 // It uses predefined bypass rules and optionally integrates with OpenAI for enhanced categorization insights.
-func ExtractCompanyAndCategory(ff *firefly.Firefly, c *config.MasterConfig, oai *openai.Client, transaction simplefin.Transactions) ExtractedData {
+// Deposits are matched against revenue accounts and income categories, withdrawals against expense accounts.
+// accountID is the Firefly account the transaction belongs to, used when the cache is keyed by account.
+func (c *Categorizer) ExtractCompanyAndCategory(ctx context.Context, transaction simplefin.Transactions, accountID string) ExtractedData {
+	ff, cfg, oai := c.firefly, c.config, c.oai
 	var extracted = ExtractedData{
 		Company:   defaultAccountName,
 		Category:  "",
 		Skip:      false,
 		CompanyID: "",
+		Source:    sourceNone,
 	}
 
-	var sbCategories, sbAccounts strings.Builder
-
 	if transaction.Description == "" {
 		return extracted
 	}
 
 	fireflyCategories, err := ff.CachedCategories()
 	if err != nil {
-		log.Error().Err(err).Msgf("Error getting cached categories - %v", err)
+		logging.Ctx(ctx).Error().Err(err).Str("event", "categorization.failed").Msgf("Error getting cached categories - %v", err)
 		return extracted
 	}
 
-	fireflyAccounts, err := ff.CachedAccounts()
+	accountType := merchantAccountType(transaction)
+	merchantAccounts, err := c.merchantAccounts(accountType)
 	if err != nil {
-		log.Error().Err(err).Msgf("Error getting cached accounts - %v", err)
+		logging.Ctx(ctx).Error().Err(err).Str("event", "categorization.failed").Msgf("Error getting cached accounts - %v", err)
+		return extracted
+	}
+
+	if len(fireflyCategories) == 0 {
+		logging.Ctx(ctx).Warn().Str("event", "categorization.skipped").Msg("No categories available for categorization")
 		return extracted
 	}
 
-	// Loop through Account Names
-	var expenseAccounts []string
-	for _, aa := range fireflyAccounts.Accounts {
-		if aa.Attributes.Type == "expense" {
-			expenseAccounts = append(expenseAccounts, aa.Attributes.Name)
+	// Check to see if it's a bypassed transaction
+	if bypassResp, ok := matchBypass(cfg, transaction); ok && c.uses(sourceRule) {
+		extracted.Confidence = 1
+		extracted.Source = sourceRule
+		if bypassResp.Skip {
+			extracted.Skip = true
+			return extracted
 		}
+		extracted.Company = bypassResp.Company
+		extracted.CompanyID = bypassResp.AssetID
+		extracted.Category = c.findCategoryID(ctx, bypassResp.Category, fireflyCategories)
+		return extracted
 	}
-	sbAccounts.WriteString(strings.Join(expenseAccounts, ", "))
 
-	// Loop through Categories
-	var sbCatArray []string
-	for _, cc := range fireflyCategories {
-		sbCatArray = append(sbCatArray, cc.Name)
+	// Interest, dividends, payroll and refunds
+	if detected, ok := c.detectIncome(ctx, transaction, fireflyCategories); ok && c.uses(sourceDetector) {
+		return detected
 	}
-	sbCategories.WriteString(strings.Join(sbCatArray, ", "))
 
-	if len(expenseAccounts) == 0 || len(fireflyCategories) == 0 {
-		log.Warn().Msg("No expense accounts or categories available for AI categorization")
+	// Learned from the transactions already categorized in Firefly
+	if p, ok := c.learner.Predict(transaction); ok && c.uses(sourceLearned) {
+		logging.Ctx(ctx).Info().Str("event", "categorization.found").Str("Source", sourceLearned).Float64("Confidence", p.Confidence).Msgf("🧠 [Learned] Found Company (%s) and Category (%s) for transaction.", p.Merchant, p.Category)
+		extracted.Company = p.Merchant
+		extracted.Category = c.findCategoryID(ctx, p.Category, fireflyCategories)
+		extracted.Confidence = p.Confidence
+		extracted.Source = sourceLearned
+		return extracted
+	}
+	if c.learner.Only() {
 		return extracted
 	}
 
-	// Check to see if it's a bypassed transaction
-	for _, transBypasses := range c.TransactionBypassResponse {
-		for key, bypassResp := range transBypasses {
-			if strings.Contains(transaction.Description, key) {
-				if bypassResp.Skip {
-					extracted.Skip = true
-					return extracted
-				}
-				extracted.Company = bypassResp.Company
-				extracted.CompanyID = bypassResp.AssetID
-				extracted.Category = FindCategoryID(bypassResp.Category, fireflyCategories)
-				return extracted
-			}
+	// Like the nearest transactions already categorized in Firefly
+	if c.uses(sourceEmbedding) {
+		if p, ok := c.embedder.Predict(ctx, transaction); ok {
+			logging.Ctx(ctx).Info().Str("event", "categorization.found").Str("Source", sourceEmbedding).Float64("Similarity", p.Similarity).Msgf("🧭 [Embedding] Found Company (%s) and Category (%s) for transaction.", p.Merchant, p.Category)
+			extracted.Company = p.Merchant
+			extracted.Category = c.findCategoryID(ctx, p.Category, fireflyCategories)
+			extracted.Confidence = p.Similarity * p.Confidence
+			extracted.Source = sourceEmbedding
+			return extracted
 		}
 	}
 
-	// If no OpenAI API Key was provided, return default
-	if cli.OpenAIAPIKey == "" && (cli.AzureAIAPIKey == "" || cli.AzureEndpoint == "") {
-		log.Info().Msgf("No OpenAI API Key provided, using default")
+	// The batch and the AI provider pick the merchant from the accounts
+	if len(merchantAccounts) == 0 {
+		logging.Ctx(ctx).Warn().Str("event", "categorization.skipped").Msgf("No %s accounts available for AI categorization", accountType)
 		return extracted
 	}
 
-	// OpenAI / ChatGPT
-	// Keeping for now to test new String Builder prompt
-	// prompt := fmt.Sprintf("Given the following transaction %s Please respond with JSON using Merchant and Category. \"Merchant\" which is your best guess at the merchant the bank transaction stemmed from using the following list seperated by commas: %s . If a sutable merchant isn't found from the list you can choose your own. when the payment was made via a payment service like paypal only show the merchant name not the payment service used. \"Category\" a general business accounting category you would expect this sort of transaction to be categorized to from the following list seperated by commas: %s . Choose the best category that fits this transaction. Choose only one merchant and category. Do not respond in anything other than JSON, No English unless in JSON format.", transaction.Description, sbAccounts.String(), sbCategories.String())
-	//  transaction.Description, sbAccounts.String(), sbCategories.String())
-	var prompt strings.Builder
-	prompt.WriteString("I want to categorize transactions on my bank account. Given the following transaction: ")
-	prompt.WriteString(transaction.Description)
-	prompt.WriteString("\n\n\"Merchant\" which is your best guess at the merchant the bank transaction stemmed from using the following list: ")
-	prompt.WriteString(sbAccounts.String())
-	prompt.WriteString("\nIf a suitable merchant isn't found from the list, you can choose your own. When the payment was made via a payment service like PayPal only show the merchant name, not the payment service used. \"Category\" a general business accounting category, Please choose a category that this transaction would fall under from the following list: ")
-	prompt.WriteString(sbCategories.String())
-	prompt.WriteString("\nChoose the best category that fits this transaction. Choose only one merchant and category. Please respond only in JSON, do not respond in anything other than JSON, No English unless in JSON format.")
-
-	var modifiedResp string
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	// GPT3Dot5TurboInstruct
-	if cli.OpenAIModel == openai.GPT3Dot5TurboInstruct {
-		req := openai.CompletionRequest{
-			Model:     cli.OpenAIModel,
-			Prompt:    prompt.String(),
-			MaxTokens: 256,
-		}
-		resp, err := oai.CreateCompletion(ctx, req)
-		if err != nil {
-			log.Error().Err(err).Msgf("Error with ChatGPT/OpenAI : %v", err)
-			return extracted
+	// Categorized ahead of time in a batch
+	if result, ok := c.takePrefetched(transaction.ID); ok {
+		return result
+	}
+
+	// Answered before, or corrected in review?
+	if c.uses(sourceCache) && c.cache != nil {
+		if cached, ok := c.lookupCache(ctx, transaction, accountID, fireflyCategories); ok {
+			return cached
 		}
+		prom.CategorizationCache.Misses.Add(1)
+	}
 
-		modifiedResp = resp.Choices[0].Text
-	} else {
-		// New
-		resp, err := oai.CreateChatCompletion(
-			context.Background(),
-			openai.ChatCompletionRequest{
-				Model: cli.OpenAIModel,
-				Messages: []openai.ChatCompletionMessage{
-					{
-						Role:    openai.ChatMessageRoleAssistant,
-						Content: prompt.String(),
-					},
-				},
-			},
-		)
+	// If no OpenAI API Key was provided (or the provider was refused), return default
+	if oai == nil {
+		logging.Ctx(ctx).Info().Str("event", "categorization.skipped").Msgf("No OpenAI API Key provided, using default")
+		return extracted
+	}
+	if !c.uses(sourceAI) {
+		return extracted
+	}
 
-		if err != nil {
-			log.Error().Err(err).Msgf("Error with ChatGPT/OpenAI chat request")
-			return extracted
-		}
+	// Monthly budget used up
+	if c.overBudget() {
+		prom.AI.Failures.Add(failureBudget, 1)
+		return extracted
+	}
 
-		if len(resp.Choices) != 1 {
-			log.Error().Msgf("Unexpected number of choices %v", resp.Choices)
-			return extracted
-		}
+	// OpenAI / ChatGPT
+	messages, err := c.transactionMessages(c.promptData(transaction, accountID, merchantAccounts, fireflyCategories))
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Str("event", "categorization.failed").Msg("Error rendering the categorization prompt")
+		return extracted
+	}
+
+	resp, err := c.createChatCompletion(ctx, openai.ChatCompletionRequest{
+		Messages:    messages,
+		MaxTokens:   c.maxTokens(defaultMaxTokens),
+		Temperature: c.temperature(),
+	}, c.timeout(defaultAITimeout))
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Str("event", "categorization.failed").Msgf("Error with ChatGPT/OpenAI chat request")
+		return extracted
+	}
 
-		modifiedResp = resp.Choices[0].Message.Content
+	if len(resp.Choices) != 1 {
+		logging.Ctx(ctx).Error().Str("event", "categorization.failed").Msgf("Unexpected number of choices %v", resp.Choices)
+		return extracted
 	}
 
+	modifiedResp := resp.Choices[0].Message.Content
+
 	// Split the text by semicolon to get Company and Category
 	var rsp OpenAIResponse
 
@@ -159,17 +243,148 @@ func ExtractCompanyAndCategory(ff *firefly.Firefly, c *config.MasterConfig, oai
 	// Try to unmarshal the response into the rsp (OpenAIResponse)
 	err = json.Unmarshal([]byte(modifiedResp), &rsp)
 	if err != nil {
-		log.Warn().Err(err).Msgf("ChatGPT responded with invalid JSON response.")
+		prom.AI.Failures.Add(failureInvalidJSON, 1)
+		logging.Ctx(ctx).Warn().Err(err).Str("event", "categorization.failed").Msgf("ChatGPT responded with invalid JSON response.")
 		return extracted
 	}
 
 	// Unmarshal was successful, ChatGPT returned a valid response
-	log.Info().Msgf("🤖 [ChatGPT] Successfully found Company (%s) and Category (%s) for transaction.", rsp.Merchant, rsp.Category)
+	logging.Ctx(ctx).Info().Str("event", "categorization.found").Str("Source", sourceAI).Msgf("🤖 [ChatGPT] Successfully found Company (%s) and Category (%s) for transaction.", rsp.Merchant, rsp.Category)
 	extracted.Company = rsp.Merchant
-	extracted.Category = FindCategoryID(rsp.Category, fireflyCategories)
+	extracted.Category = c.findCategoryID(ctx, rsp.Category, fireflyCategories)
+	extracted.Confidence = rateConfidence(rsp.Confidence)
+	extracted.Source = sourceAI
+
+	c.storeCache(transaction, accountID, extracted)
 	return extracted
 }
 
+// lookupCache returns the cached categorization of a transaction, if any. The cache is cleared first
+// if the Firefly categories changed since it was filled. Misses are counted by ExtractCompanyAndCategory, so a
+// transaction Prefetch already looked up isn't counted twice.
+func (c *Categorizer) lookupCache(ctx context.Context, transaction simplefin.Transactions, accountID string, categories []firefly.Category) (ExtractedData, bool) {
+	if c.cache == nil {
+		return ExtractedData{}, false
+	}
+
+	c.cache.CheckCategories(catcache.Fingerprint(categories))
+	key := c.cache.Key(transaction.Description, transaction.Amount, accountID)
+	entry, ok := c.cache.Get(key)
+	if !ok {
+		return ExtractedData{}, false
+	}
+
+	prom.CategorizationCache.Hits.Add(1)
+	logging.Ctx(ctx).Debug().Str("event", "categorization.found").Str("Source", sourceCache).Str("Key", key).Str("Merchant", entry.Merchant).Msg("Categorization cache hit")
+	return ExtractedData{
+		Company:    entry.Merchant,
+		CompanyID:  entry.MerchantID,
+		Category:   entry.CategoryID,
+		Confidence: rateConfidence(entry.Confidence),
+		Source:     sourceCache,
+	}, true
+}
+
+// storeCache remembers an AI categorization of a transaction.
+func (c *Categorizer) storeCache(transaction simplefin.Transactions, accountID string, extracted ExtractedData) {
+	if c.cache == nil || c.dryRun || extracted.Company == "" || extracted.Company == defaultAccountName {
+		return
+	}
+
+	c.cache.Put(catcache.Entry{
+		Key:         c.cache.Key(transaction.Description, transaction.Amount, accountID),
+		Description: transaction.Description,
+		Merchant:    extracted.Company,
+		MerchantID:  extracted.CompanyID,
+		CategoryID:  extracted.Category,
+		Provider:    aiProvider(),
+		Model:       c.model(),
+		Confidence:  extracted.Confidence,
+	})
+}
+
+// Learn feeds a categorization approved or corrected by hand back into the cache and the learned categorizer, so
+// the same description is categorized that way from now on.
+func (c *Categorizer) Learn(ctx context.Context, description string, amount decimal.Decimal, accountID, merchant, category string) {
+	if c.cache != nil {
+		categories, err := c.firefly.CachedCategories()
+		if err != nil {
+			logging.Ctx(ctx).Error().Err(err).Str("event", "categorization.failed").Msg("Error getting cached categories")
+		} else {
+			c.cache.Put(catcache.Entry{
+				Key:         c.cache.Key(description, amount, accountID),
+				Description: description,
+				Merchant:    merchant,
+				CategoryID:  c.findCategoryID(ctx, category, categories),
+				Provider:    sourceReview,
+				Confidence:  1,
+			})
+		}
+	}
+
+	dir := direction(amount.IsPositive())
+	c.learner.Learn(dir, learn.Example{Description: description, Merchant: merchant, Category: category})
+	c.embedder.Learn(description, dir, merchant, category)
+}
+
+// rateConfidence clamps a confidence reported by the AI provider between 0 and 1, using unratedConfidence when none
+// was given.
+func rateConfidence(confidence float64) float64 {
+	if confidence <= 0 {
+		return unratedConfidence
+	}
+	return min(confidence, 1)
+}
+
+// matchBypass returns the first transactionBypass rule whose key is contained in the description.
+func matchBypass(cfg *config.MasterConfig, transaction simplefin.Transactions) (config.TransactionInfo, bool) {
+	for _, transBypasses := range cfg.TransactionBypassResponse {
+		for key, bypassResp := range transBypasses {
+			if descriptionContains(transaction, key) {
+				return bypassResp, true
+			}
+		}
+	}
+	return config.TransactionInfo{}, false
+}
+
+// aiProvider names the AI provider in use.
+func aiProvider() string {
+	if cli.AzureAIAPIKey != "" && cli.AzureEndpoint != "" {
+		return "azure"
+	}
+	return "openai"
+}
+
+// findCategoryID returns the ID of the Firefly category named categoryName. Names that aren't exactly a category
+// are matched fuzzily (see the match package), and the mapping is logged.
+func (c *Categorizer) findCategoryID(ctx context.Context, categoryName string, categories []firefly.Category) string {
+	if id := FindCategoryID(categoryName, categories); id != "" || categoryName == "" {
+		return id
+	}
+
+	names := make([]string, len(categories))
+	for i, cat := range categories {
+		names[i] = cat.Name
+	}
+	result, ok := match.Best(categoryName, names, c.config.Matching.Synonyms, c.matchThreshold())
+	if !ok {
+		logging.Ctx(ctx).Info().Str("event", "category.unmatched").Str("Category", categoryName).Float64("Score", result.Score).Msg("No Firefly category close enough, leaving the category blank")
+		return ""
+	}
+
+	logging.Ctx(ctx).Info().Str("event", "category.matched").Str("Category", categoryName).Str("Matched", result.Name).Str("Reason", result.Reason).Float64("Score", result.Score).Msg("Matched category")
+	return strconv.Itoa(categories[result.Index].ID)
+}
+
+// matchThreshold returns the configured fuzzy matching threshold.
+func (c *Categorizer) matchThreshold() float64 {
+	if c.config.Matching.Threshold == 0 {
+		return defaultMatchThreshold
+	}
+	return c.config.Matching.Threshold
+}
+
 // FindCategoryID searches for a category by name in a list of firefly.Category and returns its ID as a string.
 // The function removes emojis and leading spaces from both input and category names before comparison.
 // Returns an empty string if no match is found.
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/firefly"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/logging"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/prom"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/simplefin"
+	"github.com/rs/zerolog/log"
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+const (
+	defaultBatchSize = 20
+	newMerchant      = "__new__" // Merchant value the model picks when none of the listed merchants fit
+	maxMerchantEnum  = 500       // Above this many merchants, the list goes in the prompt instead of the schema
+)
+
+// batchItem is a transaction waiting to be categorized in a batch.
+type batchItem struct {
+	Transaction simplefin.Transactions
+	AccountID   string
+}
+
+// batchResponse is the structured reply to a batch request.
+type batchResponse struct {
+	Results []batchResult `json:"results"`
+}
+
+// batchResult is the categorization of one transaction in a batch.
+type batchResult struct {
+	ID          string  `json:"id"`
+	Merchant    string  `json:"merchant"`
+	NewMerchant string  `json:"new_merchant"`
+	Category    string  `json:"category"`
+	Confidence  float64 `json:"confidence"`
+}
+
+// takePrefetched returns and forgets the batch result for a transaction.
+func (c *Categorizer) takePrefetched(transactionID string) (ExtractedData, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result, ok := c.prefetched[transactionID]
+	if ok {
+		delete(c.prefetched, transactionID)
+	}
+	return result, ok
+}
+
+// setPrefetched keeps a batch result until the transaction is synced.
+func (c *Categorizer) setPrefetched(transactionID string, result ExtractedData) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prefetched[transactionID] = result
+}
+
+// logBatching warns once at startup when batching is off because the model can only complete text, as the
+// default OPENAI_MODEL does.
+func (c *Categorizer) logBatching() {
+	if c.oai != nil && c.model() == openai.GPT3Dot5TurboInstruct {
+		log.Warn().Str("Model", c.model()).Msg("Batch categorization needs a chat model, categorizing one transaction per request. Set OPENAI_MODEL to a chat model such as gpt-4o-mini to batch them")
+	}
+}
+
+// batchSize returns the configured batch size, or 0 if batching isn't possible.
+func (c *Categorizer) batchSize() int {
+	if c.oai == nil || c.model() == openai.GPT3Dot5TurboInstruct {
+		return 0
+	}
+	if cli.OpenAIAPIKey == "" && (cli.AzureAIAPIKey == "" || cli.AzureEndpoint == "") {
+		return 0
+	}
+
+	size := c.config.Categorization.BatchSize
+	if size == 0 {
+		size = defaultBatchSize
+	}
+	if size < 2 {
+		return 0
+	}
+	return size
+}
+
+// Prefetch categorizes transactions in batches ahead of the sync. Results are handed out by
+// ExtractCompanyAndCategory; anything that could not be categorized here falls back to a request of its own.
+// Results left over from the previous sync, for transactions that were never synced, are dropped.
+func (c *Categorizer) Prefetch(ctx context.Context, items []batchItem) {
+	c.mu.Lock()
+	c.prefetched = make(map[string]ExtractedData)
+	c.mu.Unlock()
+
+	size := c.batchSize()
+	if size == 0 || len(items) == 0 || c.learner.Only() {
+		return
+	}
+	if c.overBudget() {
+		prom.AI.Failures.Add(failureBudget, 1)
+		return
+	}
+
+	categories, err := c.firefly.CachedCategories()
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Str("event", "categorization.failed").Msg("Error getting cached categories")
+		return
+	}
+	if len(categories) == 0 {
+		return
+	}
+
+	// Bypassed, detected, learned, embedded and cached transactions never reach the AI provider
+	if c.uses(sourceEmbedding) {
+		descriptions := make([]string, len(items))
+		for i, item := range items {
+			descriptions[i] = item.Transaction.Description
+		}
+		c.embedder.Prepare(ctx, descriptions)
+	}
+	var withdrawals, deposits []batchItem
+	seen := make(map[string]bool)
+	for _, item := range items {
+		trans := item.Transaction
+		if trans.Description == "" || seen[trans.ID] {
+			continue
+		}
+		seen[trans.ID] = true
+		if _, ok := matchBypass(c.config, trans); ok && c.uses(sourceRule) {
+			continue
+		}
+		if _, ok := c.detectIncome(ctx, trans, categories); ok && c.uses(sourceDetector) {
+			continue
+		}
+		if _, ok := c.learner.Predict(trans); ok && c.uses(sourceLearned) {
+			continue
+		}
+		if c.uses(sourceEmbedding) {
+			if _, ok := c.embedder.Predict(ctx, trans); ok {
+				continue
+			}
+		}
+		if c.uses(sourceCache) {
+			if cached, ok := c.lookupCache(ctx, trans, item.AccountID, categories); ok {
+				c.setPrefetched(trans.ID, cached)
+				continue
+			}
+		}
+		if isDeposit(trans) {
+			deposits = append(deposits, item)
+		} else {
+			withdrawals = append(withdrawals, item)
+		}
+	}
+
+	// Deposits and withdrawals choose from different merchants and categories, so they are batched apart
+	c.prefetchBatches(ctx, withdrawals, "expense", size, categories)
+	c.prefetchBatches(ctx, deposits, "revenue", size, categories)
+}
+
+// prefetchBatches sends pending transactions of one direction in batches of size, sending failed items again.
+func (c *Categorizer) prefetchBatches(ctx context.Context, pending []batchItem, accountType string, size int, categories []firefly.Category) {
+	if len(pending) == 0 {
+		return
+	}
+
+	merchants, err := c.merchantAccounts(accountType)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Str("event", "categorization.failed").Msg("Error getting cached accounts")
+		return
+	}
+	if len(merchants) == 0 {
+		return
+	}
+	offered := c.categoriesFor(pending[0].Transaction, categories)
+
+	retries := c.config.Categorization.BatchRetries
+	if retries == 0 {
+		retries = 1
+	}
+	for attempt := 0; attempt <= retries && len(pending) > 0; attempt++ {
+		var failed []batchItem
+		for start := 0; start < len(pending); start += size {
+			chunk := pending[start:min(start+size, len(pending))]
+			results, err := c.categorizeBatch(ctx, chunk, merchants, offered)
+			if err != nil {
+				logging.Ctx(ctx).Warn().Err(err).Str("event", "categorization.failed").Int("Transactions", len(chunk)).Msg("Batch categorization failed")
+			}
+
+			for _, item := range chunk {
+				result, ok := results[item.Transaction.ID]
+				if !ok {
+					failed = append(failed, item)
+					continue
+				}
+				extracted := ExtractedData{
+					Company:    result.Merchant,
+					Category:   c.findCategoryID(ctx, result.Category, categories),
+					Confidence: rateConfidence(result.Confidence),
+					Source:     sourceAI,
+				}
+				if result.Merchant == newMerchant {
+					extracted.Company = strings.TrimSpace(result.NewMerchant)
+				}
+				if extracted.Company == "" {
+					extracted.Company = defaultAccountName
+				}
+				c.setPrefetched(item.Transaction.ID, extracted)
+				c.storeCache(item.Transaction, item.AccountID, extracted)
+			}
+		}
+		pending = failed
+	}
+
+	if len(pending) > 0 {
+		logging.Ctx(ctx).Warn().Int("Transactions", len(pending)).Msg("Some transactions could not be categorized in a batch, categorizing them one at a time")
+	}
+}
+
+// categorizeBatch asks the AI provider to categorize a group of transactions at once. The reply is constrained
+// by a JSON schema, so merchants and categories can only be chosen from the Firefly lists.
+// Results are keyed by SimpleFIN transaction ID; transactions missing from the reply are left out.
+func (c *Categorizer) categorizeBatch(ctx context.Context, items []batchItem, merchants []string, categories []firefly.Category) (map[string]batchResult, error) {
+	var ids []string
+	var categoryNames []string
+	for _, item := range items {
+		ids = append(ids, item.Transaction.ID)
+	}
+	for _, cat := range categories {
+		categoryNames = append(categoryNames, cat.Name)
+	}
+
+	merchantSchema := jsonschema.Definition{
+		Type:        jsonschema.String,
+		Description: "The merchant from the list, or " + newMerchant + " if none of them fit",
+	}
+
+	data := PromptData{Deposit: isDeposit(items[0].Transaction), NewMerchant: newMerchant, Categories: categoryNames, Examples: c.recentExamples(categories)}
+	if len(merchants) > maxMerchantEnum {
+		data.Merchants = merchants
+	} else {
+		merchantSchema.Enum = append(append([]string{}, merchants...), newMerchant)
+	}
+	system, err := render(c.prompts.System, data)
+	if err != nil {
+		return nil, err
+	}
+	instructions, err := render(c.prompts.Batch, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var transactions strings.Builder
+	for _, item := range items {
+		line, err := json.Marshal(map[string]string{
+			"id":          item.Transaction.ID,
+			"description": c.redact(item.Transaction.Description),
+			"amount":      item.Transaction.Amount.String(),
+			"date":        time.Unix(item.Transaction.TransactedAt, 0).Format(time.DateOnly),
+		})
+		if err != nil {
+			return nil, err
+		}
+		transactions.Write(line)
+		transactions.WriteString("\n")
+	}
+
+	schema := jsonschema.Definition{
+		Type: jsonschema.Object,
+		Properties: map[string]jsonschema.Definition{
+			"results": {
+				Type: jsonschema.Array,
+				Items: &jsonschema.Definition{
+					Type: jsonschema.Object,
+					Properties: map[string]jsonschema.Definition{
+						"id":           {Type: jsonschema.String, Enum: ids},
+						"merchant":     merchantSchema,
+						"new_merchant": {Type: jsonschema.String},
+						"category":     {Type: jsonschema.String, Enum: categoryNames},
+						"confidence":   {Type: jsonschema.Number},
+					},
+					Required:             []string{"id", "merchant", "new_merchant", "category", "confidence"},
+					AdditionalProperties: false,
+				},
+			},
+		},
+		Required:             []string{"results"},
+		AdditionalProperties: false,
+	}
+
+	resp, err := c.createChatCompletion(ctx, openai.ChatCompletionRequest{
+		MaxTokens:   c.aiOptions().MaxTokens * len(items), // Each result is about as long as a single reply
+		Temperature: c.temperature(),
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: system + "\n\n" + instructions},
+			{Role: openai.ChatMessageRoleUser, Content: transactions.String()},
+		},
+		ResponseFormat: &openai.ChatCompletionResponseFormat{
+			Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+			JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+				Name:   "transaction_categories",
+				Schema: &schema,
+				Strict: true,
+			},
+		},
+	}, c.timeout(defaultBatchTimeout))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Choices) != 1 {
+		return nil, fmt.Errorf("unexpected number of choices %d", len(resp.Choices))
+	}
+
+	var reply batchResponse
+	if err = json.Unmarshal([]byte(resp.Choices[0].Message.Content), &reply); err != nil {
+		prom.AI.Failures.Add(failureInvalidJSON, 1)
+		return nil, fmt.Errorf("invalid batch response: %w", err)
+	}
+
+	results := make(map[string]batchResult, len(reply.Results))
+	for _, r := range reply.Results {
+		results[r.ID] = r
+	}
+	logging.Ctx(ctx).Info().Str("event", "categorization.found").Str("Source", sourceAI).Int("Transactions", len(items)).Int("Categorized", len(results)).Msg("🤖 [ChatGPT] Categorized a batch of transactions")
+	return results, nil
+}
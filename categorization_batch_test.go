@@ -0,0 +1,98 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/catcache"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/config"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/eval"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/eval/evaltest"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/simplefin"
+	"github.com/sashabaranov/go-openai"
+	"github.com/shopspring/decimal"
+)
+
+func TestPrefetchBatch(t *testing.T) {
+	server := evaltest.NewServer(map[string]evaltest.Answer{
+		"NETFLIX":     {Merchant: "Netflix", Category: "Entertainment", Confidence: 0.9},
+		"BLUE BOTTLE": {Merchant: "Blue Bottle Coffee", Category: "Coffee", Confidence: 0.8},
+	})
+	defer server.Close()
+
+	key := cli.OpenAIAPIKey
+	cli.OpenAIAPIKey = "test"
+	defer func() { cli.OpenAIAPIKey = key }()
+
+	oaiConfig := openai.DefaultConfig("test")
+	oaiConfig.BaseURL = server.URL + "/v1"
+	cfg := &config.MasterConfig{}
+	ff := offlineFirefly(eval.Fixture{Categories: []string{"Entertainment", "Coffee"}, ExpenseAccounts: []string{"Netflix", "Shell"}})
+	c := NewCategorizer(ff, cfg, NewAIClient(cfg, openai.NewClientWithConfig(oaiConfig)), nil, nil, nil, nil, nil, nil)
+
+	items := []batchItem{
+		{Transaction: simplefin.Transactions{ID: "t1", Description: "NETFLIX.COM", Amount: decimal.NewFromInt(-15)}, AccountID: "1"},
+		{Transaction: simplefin.Transactions{ID: "t2", Description: "BLUE BOTTLE", Amount: decimal.NewFromInt(-5)}, AccountID: "1"},
+		{Transaction: simplefin.Transactions{ID: "t3", Description: "UNKNOWN STORE", Amount: decimal.NewFromInt(-9)}, AccountID: "1"},
+	}
+	c.Prefetch(t.Context(), items)
+
+	if got, ok := c.takePrefetched("t1"); !ok || got.Company != "Netflix" || got.Category != "1" || got.Source != sourceAI {
+		t.Fatalf("Got %+v, %v, wanted Netflix in category 1 from the batch", got, ok)
+	}
+	if got, ok := c.takePrefetched("t2"); !ok || got.Company != "Blue Bottle Coffee" || got.Category != "2" {
+		t.Fatalf("Got %+v, %v, wanted the new merchant Blue Bottle Coffee in category 2", got, ok)
+	}
+	if got, ok := c.takePrefetched("t3"); ok {
+		t.Fatalf("Got %+v, wanted a transaction missing from the reply to fall back to its own request", got)
+	}
+	// The missing transaction is sent again once before falling back
+	if server.Requests.Load() != 2 {
+		t.Fatalf("Got %d requests, wanted 2", server.Requests.Load())
+	}
+
+	c.Prefetch(t.Context(), items[:1])
+	c.Prefetch(t.Context(), nil)
+	if got, ok := c.takePrefetched("t1"); ok {
+		t.Fatalf("Got %+v, wanted results of a previous sync to be dropped", got)
+	}
+}
+
+func TestShadowPrefetchWithoutCache(t *testing.T) {
+	server := evaltest.NewServer(map[string]evaltest.Answer{
+		"NETFLIX": {Merchant: "Netflix", Category: "Entertainment", Confidence: 0.9},
+		"SHELL":   {Merchant: "Shell", Category: "Gas", Confidence: 0.9},
+	})
+	defer server.Close()
+
+	key := cli.OpenAIAPIKey
+	cli.OpenAIAPIKey = "test"
+	defer func() { cli.OpenAIAPIKey = key }()
+
+	oaiConfig := openai.DefaultConfig("test")
+	oaiConfig.BaseURL = server.URL + "/v1"
+	cfg := &config.MasterConfig{}
+	ff := offlineFirefly(eval.Fixture{Categories: []string{"Entertainment", "Gas"}, ExpenseAccounts: []string{"Netflix", "Shell"}})
+	cache, err := catcache.Open(filepath.Join(t.TempDir(), "cache.json"), catcache.Options{})
+	if err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+	c := NewCategorizer(ff, cfg, NewAIClient(cfg, openai.NewClientWithConfig(oaiConfig)), cache, nil, nil, nil, nil, nil)
+
+	netflix := simplefin.Transactions{ID: "t1", Description: "NETFLIX.COM", Amount: decimal.NewFromInt(-15)}
+	c.storeCache(netflix, "1", ExtractedData{Company: "Hulu", Category: "2", Source: sourceAI})
+
+	items := []batchItem{
+		{Transaction: netflix, AccountID: "1"},
+		{Transaction: simplefin.Transactions{ID: "t2", Description: "SHELL OIL", Amount: decimal.NewFromInt(-40)}, AccountID: "1"},
+	}
+	s := c.WithChain(config.ChainConfig{Steps: []string{sourceAI}})
+	s.Prefetch(t.Context(), items)
+
+	if got, ok := s.takePrefetched("t1"); !ok || got.Company != "Netflix" || got.Source != sourceAI {
+		t.Fatalf("Got %+v, %v, wanted the shadow chain to ask the AI provider instead of reading the cache", got, ok)
+	}
+	if server.Requests.Load() != 1 {
+		t.Fatalf("Got %d requests, wanted both transactions in one batch", server.Requests.Load())
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/catcache"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/config"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/duration"
+	"github.com/rs/zerolog/log"
+)
+
+// openCategorizationCache opens the categorization cache in the data directory, or returns nil if it is disabled.
+func openCategorizationCache(cfg *config.MasterConfig) *catcache.Cache {
+	if cfg.CategorizationCache.Disabled {
+		return nil
+	}
+
+	var ttl time.Duration
+	if cfg.CategorizationCache.TTL != "" {
+		var err error
+		ttl, err = duration.ParseDuration(cfg.CategorizationCache.TTL)
+		if err != nil {
+			log.Error().Err(err).Str("TTL", cfg.CategorizationCache.TTL).Msg("Invalid categorization cache TTL, entries will not expire")
+		}
+	}
+
+	cache, err := catcache.Open(filepath.Join(cli.DataPath, "categorization_cache.json"), catcache.Options{
+		TTL:       ttl,
+		ByAmount:  cfg.CategorizationCache.KeyByAmount,
+		ByAccount: cfg.CategorizationCache.KeyByAccount,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to load categorization cache, starting a new one")
+	}
+	return cache
+}
+
+// runCacheListCommand prints the cached categorizations matching filter to out.
+func runCacheListCommand(cache *catcache.Cache, filter string, out io.Writer) error {
+	if cache == nil {
+		return fmt.Errorf("the categorization cache is disabled")
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "KEY\tMERCHANT\tCATEGORY\tPROVIDER\tMODEL\tCREATED\tHITS")
+	for _, e := range cache.List(filter) {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\n", e.Key, e.Merchant, e.CategoryID, e.Provider, e.Model, e.Created.Format(time.DateOnly), e.Hits)
+	}
+	return w.Flush()
+}
+
+// runCachePurgeCommand removes the cached categorizations matching filter, optionally only those older than a duration,
+// and prints how many were removed to out.
+func runCachePurgeCommand(cache *catcache.Cache, filter string, all bool, olderThan string, out io.Writer) error {
+	if cache == nil {
+		return fmt.Errorf("the categorization cache is disabled")
+	}
+	if filter == "" && !all && olderThan == "" {
+		return fmt.Errorf("nothing to purge, give a filter, --older-than, or --all")
+	}
+
+	var before time.Time
+	if olderThan != "" {
+		d, err := duration.ParseDuration(olderThan)
+		if err != nil {
+			return err
+		}
+		before = time.Now().Add(-d)
+	}
+
+	_, err := fmt.Fprintf(out, "Removed %d cached categorizations\n", cache.Purge(filter, before))
+	return err
+}
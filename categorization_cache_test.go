@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/catcache"
+)
+
+func TestCacheCommands(t *testing.T) {
+	cache, err := catcache.Open(filepath.Join(t.TempDir(), "cache.json"), catcache.Options{})
+	if err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+	cache.Put(catcache.Entry{Key: "netflix.com", Merchant: "Netflix", CategoryID: "1"})
+	cache.Put(catcache.Entry{Key: "shell oil", Merchant: "Shell", CategoryID: "2"})
+
+	var out bytes.Buffer
+	if err = runCacheListCommand(cache, "netflix", &out); err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+	if got := out.String(); !strings.Contains(got, "Netflix") || strings.Contains(got, "Shell") {
+		t.Fatalf("Got\n%s\nwanted only the Netflix entry", got)
+	}
+
+	out.Reset()
+	if err = runCachePurgeCommand(cache, "shell", false, "", &out); err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+	if got := out.String(); got != "Removed 1 cached categorizations\n" {
+		t.Fatalf("Got %q, wanted one entry removed", got)
+	}
+	if err = runCachePurgeCommand(cache, "", false, "", &out); err == nil {
+		t.Fatalf("Got no error, wanted purging without a filter refused")
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/duration"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/eval"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/firefly"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/prom"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/simplefin"
+	"github.com/rs/zerolog/log"
+)
+
+// currentChain is the name of the chain the sync uses, every step.
+const currentChain = "current"
+
+// evalOptions are the flags of the eval command.
+type evalOptions struct {
+	Chains  []string // Chains to compare (default currentChain)
+	Sample  int      // Transactions to sample from Firefly
+	History string   // How far back to sample from
+	Seed    int64    // Seed of the sample
+	Fixture string   // Run offline against this fixture instead of Firefly
+	Record  string   // Save the sample as a fixture
+}
+
+// runEvalCommand runs categorizer chains over transactions already categorized in Firefly (or a recorded fixture)
+// with their merchant and category hidden, and reports how often each chain agreed, what it cost and how long it took.
+// Nothing is written to Firefly or the cache.
+func runEvalCommand(c *Categorizer, opts evalOptions, out io.Writer) error {
+	var fixture eval.Fixture
+	var err error
+	ff := c.firefly
+	if opts.Fixture != "" {
+		if fixture, err = eval.LoadFixture(opts.Fixture); err != nil {
+			return err
+		}
+		// Offline: the names to choose from come from the fixture
+		ff = offlineFirefly(fixture)
+	} else if fixture, err = sampleFixture(c.firefly, opts.History, opts.Sample, opts.Seed); err != nil {
+		return err
+	}
+	if opts.Record != "" {
+		if err = fixture.Save(opts.Record); err != nil {
+			return fmt.Errorf("could not record fixture: %w", err)
+		}
+		log.Info().Str("Path", opts.Record).Int("Transactions", len(fixture.Cases)).Msg("Recorded eval fixture")
+	}
+	if len(fixture.Cases) == 0 {
+		return fmt.Errorf("no categorized transactions to evaluate")
+	}
+
+	// Grade the categorizers on transactions they haven't seen: the cache is left out, and the learned categorizer
+	// and embedding index are rebuilt without the evaluated transactions
+	exclude := make(map[string]bool, len(fixture.Cases))
+	for _, cs := range fixture.Cases {
+		exclude[cs.ID] = true
+	}
+	learner, err := c.learner.holdout(exclude)
+	if err != nil {
+		log.Warn().Err(err).Msg("Could not train the learned categorizer without the evaluated transactions, leaving it out")
+	}
+	embedder, err := c.embedder.holdout(exclude)
+	if err != nil {
+		log.Warn().Err(err).Msg("Could not build the embedding index without the evaluated transactions, leaving it out")
+	}
+	c = NewCategorizer(ff, c.config, c.oai, nil, learner, embedder, c.aliases, c.usage, c.notifier)
+
+	chains := opts.Chains
+	if len(chains) == 0 {
+		chains = []string{currentChain}
+	}
+	categories, err := c.firefly.CachedCategories()
+	if err != nil {
+		return err
+	}
+	normalizer := buildNormalizer(c.config)
+
+	for i, name := range chains {
+		chain, ok := c.config.Chains[name]
+		if !ok && name != currentChain {
+			return fmt.Errorf("unknown chain %q", name)
+		}
+		cc := c.WithChain(chain)
+
+		predictions := make([]eval.Prediction, len(fixture.Cases))
+		for j, cs := range fixture.Cases {
+			trans := simplefin.Transactions{ID: "eval-" + cs.ID, Description: cs.Description, Amount: cs.Amount}
+			if date, err := time.Parse(time.DateOnly, cs.Date); err == nil {
+				trans.TransactedAt = date.Unix()
+			}
+			if normalizer != nil {
+				trans.RawDescription = trans.Description
+				trans.Description = normalizer.Clean(trans.Description)
+			}
+
+			tokens, cost, start := prom.AI.PromptTokens.Load()+prom.AI.CompletionTokens.Load(), prom.AI.Cost(), time.Now()
+			extracted := cc.CanonicalMerchant(context.Background(), cc.ExtractCompanyAndCategory(context.Background(), trans, ""), merchantAccountType(trans))
+			predictions[j] = eval.Prediction{
+				Merchant: extracted.Company,
+				Category: categoryName(extracted.Category, categories),
+				Source:   extracted.Source,
+				Latency:  time.Since(start),
+				Tokens:   int64(prom.AI.PromptTokens.Load() + prom.AI.CompletionTokens.Load() - tokens),
+				Cost:     prom.AI.Cost() - cost,
+			}
+		}
+
+		if i > 0 {
+			_, _ = fmt.Fprintln(out)
+		}
+		eval.Score(name, fixture.Cases, predictions).Write(out)
+	}
+	return nil
+}
+
+// sampleFixture picks up to n withdrawals and deposits from the last history that have a category and a merchant.
+func sampleFixture(ff *firefly.Firefly, history string, n int, seed int64) (eval.Fixture, error) {
+	var fixture eval.Fixture
+	d, err := duration.ParseDuration(history)
+	if err != nil {
+		return fixture, err
+	}
+
+	now := time.Now()
+	txns, err := ff.ListTransactions(context.Background(), firefly.TransactionsKey{
+		Start: now.Add(-d).Format(time.DateOnly),
+		End:   now.Format(time.DateOnly),
+	})
+	if err != nil {
+		return fixture, err
+	}
+
+	for _, group := range txns {
+		for _, t := range group.Attributes.Transactions {
+			cs := eval.Case{ID: t.JournalID, Description: t.Description, Category: t.CategoryName}
+			switch t.Type {
+			case "withdrawal":
+				cs.Merchant, cs.Amount = t.DestinationName, t.Amount.Abs().Neg()
+			case "deposit":
+				cs.Merchant, cs.Amount = t.SourceName, t.Amount.Abs()
+			default:
+				continue
+			}
+			if cs.Category == "" || cs.Merchant == "" || cs.Merchant == defaultAccountName {
+				continue
+			}
+			if date, err := time.Parse(time.RFC3339, t.Date); err == nil {
+				cs.Date = date.Format(time.DateOnly)
+			}
+			fixture.Cases = append(fixture.Cases, cs)
+		}
+	}
+	rand.New(rand.NewSource(seed)).Shuffle(len(fixture.Cases), func(i, j int) {
+		fixture.Cases[i], fixture.Cases[j] = fixture.Cases[j], fixture.Cases[i]
+	})
+	if n > 0 && len(fixture.Cases) > n {
+		fixture.Cases = fixture.Cases[:n]
+	}
+
+	categories, err := ff.CachedCategories()
+	if err != nil {
+		return fixture, err
+	}
+	for _, cat := range categories {
+		fixture.Categories = append(fixture.Categories, cat.Name)
+	}
+	accounts, err := ff.CachedAccounts()
+	if err != nil {
+		return fixture, err
+	}
+	for _, acct := range accounts.Accounts {
+		switch acct.Attributes.Type {
+		case "expense":
+			fixture.ExpenseAccounts = append(fixture.ExpenseAccounts, acct.Attributes.Name)
+		case "revenue":
+			fixture.RevenueAccounts = append(fixture.RevenueAccounts, acct.Attributes.Name)
+		}
+	}
+	return fixture, nil
+}
+
+// offlineFirefly returns a Firefly client that answers account and category lookups from a fixture. Anything else
+// fails.
+func offlineFirefly(fixture eval.Fixture) *firefly.Firefly {
+	var accounts []firefly.Account
+	for i, name := range fixture.ExpenseAccounts {
+		accounts = append(accounts, firefly.Account{ID: "expense-" + strconv.Itoa(i+1), Attributes: firefly.AccountAttributes{Name: name, Type: "expense"}})
+	}
+	for i, name := range fixture.RevenueAccounts {
+		accounts = append(accounts, firefly.Account{ID: "revenue-" + strconv.Itoa(i+1), Attributes: firefly.AccountAttributes{Name: name, Type: "revenue"}})
+	}
+	categories := make([]firefly.Category, len(fixture.Categories))
+	for i, name := range fixture.Categories {
+		categories[i] = firefly.Category{ID: i + 1, Name: name}
+	}
+
+	ff := firefly.New(&http.Client{Timeout: time.Second}, "", "")
+	ff.Preload(accounts, categories)
+	return ff
+}
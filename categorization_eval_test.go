@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/catcache"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/config"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/eval"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/eval/evaltest"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/firefly"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/simplefin"
+	"github.com/sashabaranov/go-openai"
+	"github.com/shopspring/decimal"
+)
+
+func TestRunEvalCommandOffline(t *testing.T) {
+	server := evaltest.NewServer(map[string]evaltest.Answer{
+		"NETFLIX":     {Merchant: "Netflix", Category: "Entertainment", Confidence: 0.9},
+		"SHELL OIL":   {Merchant: "Shell", Category: "Gas & Fuel", Confidence: 0.9},
+		"BLUE BOTTLE": {Merchant: "Blue Bottle Coffee", Category: "Entertainment", Confidence: 0.4},
+	})
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "fixture.json")
+	fixture := eval.Fixture{
+		Categories:      []string{"Entertainment", "Gas & Fuel", "Coffee"},
+		ExpenseAccounts: []string{"Netflix", "Shell", "Blue Bottle"},
+		Cases: []eval.Case{
+			{ID: "1", Description: "NETFLIX.COM", Amount: decimal.NewFromInt(-15), Merchant: "Netflix", Category: "Entertainment"},
+			{ID: "2", Description: "SHELL OIL 57442", Amount: decimal.NewFromInt(-40), Merchant: "Shell", Category: "Gas & Fuel"},
+			{ID: "3", Description: "BLUE BOTTLE", Amount: decimal.NewFromInt(-5), Merchant: "Blue Bottle", Category: "Coffee"},
+		},
+	}
+	if err := fixture.Save(path); err != nil {
+		t.Fatalf("Got error %v saving the fixture, wanted none", err)
+	}
+
+	oaiConfig := openai.DefaultConfig("test")
+	oaiConfig.BaseURL = server.URL + "/v1"
+	cfg := &config.MasterConfig{Chains: map[string]config.ChainConfig{"ai": {Steps: []string{sourceAI}, Model: "gpt-4o-mini"}}}
+	c := NewCategorizer(nil, cfg, NewAIClient(cfg, openai.NewClientWithConfig(oaiConfig)), nil, nil, nil, nil, nil, nil)
+
+	var out bytes.Buffer
+	if err := runEvalCommand(c, evalOptions{Chains: []string{"ai"}, Fixture: path}, &out); err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+	report := out.String()
+	for _, want := range []string{"Category accuracy: 66.7%", "Merchant accuracy: 66.7%", "Tokens: 330", "ai=3"} {
+		if !strings.Contains(report, want) {
+			t.Fatalf("Got report\n%s\nwanted it to contain %q", report, want)
+		}
+	}
+	if server.Requests.Load() != 3 {
+		t.Fatalf("Got %d requests, wanted 3", server.Requests.Load())
+	}
+
+	if err := runEvalCommand(c, evalOptions{Chains: []string{"missing"}, Fixture: path}, &out); err == nil {
+		t.Fatalf("Got no error for an unknown chain, wanted one")
+	}
+}
+
+func TestRunEvalCommandHoldsOut(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data":[` +
+			`{"id":"1","attributes":{"transactions":[{"type":"withdrawal","description":"NETFLIX.COM","amount":"15","category_name":"Entertainment","destination_name":"Netflix","transaction_journal_id":"1"}]}},` +
+			`{"id":"2","attributes":{"transactions":[{"type":"withdrawal","description":"SHELL OIL","amount":"40","category_name":"Gas & Fuel","destination_name":"Shell","transaction_journal_id":"2"}]}}` +
+			`],"meta":{"pagination":{"current_page":1,"total_pages":1}}}`))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "fixture.json")
+	fixture := eval.Fixture{
+		Categories:      []string{"Entertainment", "Gas & Fuel"},
+		ExpenseAccounts: []string{"Netflix", "Shell"},
+		Cases:           []eval.Case{{ID: "1", Description: "NETFLIX.COM", Amount: decimal.NewFromInt(-15), Merchant: "Netflix", Category: "Entertainment"}},
+	}
+	if err := fixture.Save(path); err != nil {
+		t.Fatalf("Got error %v saving the fixture, wanted none", err)
+	}
+
+	// The learner and the cache both know the evaluated transaction
+	cfg := &config.MasterConfig{Chains: map[string]config.ChainConfig{"local": {Steps: []string{sourceLearned, sourceCache}}}}
+	learner := &Learner{firefly: firefly.New(&http.Client{Timeout: time.Second}, "", server.URL), config: cfg}
+	if err := learner.Retrain(); err != nil {
+		t.Fatalf("Got error %v training, wanted none", err)
+	}
+	if p, ok := learner.Predict(simplefin.Transactions{Description: "NETFLIX.COM", Amount: decimal.NewFromInt(-15)}); !ok || p.Merchant != "Netflix" {
+		t.Fatalf("Got %+v, %v, wanted the learner to know the evaluated transaction", p, ok)
+	}
+	cache, _ := catcache.Open(filepath.Join(t.TempDir(), "cache.json"), catcache.Options{})
+	cache.Put(catcache.Entry{Key: cache.Key("NETFLIX.COM", decimal.NewFromInt(-15), ""), Merchant: "Netflix", CategoryID: "1"})
+	c := NewCategorizer(nil, cfg, nil, cache, learner, nil, nil, nil, nil)
+
+	var out bytes.Buffer
+	if err := runEvalCommand(c, evalOptions{Chains: []string{"local"}, Fixture: path}, &out); err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+	if report := out.String(); !strings.Contains(report, "Category accuracy: 0.0%") {
+		t.Fatalf("Got report\n%s\nwanted the evaluated transaction left out of the learner and cache", report)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/forPelevin/gomoji"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/firefly"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/income"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/logging"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/simplefin"
+	"github.com/rs/zerolog/log"
+)
+
+// detectorConfidence is the confidence given to deposits recognized by an income detector.
+const detectorConfidence = 0.9
+
+// defaultIncomeDetectors are the category and fallback payer of each kind of deposit, unless configured otherwise.
+var defaultIncomeDetectors = map[string]struct{ Category, Payer string }{
+	income.Interest:  {"Interest", "Interest"},
+	income.Dividends: {"Dividends", "Dividends"},
+	income.Payroll:   {"Salary", "Payroll"},
+	income.Refunds:   {"Refunds", "Refund"},
+}
+
+// isDeposit reports whether a SimpleFIN transaction puts money into the account.
+func isDeposit(trans simplefin.Transactions) bool {
+	return trans.Amount.IsPositive()
+}
+
+// merchantAccountType returns the Firefly account type a transaction's merchant is chosen from: revenue accounts
+// for deposits, expense accounts for withdrawals.
+func merchantAccountType(trans simplefin.Transactions) string {
+	if isDeposit(trans) {
+		return "revenue"
+	}
+	return "expense"
+}
+
+// merchantAccounts returns the names of the Firefly accounts of accountType, which are offered as merchants.
+func (c *Categorizer) merchantAccounts(accountType string) ([]string, error) {
+	accounts, err := c.firefly.CachedAccounts()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, aa := range accounts.Accounts {
+		if aa.Attributes.Type == accountType {
+			names = append(names, aa.Attributes.Name)
+		}
+	}
+	return names, nil
+}
+
+// categoriesFor returns the categories a transaction may be given. Deposits are limited to the configured
+// income categories, when there are any.
+func (c *Categorizer) categoriesFor(trans simplefin.Transactions, categories []firefly.Category) []firefly.Category {
+	if !isDeposit(trans) || len(c.config.Categorization.IncomeCategories) == 0 {
+		return categories
+	}
+
+	var allowed []firefly.Category
+	for _, cat := range categories {
+		for _, name := range c.config.Categorization.IncomeCategories {
+			if strings.TrimLeft(gomoji.RemoveEmojis(cat.Name), " ") == strings.TrimLeft(gomoji.RemoveEmojis(name), " ") {
+				allowed = append(allowed, cat)
+			}
+		}
+	}
+	if len(allowed) == 0 {
+		log.Warn().Strs("IncomeCategories", c.config.Categorization.IncomeCategories).Msg("None of the income categories exist in Firefly, offering all categories")
+		return categories
+	}
+	return allowed
+}
+
+// detectIncome categorizes deposits that are plainly interest, dividends, payroll or refunds without asking
+// anyone. The payer named in the description is used as the revenue account, or the detector's fallback payer.
+func (c *Categorizer) detectIncome(ctx context.Context, trans simplefin.Transactions, categories []firefly.Category) (ExtractedData, bool) {
+	if !isDeposit(trans) {
+		return ExtractedData{}, false
+	}
+
+	match, ok := income.Detect(trans.Description)
+	if !ok {
+		return ExtractedData{}, false
+	}
+
+	detector := defaultIncomeDetectors[match.Kind]
+	if configured, ok := c.config.Categorization.IncomeDetectors[match.Kind]; ok {
+		if configured.Disabled {
+			return ExtractedData{}, false
+		}
+		if configured.Category != "" {
+			detector.Category = configured.Category
+		}
+		if configured.Payer != "" {
+			detector.Payer = configured.Payer
+		}
+	}
+
+	payer := match.Payer
+	if payer == "" {
+		payer = detector.Payer
+	}
+
+	logging.Ctx(ctx).Info().Str("event", "categorization.found").Str("Source", sourceDetector).Str("Kind", match.Kind).Msgf("💵 [Detector] Found Company (%s) and Category (%s) for deposit.", payer, detector.Category)
+	return ExtractedData{
+		Company:    payer,
+		Category:   c.findCategoryID(ctx, detector.Category, categories),
+		Confidence: detectorConfidence,
+		Source:     sourceDetector,
+	}, true
+}
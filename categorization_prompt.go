@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/config"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/duration"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/firefly"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/normalize"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/simplefin"
+	"github.com/rs/zerolog/log"
+	"github.com/sashabaranov/go-openai"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	defaultPromptExamples = 5
+	defaultMaxTokens      = 256
+	defaultAITimeout      = 30 * time.Second
+	defaultBatchTimeout   = 90 * time.Second
+)
+
+//go:embed prompts/*.tmpl
+var builtinPrompts embed.FS
+
+// Prompts are the parsed prompt templates.
+type Prompts struct {
+	System      *template.Template
+	Transaction *template.Template
+	Batch       *template.Template
+}
+
+// PromptData is what the prompt templates are rendered with.
+type PromptData struct {
+	Description string
+	Amount      string
+	Date        string // YYYY-MM-DD
+	AccountName string // Firefly asset account
+	Deposit     bool
+	Merchants   []string // Firefly expense accounts, or revenue accounts for deposits. Empty in a batch when they are in the schema
+	Categories  []string
+	Examples    []PromptExample // Recent categorizations
+	NewMerchant string          // Merchant a batch reply uses when none of the listed merchants fit
+}
+
+// PromptExample is an earlier categorization shown to the model.
+type PromptExample struct {
+	Description string
+	Merchant    string
+	Category    string
+}
+
+// loadPrompts parses the configured prompt templates. Templates that aren't configured, or fail to load, use the
+// built-in ones.
+func loadPrompts(cfg config.PromptsConfig) *Prompts {
+	return &Prompts{
+		System:      parsePrompt("system", cfg.System),
+		Transaction: parsePrompt("transaction", cfg.Transaction),
+		Batch:       parsePrompt("batch", cfg.Batch),
+	}
+}
+
+// parsePrompt parses the template at path, or the built-in template name.
+func parsePrompt(name, path string) *template.Template {
+	if path != "" {
+		t, err := readPrompt(name, path)
+		if err == nil {
+			return t
+		}
+		log.Error().Err(err).Str("Path", path).Msgf("Unable to load the %s prompt, using the built-in one", name)
+	}
+
+	t, err := template.New(name).Funcs(promptFuncs).ParseFS(builtinPrompts, "prompts/"+name+".tmpl")
+	if err != nil {
+		panic(err) // Built-in templates are checked by the build
+	}
+	return t.Lookup(name + ".tmpl")
+}
+
+func readPrompt(name, path string) (*template.Template, error) {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(name).Funcs(promptFuncs).Parse(string(text))
+}
+
+var promptFuncs = template.FuncMap{"join": strings.Join}
+
+// render executes a prompt template.
+func render(t *template.Template, data PromptData) (string, error) {
+	var sb strings.Builder
+	if err := t.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("could not render the %s prompt: %w", t.Name(), err)
+	}
+	return strings.TrimSpace(sb.String()), nil
+}
+
+// promptData gathers what the templates may use about a transaction, with personal information redacted.
+func (c *Categorizer) promptData(transaction simplefin.Transactions, accountID string, merchants []string, categories []firefly.Category) PromptData {
+	data := PromptData{
+		Description: c.redact(transaction.Description),
+		Amount:      transaction.Amount.String(),
+		Deposit:     isDeposit(transaction),
+		Merchants:   merchants,
+		Examples:    c.recentExamples(categories),
+	}
+	if transaction.TransactedAt != 0 {
+		data.Date = time.Unix(transaction.TransactedAt, 0).Format(time.DateOnly)
+	}
+	for _, cat := range c.categoriesFor(transaction, categories) {
+		data.Categories = append(data.Categories, cat.Name)
+	}
+	if accounts, err := c.firefly.CachedAccounts(); err == nil {
+		data.AccountName = c.redact(accounts.AccountsByID[accountID].Attributes.Name)
+	}
+	return data
+}
+
+// recentExamples returns the most recently used categorizations in the cache, newest first, with personal
+// information redacted from their descriptions.
+func (c *Categorizer) recentExamples(categories []firefly.Category) []PromptExample {
+	n := c.config.Prompts.Examples
+	if n == 0 {
+		n = defaultPromptExamples
+	}
+	if c.cache == nil || n < 0 {
+		return nil
+	}
+
+	entries := c.cache.List("")
+	sort.Slice(entries, func(i, j int) bool { return entries[i].LastUsed.After(entries[j].LastUsed) })
+
+	var examples []PromptExample
+	for _, e := range entries {
+		if len(examples) == n {
+			break
+		}
+		category := categoryName(e.CategoryID, categories)
+		if category == "" {
+			continue
+		}
+		examples = append(examples, PromptExample{Description: c.redact(e.Description), Merchant: e.Merchant, Category: category})
+	}
+	return examples
+}
+
+// transactionMessages renders the system and user messages asking about a single transaction.
+func (c *Categorizer) transactionMessages(data PromptData) ([]openai.ChatCompletionMessage, error) {
+	system, err := render(c.prompts.System, data)
+	if err != nil {
+		return nil, err
+	}
+	user, err := render(c.prompts.Transaction, data)
+	if err != nil {
+		return nil, err
+	}
+	return []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: system},
+		{Role: openai.ChatMessageRoleUser, Content: user},
+	}, nil
+}
+
+// completionPrompt joins chat messages into one prompt, for models without chat support.
+func completionPrompt(messages []openai.ChatCompletionMessage) string {
+	parts := make([]string, len(messages))
+	for i, m := range messages {
+		parts[i] = m.Content
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// aiOptions returns the request options of the AI provider in use.
+func (c *Categorizer) aiOptions() config.AIOptions {
+	return providerOptions(c.config)
+}
+
+// providerOptions returns the request options of the AI provider in use.
+func providerOptions(cfg *config.MasterConfig) config.AIOptions {
+	if aiProvider() == "azure" {
+		return cfg.AI.Azure
+	}
+	return cfg.AI.OpenAI
+}
+
+// model returns the model requests are sent to first.
+func (c *Categorizer) model() string {
+	if c.modelOverride != "" {
+		return c.modelOverride
+	}
+	if m := c.oai.Model(); m != "" {
+		return m
+	}
+	return defaultModel(c.config)
+}
+
+// defaultModel returns the provider's model, used when no model list is configured.
+func defaultModel(cfg *config.MasterConfig) string {
+	if m := providerOptions(cfg).Model; m != "" {
+		return m
+	}
+	return cli.OpenAIModel
+}
+
+// temperature returns the configured sampling temperature, or 0 to leave it to the provider.
+// go-openai leaves out a temperature of 0, so an explicit 0 is sent as the smallest float instead.
+func (c *Categorizer) temperature() float32 {
+	t := c.aiOptions().Temperature
+	if t == nil {
+		return 0
+	}
+	if *t == 0 {
+		return math.SmallestNonzeroFloat32
+	}
+	return *t
+}
+
+// maxTokens returns the configured reply limit, or def.
+func (c *Categorizer) maxTokens(def int) int {
+	if n := c.aiOptions().MaxTokens; n > 0 {
+		return n
+	}
+	return def
+}
+
+// timeout returns the configured request timeout, or def.
+func (c *Categorizer) timeout(def time.Duration) time.Duration {
+	opts := c.aiOptions()
+	if opts.Timeout == "" {
+		return def
+	}
+	t, err := duration.ParseDuration(opts.Timeout)
+	if err != nil || t <= 0 {
+		log.Error().Err(err).Str("Timeout", opts.Timeout).Msg("Invalid AI request timeout, using the default")
+		return def
+	}
+	return t
+}
+
+// runCategorizeCommand categorizes a description the way a sync would and prints the result. With explain, the
+// prompt that would be sent is printed instead, and nothing is sent.
+func runCategorizeCommand(c *Categorizer, normalizer *normalize.Normalizer, description, amount, accountID string, explain bool, out io.Writer) error {
+	amt, err := decimal.NewFromString(amount)
+	if err != nil {
+		return fmt.Errorf("invalid amount %q: %w", amount, err)
+	}
+	trans := simplefin.Transactions{ID: "categorize", Description: description, Amount: amt, TransactedAt: time.Now().Unix()}
+	if normalizer != nil {
+		trans.RawDescription = description
+		trans.Description = normalizer.Clean(description)
+	}
+
+	if !explain {
+		extracted := c.ExtractCompanyAndCategory(context.Background(), trans, accountID)
+		categories, err := c.firefly.CachedCategories()
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(out, "Description: %s\nMerchant:    %s\nCategory:    %s\nConfidence:  %.2f\nSource:      %s\n",
+			trans.Description, extracted.Company, categoryName(extracted.Category, categories), extracted.Confidence, extracted.Source)
+		return nil
+	}
+
+	categories, err := c.firefly.CachedCategories()
+	if err != nil {
+		return err
+	}
+	merchants, err := c.merchantAccounts(merchantAccountType(trans))
+	if err != nil {
+		return err
+	}
+	messages, err := c.transactionMessages(c.promptData(trans, accountID, merchants, categories))
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(out, "Provider: %s\nModel:    %s\n", aiProvider(), c.model())
+	if c.model() == openai.GPT3Dot5TurboInstruct {
+		_, _ = fmt.Fprintf(out, "\n--- prompt ---\n%s\n", completionPrompt(messages))
+		return nil
+	}
+	for _, m := range messages {
+		_, _ = fmt.Fprintf(out, "\n--- %s ---\n%s\n", m.Role, m.Content)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/config"
+)
+
+func TestBuiltinPrompts(t *testing.T) {
+	prompts := loadPrompts(config.PromptsConfig{})
+	data := PromptData{
+		Description: "NETFLIX.COM",
+		Amount:      "-15.00",
+		Date:        "2024-05-01",
+		AccountName: "Checking",
+		Merchants:   []string{"Netflix", "Shell"},
+		Categories:  []string{"Entertainment", "Gas & Fuel"},
+		Examples:    []PromptExample{{Description: "SHELL OIL", Merchant: "Shell", Category: "Gas & Fuel"}},
+		NewMerchant: newMerchant,
+	}
+
+	for _, deposit := range []bool{false, true} {
+		data.Deposit = deposit
+		for _, tmpl := range []*template.Template{prompts.System, prompts.Transaction, prompts.Batch} {
+			got, err := render(tmpl, data)
+			if err != nil || got == "" {
+				t.Fatalf("Got %q, %v rendering the built-in %s prompt, wanted text", got, err, tmpl.Name())
+			}
+		}
+	}
+
+	got, _ := render(prompts.Transaction, data)
+	for _, want := range []string{"NETFLIX.COM", "-15.00 on 2024-05-01, account Checking", "Netflix, Shell", "Entertainment, Gas & Fuel", "SHELL OIL: Merchant Shell"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("Got transaction prompt\n%s\nwanted it to contain %q", got, want)
+		}
+	}
+	got, _ = render(prompts.Batch, data)
+	if !strings.Contains(got, newMerchant) || !strings.Contains(got, "deposits") {
+		t.Fatalf("Got batch prompt\n%s\nwanted it to mention %s and deposits", got, newMerchant)
+	}
+}
+
+func TestCustomPrompt(t *testing.T) {
+	dir := t.TempDir()
+	custom := filepath.Join(dir, "transaction.tmpl")
+	if err := os.WriteFile(custom, []byte(`Categorize {{.Description}} into one of {{join .Categories "|"}}`), 0o644); err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+	broken := filepath.Join(dir, "batch.tmpl")
+	if err := os.WriteFile(broken, []byte(`{{.Description`), 0o644); err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+
+	prompts := loadPrompts(config.PromptsConfig{Transaction: custom, Batch: broken, System: filepath.Join(dir, "missing.tmpl")})
+	data := PromptData{Description: "NETFLIX.COM", Categories: []string{"Entertainment", "Coffee"}}
+
+	got, err := render(prompts.Transaction, data)
+	if err != nil || got != "Categorize NETFLIX.COM into one of Entertainment|Coffee" {
+		t.Fatalf("Got %q, %v, wanted the custom template rendered", got, err)
+	}
+
+	// Templates that fail to load fall back to the built-in ones
+	builtin := loadPrompts(config.PromptsConfig{})
+	for _, pair := range [][2]*template.Template{{prompts.Batch, builtin.Batch}, {prompts.System, builtin.System}} {
+		got, _ := render(pair[0], data)
+		want, _ := render(pair[1], data)
+		if got != want {
+			t.Fatalf("Got %q, wanted the built-in %s prompt %q", got, pair[1].Name(), want)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/catcache"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/config"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/eval"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/simplefin"
+	"github.com/shopspring/decimal"
+)
+
+func TestDepositBypassWithoutRevenueAccounts(t *testing.T) {
+	cfg := &config.MasterConfig{TransactionBypassResponse: []map[string]config.TransactionInfo{
+		{"ACME PAYROLL": {Company: "Acme", Category: "Salary"}},
+	}}
+	ff := offlineFirefly(eval.Fixture{Categories: []string{"Salary", "Coffee"}, ExpenseAccounts: []string{"Shell"}})
+	c := NewCategorizer(ff, cfg, nil, nil, nil, nil, nil, nil, nil)
+
+	deposit := simplefin.Transactions{ID: "t1", Description: "ACME PAYROLL DIRECT DEP", Amount: decimal.NewFromInt(2000)}
+	got := c.ExtractCompanyAndCategory(t.Context(), deposit, "1")
+	if got.Company != "Acme" || got.Category != "1" || got.Source != sourceRule {
+		t.Fatalf("Got %+v, wanted the bypass rule's Acme in category 1 with no revenue accounts yet", got)
+	}
+}
+
+func TestCacheWithoutAIProvider(t *testing.T) {
+	ff := offlineFirefly(eval.Fixture{Categories: []string{"Entertainment", "Coffee"}, ExpenseAccounts: []string{"Netflix"}})
+	cache, err := catcache.Open(filepath.Join(t.TempDir(), "cache.json"), catcache.Options{})
+	if err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+	c := NewCategorizer(ff, &config.MasterConfig{}, nil, cache, nil, nil, nil, nil, nil)
+
+	trans := simplefin.Transactions{ID: "t1", Description: "NETFLIX.COM", Amount: decimal.NewFromInt(-15)}
+	c.Learn(t.Context(), trans.Description, trans.Amount, "1", "Netflix", "Entertainment")
+	got := c.ExtractCompanyAndCategory(t.Context(), trans, "1")
+	if got.Company != "Netflix" || got.Category != "1" || got.Source != sourceCache {
+		t.Fatalf("Got %+v, wanted the review correction from the cache without an AI provider", got)
+	}
+}
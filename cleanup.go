@@ -1,31 +1,35 @@
 package main
 
 import (
+	"context"
 	"time"
 
+	"github.com/helpcomp/firefly-iii-simplefin-importer/dedupe"
 	"github.com/helpcomp/firefly-iii-simplefin-importer/duration"
 	"github.com/helpcomp/firefly-iii-simplefin-importer/firefly"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/logging"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/prom"
 	"github.com/helpcomp/firefly-iii-simplefin-importer/simplefin"
-	"github.com/rs/zerolog/log"
 )
 
 // RemoveNonExistentTransactions removes Firefly transactions
 // that no longer exist in SimpleFin within a specified time frame.
-func RemoveNonExistentTransactions(ff *firefly.Firefly, accountsResponse simplefin.AccountsResponse) {
-	log.Debug().Msgf("Checking for non-existant transactions")
+// Manually entered transactions that were adopted by an import are never removed.
+func RemoveNonExistentTransactions(ctx context.Context, ff *firefly.Firefly, accountsResponse simplefin.AccountsResponse, duplicates *dedupe.Store) {
+	logging.Ctx(ctx).Debug().Str("event", "cleanup.started").Msgf("Checking for non-existant transactions")
 	t, err := duration.ParseDuration(cli.SimplefinLoopbackDuration)
 	if err != nil {
-		log.Error().Err(err).Msg("Error parsing Simplefin Loopback Duration")
+		logging.Ctx(ctx).Error().Err(err).Str("event", "cleanup.failed").Msg("Error parsing Simplefin Loopback Duration")
 		return
 	}
 
-	existing, err := ff.CachedTransactions(firefly.TransactionsKey{
+	existing, err := ff.CachedTransactions(ctx, firefly.TransactionsKey{
 		Start: time.Now().Add(-t).Format(time.DateOnly),
 		End:   time.Now().Format(time.DateOnly),
 	})
 
 	if err != nil {
-		log.Error().Err(err).Msg("Error getting cached transactions")
+		logging.Ctx(ctx).Error().Err(err).Str("event", "cleanup.failed").Msg("Error getting cached transactions")
 		return
 	}
 
@@ -44,7 +48,7 @@ func RemoveNonExistentTransactions(ff *firefly.Firefly, accountsResponse simplef
 
 			// If there is no ExternalID, skip the transaction
 			if fireflyTrans.ExternalID == "" {
-				log.Info().Msgf("Transaction %s (%s) doesn't exist in SimpleFin. Missing ExternalID; It was probably added manually, skipping removal.", fireflyTrans.Description, transAttrib.ID)
+				logging.Ctx(ctx).Info().Str("event", "transaction.kept").Msgf("Transaction %s (%s) doesn't exist in SimpleFin. Missing ExternalID; It was probably added manually, skipping removal.", fireflyTrans.Description, transAttrib.ID)
 				continue
 			}
 
@@ -53,19 +57,28 @@ func RemoveNonExistentTransactions(ff *firefly.Firefly, accountsResponse simplef
 				continue // Transaction exists
 			}
 
+			// The Transaction was entered by hand and only linked to SimpleFin, keep it
+			if duplicates != nil && duplicates.IsAdopted(fireflyTrans.ExternalID) {
+				logging.Ctx(ctx).Info().Str("event", "transaction.kept").Str("Type", "Transaction").Str("Description", fireflyTrans.Description).Str("ID", transAttrib.ID).Msg("Adopted manual transaction doesn't exist in SimpleFin, skipping removal.")
+				continue
+			}
+
 			// The Transaction was not found in SimpleFin. It needs to be deleted
 			if !cli.AutoRemoveTransactions {
 				// Auto Removal is turned off, alert only.
-				log.Info().Str("Type", "Transaction").Str("Description", fireflyTrans.Description).Str("ID", transAttrib.ID).Msg("Transaction doesn't exist in SimpleFin. [AutoRemove is Off]")
+				logging.Ctx(ctx).Info().Str("event", "transaction.missing").Str("Type", "Transaction").Str("Description", fireflyTrans.Description).Str("ID", transAttrib.ID).Bool("AutoRemove", false).Msg("Transaction doesn't exist in SimpleFin. [AutoRemove is Off]")
 				continue
 			}
 
 			// Auto Removal is enabled, proceed with removing the transaction.
-			log.Info().Str("Type", "Transaction").Str("Description", fireflyTrans.Description).Str("ID", transAttrib.ID).Msg("Transaction doesn't exist in SimpleFin. It will be removed.")
-			err = ff.DeleteTransaction(transAttrib.ID)
+			logging.Ctx(ctx).Info().Str("event", "transaction.missing").Str("Type", "Transaction").Str("Description", fireflyTrans.Description).Str("ID", transAttrib.ID).Bool("AutoRemove", true).Msg("Transaction doesn't exist in SimpleFin. It will be removed.")
+			err = ff.DeleteTransaction(ctx, transAttrib.ID)
 			if err != nil {
-				log.Error().Err(err).Msgf("Could not delete transaction %s (%s)", fireflyTrans.Description, transAttrib.ID)
+				logging.Ctx(ctx).Error().Err(err).Str("event", "transaction.failed").Str("Action", "delete").Str("ID", transAttrib.ID).Str("Description", fireflyTrans.Description).Msg("Could not delete transaction")
+				continue
 			}
+			countSync(ff, assetAccountID(fireflyTrans), prom.SyncDeleted)
+			logging.Ctx(ctx).Info().Str("event", "transaction.deleted").Str("ID", transAttrib.ID).Str("Description", fireflyTrans.Description).Msg("🗑️ Removed transaction")
 		}
 	}
 }
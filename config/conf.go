@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/go-yaml/yaml"
@@ -11,6 +12,236 @@ type MasterConfig struct {
 	Accounts                  map[string]string            `yaml:"accounts"`
 	NonAssetAccounts          map[string]string            `yaml:"non_asset_accounts"`
 	TransactionBypassResponse []map[string]TransactionInfo `yaml:"transactionBypass"`
+	Reconciliation            ReconciliationConfig         `yaml:"reconciliation"`
+	ManualMatching            ManualMatchingConfig         `yaml:"manual_matching"`
+	CategorizationCache       CategorizationCacheConfig    `yaml:"categorization_cache"`
+	Categorization            CategorizationConfig         `yaml:"categorization"`
+	LearnedCategorizer        LearnedCategorizerConfig     `yaml:"learned_categorizer"`
+	Review                    ReviewConfig                 `yaml:"review"`
+	Normalization             NormalizationConfig          `yaml:"normalization"`
+	MerchantAliases           []MerchantAlias              `yaml:"merchant_aliases"`
+	Matching                  MatchingConfig               `yaml:"matching"`
+	Prompts                   PromptsConfig                `yaml:"prompts"`
+	AI                        AIConfig                     `yaml:"ai"`
+	Redaction                 RedactionConfig              `yaml:"redaction"`
+	Chains                    map[string]ChainConfig       `yaml:"chains"`
+	Shadow                    ShadowConfig                 `yaml:"shadow"`
+	Embeddings                EmbeddingsConfig             `yaml:"embeddings"`
+	Notifications             NotificationsConfig          `yaml:"notifications"`
+	Institutions              InstitutionsConfig           `yaml:"institutions"`
+	Tracing                   TracingConfig                `yaml:"tracing"`
+}
+
+// TracingConfig exports OpenTelemetry traces of each sync run.
+type TracingConfig struct {
+	Exporter    string            `yaml:"exporter"`     // none, otlp, stdout or file (default none)
+	Endpoint    string            `yaml:"endpoint"`     // otlp: collector URL or host:port
+	Insecure    bool              `yaml:"insecure"`     // otlp: plain HTTP when the endpoint is host:port
+	Headers     map[string]string `yaml:"headers"`      // otlp
+	File        string            `yaml:"file"`         // file: path traces are appended to
+	SampleRatio float64           `yaml:"sample_ratio"` // Fraction of sync runs traced (default all)
+}
+
+// InstitutionsConfig tracks the health of the bank connections behind SimpleFIN.
+type InstitutionsConfig struct {
+	StaleAfter string `yaml:"stale_after"` // Balances older than this degrade their institution (default 2d)
+}
+
+// NotificationsConfig sends balance mismatches, bank errors and failed syncs or transactions to sinks.
+type NotificationsConfig struct {
+	Sinks        map[string]NotifySinkConfig     `yaml:"sinks"`         // By name
+	Routes       map[string][]string             `yaml:"routes"`        // Event type (or "*") to sink names
+	Templates    map[string]NotifyTemplateConfig `yaml:"templates"`     // By event type
+	DedupeWindow string                          `yaml:"dedupe_window"` // Repeats within it are dropped (default 1d, 0 = never)
+	QuietHours   QuietHoursConfig                `yaml:"quiet_hours"`
+}
+
+// NotifySinkConfig is one place notifications are sent. Which fields apply depends on Type.
+type NotifySinkConfig struct {
+	Type     string            `yaml:"type"`     // webhook, ntfy, gotify, email or command
+	URL      string            `yaml:"url"`      // webhook, ntfy and gotify
+	Headers  map[string]string `yaml:"headers"`  // webhook
+	Token    string            `yaml:"token"`    // ntfy and gotify
+	Priority int               `yaml:"priority"` // ntfy and gotify
+	SMTP     string            `yaml:"smtp"`     // email: host:port
+	Username string            `yaml:"username"` // email
+	Password string            `yaml:"password"` // email
+	From     string            `yaml:"from"`     // email
+	To       []string          `yaml:"to"`       // email
+	Command  string            `yaml:"command"`  // command: run with sh -c
+}
+
+// NotifyTemplateConfig overrides an event type's title and body, as text/template strings.
+type NotifyTemplateConfig struct {
+	Title string `yaml:"title"`
+	Body  string `yaml:"body"`
+}
+
+// QuietHoursConfig holds notifications back between Start and End (HH:MM, local time).
+type QuietHoursConfig struct {
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+}
+
+// EmbeddingsConfig categorizes transactions like the most similar ones already categorized in Firefly, compared by
+// description embeddings, so only new merchants are sent to the AI provider.
+type EmbeddingsConfig struct {
+	Enabled         bool    `yaml:"enabled"`
+	Model           string  `yaml:"model"`            // Embedding model (default text-embedding-3-small)
+	BaseURL         string  `yaml:"base_url"`         // OpenAI-compatible embeddings server (default the configured provider)
+	Threshold       float64 `yaml:"threshold"`        // Cosine similarity neighbours must reach (default 0.85)
+	Neighbours      int     `yaml:"neighbours"`       // Nearest transactions that vote on the merchant and category (default 5)
+	History         string  `yaml:"history"`          // How far back to index, e.g. 365d (default 365d)
+	RebuildInterval string  `yaml:"rebuild_interval"` // How often new Firefly transactions are indexed (default 1d)
+	Price           float64 `yaml:"price"`            // Dollars per million tokens, for cost accounting
+}
+
+// ShadowConfig runs a second categorizer chain next to the one in use, recording whether they agree.
+type ShadowConfig struct {
+	Chain         string `yaml:"chain"`         // Name of a chain under chains (empty = disabled)
+	Disagreements int    `yaml:"disagreements"` // Latest disagreements kept for the report (default 200)
+}
+
+// ChainConfig is a categorizer chain, a selection of categorization steps compared by the eval command.
+type ChainConfig struct {
+	Steps []string `yaml:"steps"` // rule, detector, learned, embedding, cache, ai (default all of them)
+	Model string   `yaml:"model"` // Model for the ai step (default the provider's)
+}
+
+// RedactionConfig controls what is removed from transaction data before it is sent to an AI provider.
+type RedactionConfig struct {
+	Disabled  bool     `yaml:"disabled"`
+	Patterns  []string `yaml:"patterns"`   // Extra regular expressions to redact, after the built-in ones
+	LocalOnly bool     `yaml:"local_only"` // Refuse AI providers that aren't on this machine or the local network
+}
+
+// PromptsConfig points at text/template files replacing the built-in prompts (see prompts/ for the defaults).
+type PromptsConfig struct {
+	System      string `yaml:"system"`      // System message, sent with every request
+	Transaction string `yaml:"transaction"` // Asks about a single transaction
+	Batch       string `yaml:"batch"`       // Instructions for a batch of transactions
+	Examples    int    `yaml:"examples"`    // Recent categorizations shown as examples (default 5, -1 = none)
+}
+
+// AIConfig holds the request options of each AI provider.
+type AIConfig struct {
+	OpenAI AIOptions       `yaml:"openai"`
+	Azure  AIOptions       `yaml:"azure"`
+	Budget AIBudgetConfig  `yaml:"budget"`
+	Models []AIModelConfig `yaml:"models"` // Tried in order until one answers (default the provider's model)
+	Limits AILimitsConfig  `yaml:"limits"`
+}
+
+// AIModelConfig is a model in the fallback list.
+type AIModelConfig struct {
+	Model   string `yaml:"model"`
+	BaseURL string `yaml:"base_url"` // OpenAI-compatible server, e.g. a local model (default the configured provider)
+
+	PromptPrice     float64 `yaml:"prompt_price"` // Dollars per million tokens (default the provider's prices)
+	CompletionPrice float64 `yaml:"completion_price"`
+}
+
+// AILimitsConfig protects the sync from a slow or failing AI provider. Each model has its own rate limit and
+// circuit breaker.
+type AILimitsConfig struct {
+	RequestsPerMinute float64 `yaml:"requests_per_minute"` // 0 = no limit
+	Burst             int     `yaml:"burst"`               // Requests sent at once before the rate limit applies (default 1)
+	Retries           int     `yaml:"retries"`             // On rate limits, server and network errors (default 2, -1 = none)
+	Backoff           string  `yaml:"backoff"`             // Before the first retry, doubled for each one after (default 1s)
+	BreakerFailures   int     `yaml:"breaker_failures"`    // Failed requests in a row before a model is skipped (default 5, -1 = never)
+	BreakerCooldown   string  `yaml:"breaker_cooldown"`    // How long a failing model is skipped (default 1m)
+}
+
+// AIBudgetConfig limits what is spent on the AI provider each calendar month. Once it is used up, categorization
+// falls back to everything but the AI provider until the next month.
+type AIBudgetConfig struct {
+	MonthlyTokens  int64   `yaml:"monthly_tokens"`  // Prompt plus completion tokens (0 = no limit)
+	MonthlyDollars float64 `yaml:"monthly_dollars"` // Estimated from prompt_price and completion_price (0 = no limit)
+}
+
+// AIOptions controls the requests sent to an AI provider.
+type AIOptions struct {
+	BaseURL     string   `yaml:"base_url"`    // OpenAI-compatible server, e.g. a local model (OpenAI only)
+	Model       string   `yaml:"model"`       // Model, or Azure deployment (default OPENAI_MODEL)
+	Temperature *float32 `yaml:"temperature"` // Sampling temperature (default the provider's)
+	MaxTokens   int      `yaml:"max_tokens"`  // Longest reply per transaction (default 256), times the batch size for batches (default no limit)
+	Timeout     string   `yaml:"timeout"`     // Per request, e.g. 30s (default 30s, 90s for batches)
+
+	PromptPrice     float64 `yaml:"prompt_price"`     // Dollars per million prompt tokens, for cost accounting
+	CompletionPrice float64 `yaml:"completion_price"` // Dollars per million completion tokens
+}
+
+// MatchingConfig controls how merchant and category names given by the AI provider are matched to Firefly names.
+type MatchingConfig struct {
+	Threshold float64             `yaml:"threshold"` // How close a name must be, from 0 to 1 (default 0.8)
+	Synonyms  map[string][]string `yaml:"synonyms"`  // Firefly category or account name -> other names that mean it
+}
+
+// MerchantAlias maps variant merchant names onto one Firefly account.
+type MerchantAlias struct {
+	AccountID string   `yaml:"account_id"` // Canonical Firefly expense (or revenue) account
+	Names     []string `yaml:"names"`      // Names that mean this account, compared ignoring case, punctuation and suffixes like .com
+}
+
+// NormalizationConfig controls how bank descriptions are cleaned up before categorization.
+type NormalizationConfig struct {
+	Disabled bool     `yaml:"disabled"`
+	Patterns []string `yaml:"patterns"` // Extra regular expressions to remove, applied after the built-in ones
+}
+
+// ReviewConfig controls which categorizations are queued for a person to check.
+type ReviewConfig struct {
+	Enabled   bool    `yaml:"enabled"`
+	Threshold float64 `yaml:"threshold"` // Categorizations less confident than this are reviewed (default 0.7)
+	Tag       string  `yaml:"tag"`       // Firefly tag for transactions waiting on review (default needs-review)
+}
+
+// LearnedCategorizerConfig controls the local categorizer trained on transactions already in Firefly.
+type LearnedCategorizerConfig struct {
+	Enabled         bool    `yaml:"enabled"`
+	Mode            string  `yaml:"mode"`             // "first" tries it before the AI provider, "only" never asks the AI provider (default first)
+	MinConfidence   float64 `yaml:"min_confidence"`   // Predictions below this are not used (default 0.8)
+	RetrainInterval string  `yaml:"retrain_interval"` // How often the model is retrained, e.g. 7d (default 1d)
+	History         string  `yaml:"history"`          // How far back to learn from, e.g. 365d (default 365d)
+}
+
+// CategorizationConfig controls how new transactions are sent to the AI provider.
+type CategorizationConfig struct {
+	BatchSize    int `yaml:"batch_size"`    // Transactions per request (default 20, 1 = one request per transaction)
+	BatchRetries int `yaml:"batch_retries"` // How many times items that failed in a batch are sent again (default 1)
+
+	IncomeCategories []string                  `yaml:"income_categories"` // Categories offered for deposits (empty = all)
+	IncomeDetectors  map[string]IncomeDetector `yaml:"income_detectors"`  // interest, dividends, payroll, refunds
+}
+
+// IncomeDetector sets what a recognized kind of deposit is categorized as.
+type IncomeDetector struct {
+	Disabled bool   `yaml:"disabled"`
+	Category string `yaml:"category"` // Category name
+	Payer    string `yaml:"payer"`    // Revenue account used when the description doesn't name the payer
+}
+
+// CategorizationCacheConfig controls the cache of AI categorization results.
+type CategorizationCacheConfig struct {
+	Disabled     bool   `yaml:"disabled"`
+	TTL          string `yaml:"ttl"`            // How long results are reused, e.g. 90d (empty = forever)
+	KeyByAmount  bool   `yaml:"key_by_amount"`  // Cache separately per amount bucket (direction and order of magnitude)
+	KeyByAccount bool   `yaml:"key_by_account"` // Cache separately per account
+}
+
+// ManualMatchingConfig controls matching imported transactions against transactions entered by hand in Firefly.
+type ManualMatchingConfig struct {
+	Enabled bool `yaml:"enabled"`
+	Days    int  `yaml:"days"`   // How many days apart the dates may be (default 3)
+	Enrich  bool `yaml:"enrich"` // Fill in a missing category on the adopted transaction
+}
+
+// ReconciliationConfig controls how account balances are reconciled against SimpleFIN.
+type ReconciliationConfig struct {
+	Tolerance      float64 `yaml:"tolerance"`       // Differences at or below this are treated as a match
+	MaxAdjustment  float64 `yaml:"max_adjustment"`  // Largest difference that is automatically adjusted (0 = no limit)
+	MarkReconciled *bool   `yaml:"mark_reconciled"` // Flag imported transactions as reconciled once the balance matches (default true)
+	HistorySize    int     `yaml:"history_size"`    // Entries kept per account (default 100)
 }
 
 type NonAssetAccountInfo struct {
@@ -30,19 +261,28 @@ type TransactionBypassResp struct {
 }
 
 func InitConfig(path string) *MasterConfig {
-	init := MasterConfig{}
-	init.getConf(path)
-	return &init
-}
-func (c *MasterConfig) getConf(file string) *MasterConfig {
-	yamlFile, err := os.ReadFile(file)
+	init, err := Load(path)
 	if err != nil {
-		log.Fatal().Err(err).Msgf("Error opening master config %s", file)
+		log.Fatal().Err(err).Msgf("Error loading master config %s", path)
 	}
+	return init
+}
 
-	err = yaml.Unmarshal(yamlFile, c)
+// Load reads the master config at path, returning an error instead of exiting when it can't be read or parsed.
+func Load(path string) (*MasterConfig, error) {
+	yamlFile, err := os.ReadFile(path)
 	if err != nil {
-		log.Fatal().Err(err).Msgf("Error reading master config %s", file)
+		return nil, fmt.Errorf("error opening master config: %w", err)
 	}
-	return c
+
+	var c MasterConfig
+	if err = yaml.Unmarshal(yamlFile, &c); err != nil {
+		return nil, fmt.Errorf("error reading master config: %w", err)
+	}
+	return &c, nil
+}
+
+// ShouldMarkReconciled reports whether matched transactions should be flagged as reconciled in Firefly.
+func (r ReconciliationConfig) ShouldMarkReconciled() bool {
+	return r.MarkReconciled == nil || *r.MarkReconciled
 }
@@ -0,0 +1,73 @@
+// Package dedupe matches imported transactions against transactions that were entered by hand in Firefly
+package dedupe
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/firefly"
+	"github.com/shopspring/decimal"
+)
+
+// Candidate is a manually entered Firefly transaction that may be the same as an imported one.
+type Candidate struct {
+	FireflyID    string          `json:"firefly_id"`
+	JournalID    string          `json:"journal_id"`
+	Description  string          `json:"description"`
+	Date         string          `json:"date"`
+	Amount       decimal.Decimal `json:"amount"`
+	CategoryName string          `json:"category_name,omitempty"`
+	DaysApart    int             `json:"days_apart"`
+}
+
+// FindCandidates returns the Firefly transactions without an external ID that move the same amount in the same
+// direction on the same asset account as trans, within days of its date. Closest dates come first.
+// Transaction groups listed in claimed were already adopted during this run and are ignored.
+func FindCandidates(existing []firefly.Transactions, accountID string, trans firefly.Transaction, days int, claimed map[string]bool) []Candidate {
+	date, err := time.Parse(time.DateOnly, trans.Date)
+	if err != nil {
+		return nil
+	}
+
+	var candidates []Candidate
+	for _, group := range existing {
+		if claimed[group.ID] {
+			continue
+		}
+		for _, old := range group.Attributes.Transactions {
+			if old.ExternalID != "" || old.Type != trans.Type || !old.Amount.Equal(trans.Amount) {
+				continue
+			}
+			if (trans.Type == "withdrawal" && old.SourceID != accountID) || (trans.Type == "deposit" && old.DestinationID != accountID) {
+				continue
+			}
+
+			oldDate, err := time.Parse(time.RFC3339, old.Date)
+			if err != nil {
+				continue
+			}
+			// Compare calendar days, Firefly dates carry the server's time zone
+			y, m, d := oldDate.Date()
+			apart := int(math.Abs(math.Round(time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Sub(date).Hours() / 24)))
+			if apart > days {
+				continue
+			}
+
+			candidates = append(candidates, Candidate{
+				FireflyID:    group.ID,
+				JournalID:    old.JournalID,
+				Description:  old.Description,
+				Date:         oldDate.Format(time.DateOnly),
+				Amount:       old.Amount,
+				CategoryName: old.CategoryName,
+				DaysApart:    apart,
+			})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].DaysApart < candidates[j].DaysApart
+	})
+	return candidates
+}
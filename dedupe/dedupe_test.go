@@ -0,0 +1,54 @@
+package dedupe_test
+
+import (
+	"testing"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/dedupe"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/firefly"
+	"github.com/shopspring/decimal"
+)
+
+func TestFindCandidates(t *testing.T) {
+	existing := []firefly.Transactions{
+		{ID: "1", Attributes: firefly.TransactionAttributes{Transactions: []firefly.Transaction{
+			{Type: "withdrawal", Date: "2025-03-09T00:00:00-05:00", Amount: decimal.RequireFromString("4.75"), SourceID: "25", Description: "Coffee"},
+		}}},
+		// Already imported
+		{ID: "2", Attributes: firefly.TransactionAttributes{Transactions: []firefly.Transaction{
+			{Type: "withdrawal", Date: "2025-03-10T00:00:00-05:00", Amount: decimal.RequireFromString("4.75"), SourceID: "25", ExternalID: "TRN-9"},
+		}}},
+		// Too far apart
+		{ID: "3", Attributes: firefly.TransactionAttributes{Transactions: []firefly.Transaction{
+			{Type: "withdrawal", Date: "2025-03-01T00:00:00-05:00", Amount: decimal.RequireFromString("4.75"), SourceID: "25"},
+		}}},
+		// Different account
+		{ID: "4", Attributes: firefly.TransactionAttributes{Transactions: []firefly.Transaction{
+			{Type: "withdrawal", Date: "2025-03-10T00:00:00-05:00", Amount: decimal.RequireFromString("4.75"), SourceID: "26"},
+		}}},
+		{ID: "5", Attributes: firefly.TransactionAttributes{Transactions: []firefly.Transaction{
+			{Type: "withdrawal", Date: "2025-03-10T00:00:00-05:00", Amount: decimal.RequireFromString("4.75"), SourceID: "25", Description: "Coffee again"},
+		}}},
+	}
+	trans := firefly.Transaction{
+		Type:     "withdrawal",
+		Date:     "2025-03-10",
+		Amount:   decimal.RequireFromString("4.75"),
+		SourceID: "25",
+	}
+
+	c := dedupe.FindCandidates(existing, "25", trans, 3, map[string]bool{})
+	if len(c) != 2 {
+		t.Fatalf("Got %d candidates, wanted 2", len(c))
+	}
+	if c[0].FireflyID != "5" || c[0].DaysApart != 0 {
+		t.Fatalf("Got candidate %s (%d days apart) first, wanted 5 (0 days apart)", c[0].FireflyID, c[0].DaysApart)
+	}
+	if c[1].FireflyID != "1" || c[1].DaysApart != 1 {
+		t.Fatalf("Got candidate %s (%d days apart) second, wanted 1 (1 day apart)", c[1].FireflyID, c[1].DaysApart)
+	}
+
+	c = dedupe.FindCandidates(existing, "25", trans, 3, map[string]bool{"5": true})
+	if len(c) != 1 || c[0].FireflyID != "1" {
+		t.Fatalf("Got %v, wanted only candidate 1 once 5 is claimed", c)
+	}
+}
@@ -0,0 +1,199 @@
+package dedupe
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/httperror"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/internal/jsonfile"
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+// Resolution actions for a reviewed transaction
+const (
+	ActionAdopt  = "adopt"  // Use the chosen manual transaction instead of importing
+	ActionImport = "import" // Not a duplicate, import it as a new transaction
+)
+
+// Review is an imported transaction with more than one possible manual match.
+type Review struct {
+	TransactionID string          `json:"transaction_id"`
+	AccountID     string          `json:"account_id"`
+	Description   string          `json:"description"`
+	Date          string          `json:"date"`
+	Amount        decimal.Decimal `json:"amount"`
+	Candidates    []Candidate     `json:"candidates"`
+	Created       time.Time       `json:"created"`
+}
+
+// Resolution is a decision made on a Review.
+type Resolution struct {
+	Action    string `json:"action"`
+	FireflyID string `json:"firefly_id,omitempty"`
+}
+
+// Adoption is a manual Firefly transaction an imported transaction was matched to.
+type Adoption struct {
+	FireflyID string    `json:"firefly_id"`
+	Adopted   time.Time `json:"adopted"`
+}
+
+// Store keeps the review list, resolved reviews, and which imported transactions adopted a manual one.
+type Store struct {
+	path        string
+	window      time.Duration
+	Reviews     map[string]Review     `json:"reviews"`
+	Resolutions map[string]Resolution `json:"resolutions"`
+	Adopted     map[string]Adoption   `json:"adopted"` // SimpleFIN transaction ID -> Firefly transaction
+	mu          sync.Mutex
+}
+
+// NewStore loads the review list and adoptions saved at path, starting with none if it doesn't exist yet.
+// Adoptions older than window are dropped when saving, as SimpleFIN no longer returns their transactions; a zero
+// window keeps them forever.
+func NewStore(path string, window time.Duration) (*Store, error) {
+	s := &Store{
+		path:        path,
+		window:      window,
+		Reviews:     make(map[string]Review),
+		Resolutions: make(map[string]Resolution),
+		Adopted:     make(map[string]Adoption),
+	}
+
+	if err := jsonfile.Load(path, s); err != nil {
+		return s, fmt.Errorf("could not load duplicate review list: %w", err)
+	}
+	return s, nil
+}
+
+// AddReview puts a transaction on the review list, replacing any previous review for it.
+func (s *Store) AddReview(r Review) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if old, ok := s.Reviews[r.TransactionID]; ok {
+		r.Created = old.Created
+	}
+	s.Reviews[r.TransactionID] = r
+	s.save()
+}
+
+// Resolution returns the decision made for a transaction, if any.
+func (s *Store) Resolution(transactionID string) (Resolution, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.Resolutions[transactionID]
+	return r, ok
+}
+
+// Resolve records a decision for a transaction on the review list. It is applied on the next sync.
+func (s *Store) Resolve(transactionID string, res Resolution) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	review, ok := s.Reviews[transactionID]
+	if !ok {
+		return fmt.Errorf("transaction %s is not on the review list", transactionID)
+	}
+
+	switch res.Action {
+	case ActionImport:
+	case ActionAdopt:
+		found := false
+		for _, c := range review.Candidates {
+			found = found || c.FireflyID == res.FireflyID
+		}
+		if !found {
+			return fmt.Errorf("firefly transaction %s is not a candidate for %s", res.FireflyID, transactionID)
+		}
+	default:
+		return fmt.Errorf("unknown action %q", res.Action)
+	}
+
+	s.Resolutions[transactionID] = res
+	s.save()
+	return nil
+}
+
+// Adopt records that an imported transaction now lives on a manual Firefly transaction, and clears its review.
+func (s *Store) Adopt(transactionID, fireflyID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Adopted[transactionID] = Adoption{FireflyID: fireflyID, Adopted: time.Now()}
+	delete(s.Reviews, transactionID)
+	delete(s.Resolutions, transactionID)
+	s.save()
+}
+
+// Imported clears the review of a transaction that was imported after all.
+func (s *Store) Imported(transactionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.Reviews[transactionID]; !ok {
+		return
+	}
+	delete(s.Reviews, transactionID)
+	delete(s.Resolutions, transactionID)
+	s.save()
+}
+
+// IsAdopted reports whether an imported transaction was matched to a manual one. Adopted transactions keep the
+// details entered by hand and are never overwritten by an update.
+func (s *Store) IsAdopted(transactionID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.Adopted[transactionID]
+	return ok
+}
+
+// save drops adoptions older than the window and persists the store; s.mu must be held.
+func (s *Store) save() {
+	if s.window > 0 {
+		cutoff := time.Now().Add(-s.window)
+		for id, a := range s.Adopted {
+			if a.Adopted.Before(cutoff) {
+				delete(s.Adopted, id)
+			}
+		}
+	}
+	if err := jsonfile.Save(s.path, s); err != nil {
+		log.Error().Err(err).Msg("Could not save duplicate review list")
+	}
+}
+
+// HandleReviews lists the review list (GET) or resolves an entry (POST with id, action and firefly_id).
+func (s *Store) HandleReviews(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET":
+		s.mu.Lock()
+		reviews := make([]Review, 0, len(s.Reviews))
+		for _, r := range s.Reviews {
+			reviews = append(reviews, r)
+		}
+		s.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(reviews); err != nil {
+			log.Err(err).Msg("Failed to encode duplicate review list")
+		}
+	case "POST":
+		id := req.FormValue("id")
+		res := Resolution{
+			Action:    req.FormValue("action"),
+			FireflyID: req.FormValue("firefly_id"),
+		}
+		if err := s.Resolve(id, res); err != nil {
+			httperror.Send(w, req, http.StatusBadRequest, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		httperror.Send(w, req, http.StatusNotImplemented, fmt.Sprintf("Unsupported method %s", req.Method))
+	}
+}
@@ -0,0 +1,41 @@
+package dedupe_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/dedupe"
+)
+
+func TestStorePrunesAdoptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "duplicates.json")
+	recent := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	old := time.Now().Add(-48 * time.Hour).Format(time.RFC3339)
+	data := `{"adopted":{"TRN-1":{"firefly_id":"10","adopted":"` + recent + `"},"TRN-2":{"firefly_id":"20","adopted":"` + old + `"}}}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := dedupe.NewStore(path, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("Got error %v loading the store", err)
+	}
+	if !s.IsAdopted("TRN-1") || s.Adopted["TRN-1"].FireflyID != "10" {
+		t.Fatalf("Got %v, wanted TRN-1 loaded", s.Adopted["TRN-1"])
+	}
+
+	s.Adopt("TRN-3", "30")
+	if s.IsAdopted("TRN-2") {
+		t.Fatalf("Got TRN-2 adopted, wanted it dropped once older than the window")
+	}
+	if !s.IsAdopted("TRN-1") || !s.IsAdopted("TRN-3") {
+		t.Fatalf("Got %v, wanted TRN-1 and TRN-3 kept", s.Adopted)
+	}
+
+	s, err = dedupe.NewStore(path, 24*time.Hour)
+	if err != nil || len(s.Adopted) != 2 {
+		t.Fatalf("Got %v (%v) after reloading, wanted 2 adoptions", s.Adopted, err)
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/config"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/duration"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/firefly"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/logging"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/normalize"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/notify"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/prom"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/redact"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/similar"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/simplefin"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/usage"
+	"github.com/rs/zerolog/log"
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	defaultEmbeddingModel     = string(openai.SmallEmbedding3)
+	defaultEmbeddingThreshold = 0.85
+	defaultEmbeddingNeighbors = 5
+	defaultEmbeddingHistory   = "365d"
+	defaultEmbeddingRebuild   = "1d"
+	embeddingBatchSize        = 100 // Descriptions per embeddings request
+	maxEmbeddedQueries        = 1000
+)
+
+// Embedder categorizes transactions like the nearest labelled Firefly transactions, comparing embeddings of their
+// normalized descriptions. The labelled embeddings are kept in an index in the data directory.
+type Embedder struct {
+	firefly    *firefly.Firefly
+	config     *config.MasterConfig
+	client     *openai.Client
+	index      *similar.Index
+	normalizer *normalize.Normalizer
+	redactor   *redact.Redactor
+	usage      *usage.Tracker
+	notifier   *notify.Notifier
+	queries    map[string][]float32 // Embeddings of descriptions being synced, so they are only requested once
+	mu         sync.Mutex
+}
+
+// NewEmbedder loads the embedding index, or returns nil if embeddings are disabled or there is no embeddings
+// endpoint. Embeddings are requested through provider, the client of the configured AI provider, unless
+// embeddings.base_url is set. Usage counts against the monthly budget in tracker, which may be nil, and using it up
+// is sent to notifier.
+func NewEmbedder(ff *firefly.Firefly, cfg *config.MasterConfig, provider *openai.Client, tracker *usage.Tracker, notifier *notify.Notifier) *Embedder {
+	ecfg := cfg.Embeddings
+	if !ecfg.Enabled {
+		return nil
+	}
+
+	client := provider
+	if ecfg.BaseURL != "" {
+		if cfg.Redaction.LocalOnly && !isLocalEndpoint(ecfg.BaseURL) {
+			log.Error().Str("Endpoint", ecfg.BaseURL).Msg("Local-only mode refuses embedding servers that aren't local, embeddings are disabled")
+			return nil
+		}
+		client = compatibleClient(ecfg.BaseURL)
+	}
+	if client == nil {
+		log.Warn().Msg("No AI provider or embeddings base_url configured, embeddings are disabled")
+		return nil
+	}
+
+	model := ecfg.Model
+	if model == "" {
+		model = defaultEmbeddingModel
+	}
+	index, err := similar.Open(filepath.Join(cli.DataPath, "embeddings.json"), model)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to load the embedding index, it will be rebuilt")
+	}
+	prom.Embeddings.Indexed.Store(int64(index.Len()))
+
+	return &Embedder{
+		firefly:    ff,
+		config:     cfg,
+		client:     client,
+		index:      index,
+		normalizer: buildNormalizer(cfg),
+		redactor:   buildRedactor(cfg),
+		usage:      tracker,
+		notifier:   notifier,
+		queries:    make(map[string][]float32),
+	}
+}
+
+// Predict returns the merchant and category of the nearest labelled transactions of the same direction to trans,
+// if any are similar enough. The description's embedding is requested unless Prepare already did.
+func (e *Embedder) Predict(ctx context.Context, trans simplefin.Transactions) (similar.Prediction, bool) {
+	if e == nil || e.index.Len() == 0 {
+		return similar.Prediction{}, false
+	}
+	description := trans.Description
+
+	e.mu.Lock()
+	vector, ok := e.queries[description]
+	e.mu.Unlock()
+	if !ok {
+		e.Prepare(ctx, []string{description})
+		e.mu.Lock()
+		vector, ok = e.queries[description]
+		e.mu.Unlock()
+		if !ok {
+			return similar.Prediction{}, false
+		}
+	}
+
+	threshold := e.config.Embeddings.Threshold
+	if threshold == 0 {
+		threshold = defaultEmbeddingThreshold
+	}
+	k := e.config.Embeddings.Neighbours
+	if k == 0 {
+		k = defaultEmbeddingNeighbors
+	}
+	p, ok := e.index.Predict(vector, direction(isDeposit(trans)), k, threshold)
+	if !ok {
+		prom.Embeddings.Misses.Add(1)
+		return p, false
+	}
+	prom.Embeddings.Hits.Add(1)
+	return p, true
+}
+
+// Prepare requests the embeddings of descriptions about to be categorized in as few requests as possible.
+func (e *Embedder) Prepare(ctx context.Context, descriptions []string) {
+	if e == nil || e.index.Len() == 0 {
+		return
+	}
+
+	e.mu.Lock()
+	if len(e.queries) > maxEmbeddedQueries {
+		e.queries = make(map[string][]float32)
+	}
+	var missing []string
+	for _, d := range descriptions {
+		if _, ok := e.queries[d]; !ok && d != "" {
+			missing = append(missing, d)
+		}
+	}
+	e.mu.Unlock()
+	if len(missing) == 0 {
+		return
+	}
+
+	vectors, err := e.embed(ctx, missing)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Str("event", "categorization.failed").Msg("Could not embed transaction descriptions")
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i, v := range vectors {
+		e.queries[missing[i]] = v
+	}
+}
+
+// Learn adds a categorization of a direction approved or corrected by hand to the index right away.
+func (e *Embedder) Learn(description, direction, merchant, category string) {
+	if e == nil || description == "" || merchant == "" || category == "" {
+		return
+	}
+
+	vectors, err := e.embed(context.Background(), []string{description})
+	if err != nil || len(vectors) == 0 {
+		log.Error().Err(err).Msg("Could not embed the corrected description")
+		return
+	}
+	if err = e.index.Add(similar.Entry{Description: description, Direction: direction, Merchant: merchant, Category: category, Vector: vectors[0]}); err != nil {
+		log.Error().Err(err).Msg("Could not save the embedding index")
+	}
+	prom.Embeddings.Indexed.Store(int64(e.index.Len()))
+}
+
+// Rebuild indexes every categorized withdrawal and deposit in Firefly within the configured history. Only
+// descriptions that aren't in the index yet are embedded.
+func (e *Embedder) Rebuild() error {
+	entries, embedded, err := e.labelled(nil)
+	if err != nil {
+		return err
+	}
+	if err = e.index.Replace(entries); err != nil {
+		log.Error().Err(err).Msg("Could not save the embedding index")
+	}
+	prom.Embeddings.Indexed.Store(int64(len(entries)))
+
+	log.Info().Int("Entries", len(entries)).Int("Embedded", embedded).Msg("🧭 Rebuilt embedding index")
+	return nil
+}
+
+// holdout returns an embedder with an index kept in memory, built without the transaction journals in exclude, so
+// it can be evaluated on them. Returns nil if e is.
+func (e *Embedder) holdout(exclude map[string]bool) (*Embedder, error) {
+	if e == nil {
+		return nil, nil
+	}
+	entries, _, err := e.labelled(exclude)
+	if err != nil {
+		return nil, err
+	}
+	index, _ := similar.Open("", e.index.Model)
+	if err = index.Replace(entries); err != nil {
+		return nil, err
+	}
+
+	return &Embedder{
+		firefly:    e.firefly,
+		config:     e.config,
+		client:     e.client,
+		index:      index,
+		normalizer: e.normalizer,
+		redactor:   e.redactor,
+		usage:      e.usage,
+		notifier:   e.notifier,
+		queries:    make(map[string][]float32),
+	}, nil
+}
+
+// labelled returns the entries of every categorized withdrawal and deposit in Firefly within the configured history,
+// leaving out the transaction journals in exclude, and how many descriptions had to be embedded. Descriptions that
+// could not be embedded are left out.
+func (e *Embedder) labelled(exclude map[string]bool) ([]similar.Entry, int, error) {
+	history := e.config.Embeddings.History
+	if history == "" {
+		history = defaultEmbeddingHistory
+	}
+	d, err := duration.ParseDuration(history)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	now := time.Now()
+	txns, err := e.firefly.ListTransactions(context.Background(), firefly.TransactionsKey{
+		Start: now.Add(-d).Format(time.DateOnly),
+		End:   now.Format(time.DateOnly),
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// One entry per description and direction, labelled by its latest categorization
+	labelled := make(map[string]similar.Entry)
+	var order, missing []string
+	known := e.index.Vectors()
+	queued := make(map[string]bool)
+	for _, group := range txns {
+		for _, t := range group.Attributes.Transactions {
+			merchant := t.DestinationName
+			switch t.Type {
+			case "withdrawal":
+			case "deposit":
+				merchant = t.SourceName
+			default:
+				continue
+			}
+			if merchant == defaultAccountName || merchant == "" || t.CategoryName == "" || exclude[t.JournalID] {
+				continue
+			}
+			description := t.Description
+			if e.normalizer != nil {
+				description = e.normalizer.Clean(description)
+			}
+			if description == "" {
+				continue
+			}
+			key := t.Type + "\x00" + description
+			if _, ok := labelled[key]; !ok {
+				order = append(order, key)
+			}
+			labelled[key] = similar.Entry{Description: description, Direction: t.Type, Merchant: merchant, Category: t.CategoryName}
+			if _, ok := known[description]; !ok && !queued[description] {
+				missing = append(missing, description)
+				queued[description] = true
+			}
+		}
+	}
+
+	vectors, err := e.embed(context.Background(), missing)
+	if err != nil {
+		log.Error().Err(err).Int("Embedded", len(vectors)).Int("Missing", len(missing)).Msg("Could not embed every description, indexing the rest next time")
+	}
+	for i, v := range vectors {
+		known[missing[i]] = v
+	}
+
+	entries := make([]similar.Entry, 0, len(order))
+	for _, key := range order {
+		entry := labelled[key]
+		if v, ok := known[entry.Description]; ok {
+			entry.Vector = v
+			entries = append(entries, entry)
+		}
+	}
+	return entries, len(vectors), nil
+}
+
+// Schedule rebuilds the index now if it is missing or stale, then on every rebuild interval until quit is closed.
+func (e *Embedder) Schedule(quit <-chan struct{}) {
+	if e == nil {
+		return
+	}
+
+	interval := e.config.Embeddings.RebuildInterval
+	if interval == "" {
+		interval = defaultEmbeddingRebuild
+	}
+	every, err := duration.ParseDuration(interval)
+	if err != nil || every <= 0 {
+		log.Error().Err(err).Str("Interval", interval).Msg("Invalid embedding rebuild interval, using " + defaultEmbeddingRebuild)
+		every, _ = duration.ParseDuration(defaultEmbeddingRebuild)
+	}
+
+	if time.Since(e.index.BuiltAt()) >= every {
+		if err = e.Rebuild(); err != nil {
+			log.Error().Err(err).Msg("Could not build the embedding index")
+		}
+	}
+
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err = e.Rebuild(); err != nil {
+				log.Error().Err(err).Msg("Could not rebuild the embedding index")
+			}
+		case <-quit:
+			return
+		}
+	}
+}
+
+// embed requests the embeddings of texts in batches, with personal information redacted. On error, the embeddings
+// of the batches before it are returned.
+func (e *Embedder) embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += embeddingBatchSize {
+		if e.usage != nil && e.usage.Exceeded() {
+			prom.AI.Failures.Add(failureBudget, 1)
+			return vectors, errBudgetExceeded
+		}
+
+		batch := texts[start:min(start+embeddingBatchSize, len(texts))]
+		input := make([]string, len(batch))
+		for i, text := range batch {
+			input[i] = redactText(e.redactor, text)
+		}
+
+		requestCtx, cancel := context.WithTimeout(ctx, defaultAITimeout)
+		begin := time.Now()
+		resp, err := e.client.CreateEmbeddings(requestCtx, openai.EmbeddingRequest{Input: input, Model: openai.EmbeddingModel(e.index.Model)})
+		cancel()
+		cost := float64(resp.Usage.PromptTokens) * e.config.Embeddings.Price / 1e6
+		accountUsage(e.usage, e.notifier, e.config, begin, resp.Usage, cost, err)
+		if err != nil {
+			return vectors, err
+		}
+		if len(resp.Data) != len(batch) {
+			return vectors, fmt.Errorf("got %d embeddings for %d descriptions", len(resp.Data), len(batch))
+		}
+
+		batchVectors := make([][]float32, len(batch))
+		for _, d := range resp.Data {
+			if d.Index < 0 || d.Index >= len(batch) {
+				return vectors, fmt.Errorf("embedding index %d out of range", d.Index)
+			}
+			batchVectors[d.Index] = d.Embedding
+		}
+		vectors = append(vectors, batchVectors...)
+	}
+	return vectors, nil
+}
@@ -0,0 +1,190 @@
+// Package eval scores categorizers against transactions whose merchant and category are already known
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/match"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/merchant"
+	"github.com/shopspring/decimal"
+)
+
+// Case is a categorized transaction. Merchant and Category are the answer, hidden from the categorizer.
+type Case struct {
+	ID          string          `json:"id"`
+	Description string          `json:"description"`
+	Amount      decimal.Decimal `json:"amount"` // Negative for withdrawals
+	Date        string          `json:"date"`   // YYYY-MM-DD
+	Merchant    string          `json:"merchant"`
+	Category    string          `json:"category"`
+}
+
+// Fixture is a recorded set of cases, with the Firefly names a categorizer chooses from, for running offline.
+type Fixture struct {
+	Categories      []string `json:"categories"`
+	ExpenseAccounts []string `json:"expense_accounts"`
+	RevenueAccounts []string `json:"revenue_accounts"`
+	Cases           []Case   `json:"cases"`
+}
+
+// LoadFixture reads a fixture file.
+func LoadFixture(path string) (Fixture, error) {
+	var f Fixture
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return f, fmt.Errorf("could not read fixture: %w", err)
+	}
+	if err = json.Unmarshal(data, &f); err != nil {
+		return f, fmt.Errorf("could not parse fixture: %w", err)
+	}
+	return f, nil
+}
+
+// Save writes the fixture to path.
+func (f Fixture) Save(path string) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// Prediction is a categorizer's answer for a case.
+type Prediction struct {
+	Merchant string
+	Category string
+	Source   string
+	Latency  time.Duration
+	Tokens   int64
+	Cost     float64 // Dollars
+}
+
+// CategoryScore is how well one category was predicted.
+type CategoryScore struct {
+	Category       string
+	TruePositives  int
+	FalsePositives int
+	FalseNegatives int
+}
+
+// Precision is the share of predictions of the category that were right.
+func (s CategoryScore) Precision() float64 {
+	return ratio(s.TruePositives, s.TruePositives+s.FalsePositives)
+}
+
+// Recall is the share of transactions in the category that were found.
+func (s CategoryScore) Recall() float64 {
+	return ratio(s.TruePositives, s.TruePositives+s.FalseNegatives)
+}
+
+// Report is the score of one categorizer chain.
+type Report struct {
+	Chain           string
+	Cases           int
+	CategoryCorrect int
+	MerchantCorrect int
+	Unanswered      int            // No category given
+	Sources         map[string]int // Answers per categorization source
+	Categories      []CategoryScore
+	Latency         time.Duration // Total
+	Tokens          int64
+	Cost            float64
+}
+
+// Score compares predictions with the answers of cases, in the same order. Names are compared ignoring case,
+// emoji and punctuation (and for merchants, suffixes like .com).
+func Score(chain string, cases []Case, predictions []Prediction) Report {
+	r := Report{Chain: chain, Cases: len(cases), Sources: make(map[string]int)}
+	scores := make(map[string]*CategoryScore)
+	score := func(category string) *CategoryScore {
+		key := match.Normalize(category)
+		if scores[key] == nil {
+			scores[key] = &CategoryScore{Category: category}
+		}
+		return scores[key]
+	}
+
+	for i, c := range cases {
+		p := predictions[i]
+		r.Latency += p.Latency
+		r.Tokens += p.Tokens
+		r.Cost += p.Cost
+		r.Sources[p.Source]++
+
+		if merchant.Key(p.Merchant) == merchant.Key(c.Merchant) {
+			r.MerchantCorrect++
+		}
+		if p.Category == "" {
+			r.Unanswered++
+			score(c.Category).FalseNegatives++
+			continue
+		}
+		if match.Normalize(p.Category) == match.Normalize(c.Category) {
+			r.CategoryCorrect++
+			score(c.Category).TruePositives++
+			continue
+		}
+		score(c.Category).FalseNegatives++
+		score(p.Category).FalsePositives++
+	}
+
+	for _, s := range scores {
+		r.Categories = append(r.Categories, *s)
+	}
+	sort.Slice(r.Categories, func(i, j int) bool { return r.Categories[i].Category < r.Categories[j].Category })
+	return r
+}
+
+// CategoryAccuracy is the share of cases given the right category.
+func (r Report) CategoryAccuracy() float64 {
+	return ratio(r.CategoryCorrect, r.Cases)
+}
+
+// MerchantAccuracy is the share of cases given the right merchant.
+func (r Report) MerchantAccuracy() float64 {
+	return ratio(r.MerchantCorrect, r.Cases)
+}
+
+// Write prints the report as text.
+func (r Report) Write(out io.Writer) {
+	_, _ = fmt.Fprintf(out, "Chain %s: %d transactions\n", r.Chain, r.Cases)
+	_, _ = fmt.Fprintf(out, "  Category accuracy: %.1f%% (%d unanswered)\n", 100*r.CategoryAccuracy(), r.Unanswered)
+	_, _ = fmt.Fprintf(out, "  Merchant accuracy: %.1f%%\n", 100*r.MerchantAccuracy())
+	var avg time.Duration
+	if r.Cases > 0 {
+		avg = r.Latency / time.Duration(r.Cases)
+	}
+	_, _ = fmt.Fprintf(out, "  Latency: %s total, %s average\n", r.Latency.Round(time.Millisecond), avg.Round(time.Millisecond))
+	_, _ = fmt.Fprintf(out, "  Tokens: %d, cost: $%.4f\n", r.Tokens, r.Cost)
+
+	sources := make([]string, 0, len(r.Sources))
+	for source := range r.Sources {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	_, _ = fmt.Fprint(out, "  Answered by:")
+	for _, source := range sources {
+		_, _ = fmt.Fprintf(out, " %s=%d", source, r.Sources[source])
+	}
+	_, _ = fmt.Fprintln(out)
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "  CATEGORY\tPRECISION\tRECALL\tTP\tFP\tFN")
+	for _, s := range r.Categories {
+		_, _ = fmt.Fprintf(tw, "  %s\t%.2f\t%.2f\t%d\t%d\t%d\n", s.Category, s.Precision(), s.Recall(), s.TruePositives, s.FalsePositives, s.FalseNegatives)
+	}
+	_ = tw.Flush()
+}
+
+func ratio(n, d int) float64 {
+	if d == 0 {
+		return 0
+	}
+	return float64(n) / float64(d)
+}
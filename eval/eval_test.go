@@ -0,0 +1,36 @@
+package eval_test
+
+import (
+	"testing"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/eval"
+)
+
+func TestScore(t *testing.T) {
+	cases := []eval.Case{
+		{Merchant: "Amazon", Category: "Shopping"},
+		{Merchant: "Shell", Category: "Gas & Fuel"},
+		{Merchant: "Netflix", Category: "Entertainment"},
+		{Merchant: "Kroger", Category: "Groceries"},
+	}
+	predictions := []eval.Prediction{
+		{Merchant: "Amazon.com", Category: "🛍️ Shopping"},
+		{Merchant: "Shell", Category: "Shopping"},
+		{Merchant: "Hulu", Category: "Entertainment"},
+		{Merchant: "Kroger"},
+	}
+
+	r := eval.Score("test", cases, predictions)
+	if r.CategoryCorrect != 2 || r.MerchantCorrect != 3 || r.Unanswered != 1 {
+		t.Fatalf("Got %d categories, %d merchants right and %d unanswered, wanted 2, 3 and 1", r.CategoryCorrect, r.MerchantCorrect, r.Unanswered)
+	}
+
+	for _, s := range r.Categories {
+		if s.Category == "Shopping" && (s.Precision() != 0.5 || s.Recall() != 1) {
+			t.Fatalf("Got Shopping precision %v and recall %v, wanted 0.5 and 1", s.Precision(), s.Recall())
+		}
+		if s.Category == "Gas & Fuel" && s.Recall() != 0 {
+			t.Fatalf("Got Gas & Fuel recall %v, wanted 0", s.Recall())
+		}
+	}
+}
@@ -0,0 +1,163 @@
+// Package evaltest is a fake OpenAI-compatible server for testing categorizers without an AI provider
+package evaltest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// Answer is what the server replies for a transaction.
+type Answer struct {
+	Merchant   string  `json:"Merchant"`
+	Category   string  `json:"Category"`
+	Confidence float64 `json:"Confidence"`
+}
+
+// Server answers chat and completion requests with the Answer whose key appears in the prompt. Prompts matching no
+// key get an empty answer. Batch requests, constrained by a JSON schema, get a result for each transaction line whose
+// description matches a key; a merchant missing from the schema's merchant enum is given as new_merchant, with the
+// enum's last value (the placeholder for a new merchant) as merchant. Every reply reports 100 prompt and 10
+// completion tokens.
+type Server struct {
+	*httptest.Server
+	Requests atomic.Int64
+	answers  map[string]Answer
+}
+
+// NewServer starts a server. Point an OpenAI client at URL + "/v1" and Close it when done.
+func NewServer(answers map[string]Answer) *Server {
+	s := &Server{answers: answers}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/chat/completions", s.chat)
+	mux.HandleFunc("POST /v1/completions", s.completion)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+var usage = map[string]int{"prompt_tokens": 100, "completion_tokens": 10, "total_tokens": 110}
+
+func (s *Server) chat(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Messages []struct {
+			Content string `json:"content"`
+		} `json:"messages"`
+		ResponseFormat *struct {
+			JSONSchema *struct {
+				Schema batchSchema `json:"schema"`
+			} `json:"json_schema"`
+		} `json:"response_format"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var prompt strings.Builder
+	for _, m := range req.Messages {
+		prompt.WriteString(m.Content)
+	}
+
+	content := ""
+	if req.ResponseFormat != nil && req.ResponseFormat.JSONSchema != nil && len(req.Messages) > 0 {
+		content = s.batch(req.Messages[len(req.Messages)-1].Content, req.ResponseFormat.JSONSchema.Schema.Properties.Results.Items.Properties.Merchant.Enum)
+	} else {
+		content = s.answer(prompt.String())
+	}
+	s.reply(w, map[string]any{
+		"object":  "chat.completion",
+		"choices": []any{map[string]any{"index": 0, "message": map[string]string{"role": "assistant", "content": content}}},
+		"usage":   usage,
+	})
+}
+
+// batchSchema is the part of a batch request's schema the server reads: the merchants to choose from.
+type batchSchema struct {
+	Properties struct {
+		Results struct {
+			Items struct {
+				Properties struct {
+					Merchant struct {
+						Enum []string `json:"enum"`
+					} `json:"merchant"`
+				} `json:"properties"`
+			} `json:"items"`
+		} `json:"results"`
+	} `json:"properties"`
+}
+
+// batchResult is one transaction in a batch reply.
+type batchResult struct {
+	ID          string  `json:"id"`
+	Merchant    string  `json:"merchant"`
+	NewMerchant string  `json:"new_merchant"`
+	Category    string  `json:"category"`
+	Confidence  float64 `json:"confidence"`
+}
+
+// batch answers a batch of transactions, one JSON object with an id and description per line.
+func (s *Server) batch(transactions string, merchants []string) string {
+	s.Requests.Add(1)
+	results := []batchResult{}
+	for _, line := range strings.Split(strings.TrimSpace(transactions), "\n") {
+		var t struct {
+			ID          string `json:"id"`
+			Description string `json:"description"`
+		}
+		if json.Unmarshal([]byte(line), &t) != nil {
+			continue
+		}
+		a, ok := s.lookup(t.Description)
+		if !ok {
+			continue
+		}
+		result := batchResult{ID: t.ID, Merchant: a.Merchant, Category: a.Category, Confidence: a.Confidence}
+		if len(merchants) > 0 && !slices.Contains(merchants, a.Merchant) {
+			result.Merchant, result.NewMerchant = merchants[len(merchants)-1], a.Merchant
+		}
+		results = append(results, result)
+	}
+	data, _ := json.Marshal(map[string]any{"results": results})
+	return string(data)
+}
+
+func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Prompt string `json:"prompt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.reply(w, map[string]any{
+		"object":  "text_completion",
+		"choices": []any{map[string]any{"index": 0, "text": s.answer(req.Prompt)}},
+		"usage":   usage,
+	})
+}
+
+// answer returns the JSON answer for a prompt.
+func (s *Server) answer(prompt string) string {
+	s.Requests.Add(1)
+	a, _ := s.lookup(prompt)
+	data, _ := json.Marshal(a)
+	return string(data)
+}
+
+// lookup returns the Answer whose key appears in text.
+func (s *Server) lookup(text string) (Answer, bool) {
+	for key, answer := range s.answers {
+		if strings.Contains(text, key) {
+			return answer, true
+		}
+	}
+	return Answer{}, false
+}
+
+func (s *Server) reply(w http.ResponseWriter, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(body)
+}
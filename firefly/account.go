@@ -1,6 +1,7 @@
 package firefly
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -151,3 +152,87 @@ func (f *Firefly) ListAccountTransactions(accountID string) (TxnsResponse, error
 
 	return accs, err
 }
+
+// FetchAccount retrieves a single account. If date (YYYY-MM-DD) is provided, Firefly
+// reports the account's balance as of the end of that day instead of today.
+func (f *Firefly) FetchAccount(ctx context.Context, accountID string, date string) (Account, error) {
+	const path = "/api/v1/accounts/"
+
+	if accountID == "" {
+		return Account{}, errors.New("missing Account ID")
+	}
+
+	params := ""
+	if date != "" {
+		params = "?date=" + date
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", f.url+path+accountID+params, nil)
+	req.Header.Add("Authorization", "Bearer "+f.token)
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return Account{}, fmt.Errorf("failed to fetch Account: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return Account{}, fmt.Errorf("got status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Data Account `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return Account{}, err
+	}
+
+	return result.Data, nil
+}
+
+// AccountTransactionsRange lists every transaction of an account between key.Start and key.End,
+// following pagination until the last page.
+func (f *Firefly) AccountTransactionsRange(ctx context.Context, accountID string, key TransactionsKey) ([]Transactions, error) {
+	const path = "/api/v1/accounts/"
+	var results []Transactions
+
+	if accountID == "" {
+		return nil, errors.New("missing Account ID")
+	}
+
+	for page, more := 1, true; more; page++ {
+		params := fmt.Sprintf("%s/transactions?page=%d", accountID, page)
+		if key.Start != "" && key.End != "" {
+			params += fmt.Sprintf("&start=%s&end=%s", key.Start, key.End)
+		}
+		if key.Type != "" {
+			params += "&type=" + key.Type
+		}
+
+		req, _ := http.NewRequestWithContext(ctx, "GET", f.url+path+params, nil)
+		req.Header.Add("Authorization", "Bearer "+f.token)
+		resp, err := f.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch Account Transactions: %w", err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			_ = resp.Body.Close()
+			return nil, fmt.Errorf("got status %d", resp.StatusCode)
+		}
+
+		var txns TxnsResponse
+		err = json.NewDecoder(resp.Body).Decode(&txns)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, txns.Data...)
+		more = txns.Meta.Pagination.CurrentPage < txns.Meta.Pagination.TotalPages
+	}
+
+	return results, nil
+}
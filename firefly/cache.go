@@ -1,6 +1,7 @@
 package firefly
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"time"
@@ -68,6 +69,14 @@ func buildAccountIndexes(accounts []Account) AccountCache {
 	return cache
 }
 
+// Preload fills the account and category caches, so they are used without asking Firefly (to run offline).
+func (f *Firefly) Preload(accounts []Account, categories []Category) {
+	f.cache.mu.Lock()
+	defer f.cache.mu.Unlock()
+	f.cache.Accounts = accounts
+	f.cache.Categories = categories
+}
+
 func (f *Firefly) refreshAccounts() error {
 	c, err := f.ListAccounts("")
 	if err != nil {
@@ -165,12 +174,12 @@ func (f *Firefly) refreshCategoryTotals(key categoryTotalsKey) error {
 	return nil
 }
 
-func (f *Firefly) CachedTransactions(key TransactionsKey) ([]Transactions, error) {
+func (f *Firefly) CachedTransactions(ctx context.Context, key TransactionsKey) ([]Transactions, error) {
 	f.cache.mu.Lock()
 	defer f.cache.mu.Unlock()
 	_, ok := f.cache.Transactions[key]
 	if !ok {
-		err := f.refreshTransactions(key)
+		err := f.refreshTransactions(ctx, key)
 		if err != nil {
 			return nil, err
 		}
@@ -187,11 +196,11 @@ func (f *Firefly) invalidateTransactionsCache() {
 	f.cache.Transactions = nil
 }
 
-func (f *Firefly) refreshTransactions(key TransactionsKey) error {
+func (f *Firefly) refreshTransactions(ctx context.Context, key TransactionsKey) error {
 	if f.cache.Transactions == nil {
 		f.cache.Transactions = make(map[TransactionsKey][]Transactions)
 	}
-	t, err := f.ListTransactions(key)
+	t, err := f.ListTransactions(ctx, key)
 	if err != nil {
 		return err
 	}
@@ -160,6 +160,8 @@ func (f *Firefly) ListCategoryTotals(start, end time.Time) ([]CategoryTotal, err
 	return results, nil
 }
 
+// FetchCategoryTotal returns what was spent and earned in a category between start and end, summed over every
+// currency.
 func (f *Firefly) FetchCategoryTotal(catID int, start, end time.Time) ([]CategoryTotal, error) {
 	const path = "/api/v1/categories/"
 	params := fmt.Sprintf("?start=%s&end=%s", start.Format("2006-01-02"), end.Format("2006-01-02"))
@@ -190,17 +192,13 @@ func (f *Firefly) FetchCategoryTotal(catID int, start, end time.Time) ([]Categor
 	if err != nil {
 		return nil, fmt.Errorf("could not convert id to int: %s", err)
 	}
-	if len(r.Attributes.Spent) == 1 {
-		spent, err = decimal.NewFromString(r.Attributes.Spent[0].Sum)
-		if err != nil {
-			return nil, fmt.Errorf("could not convert spent sum to decimal: %s", err)
-		}
+	spent, err = sumTotals(r.Attributes.Spent)
+	if err != nil {
+		return nil, fmt.Errorf("could not convert spent sum to decimal: %s", err)
 	}
-	if len(r.Attributes.Earned) == 1 {
-		earned, err = decimal.NewFromString(r.Attributes.Earned[0].Sum)
-		if err != nil {
-			return nil, fmt.Errorf("could not convert earned sum to decimal: %s", err)
-		}
+	earned, err = sumTotals(r.Attributes.Earned)
+	if err != nil {
+		return nil, fmt.Errorf("could not convert earned sum to decimal: %s", err)
 	}
 
 	c.ID = id
@@ -240,3 +238,16 @@ func (f *Firefly) ListCategoryTransactions(catID int) (TxnsResponse, error) {
 
 	return accs, err
 }
+
+// sumTotals adds up the sums of every currency.
+func sumTotals(totals []rawTotal) (decimal.Decimal, error) {
+	var sum decimal.Decimal
+	for _, t := range totals {
+		amount, err := decimal.NewFromString(t.Sum)
+		if err != nil {
+			return decimal.Decimal{}, err
+		}
+		sum = sum.Add(amount)
+	}
+	return sum, nil
+}
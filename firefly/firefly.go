@@ -2,6 +2,7 @@
 package firefly
 
 import (
+	"fmt"
 	"net/http"
 )
 
@@ -19,6 +20,28 @@ func New(client *http.Client, token, url string) *Firefly {
 	}
 }
 
+// CheckUser asks Firefly who the token belongs to, failing if Firefly can't be reached or rejects the token.
+func (f *Firefly) CheckUser() error {
+	const path = "/api/v1/about/user"
+
+	req, _ := http.NewRequest("GET", f.url+path, nil)
+	req.Header.Add("Authorization", "Bearer "+f.token)
+	req.Header.Add("Accept", "application/json")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach Firefly: %w", err)
+	}
+	_ = resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("token rejected with status %d", resp.StatusCode)
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("got status %d", resp.StatusCode)
+	}
+	return nil
+}
+
 type meta struct {
 	Pagination pagination `json:"pagination"`
 }
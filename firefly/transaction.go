@@ -2,6 +2,7 @@ package firefly
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -45,6 +46,9 @@ type Transaction struct {
 	DestinationName string          `json:"destination_name,omitempty"`
 	Tags            []string        `json:"tags"`
 	ExternalID      string          `json:"external_id,omitempty"`
+	JournalID       string          `json:"transaction_journal_id,omitempty"`
+	Reconciled      bool            `json:"reconciled,omitempty"`
+	Notes           string          `json:"notes,omitempty"`
 }
 
 type createRequest struct {
@@ -56,7 +60,7 @@ type updateRequest struct {
 	Transactions []Transaction `json:"transactions"`
 }
 
-func (f *Firefly) CreateTransaction(t Transaction) error {
+func (f *Firefly) CreateTransaction(ctx context.Context, t Transaction) error {
 	// Validate the transaction (this modifies t.CategoryID and t.Type if needed)
 	txnDate, err := ValidateTransaction(&t, f)
 	if err != nil {
@@ -81,7 +85,7 @@ func (f *Firefly) CreateTransaction(t Transaction) error {
 		return err
 	}
 
-	r, _ := http.NewRequest("POST", f.url+path, bytes.NewBuffer(body))
+	r, _ := http.NewRequestWithContext(ctx, "POST", f.url+path, bytes.NewBuffer(body))
 	r.Header.Add("Authorization", "Bearer "+f.token)
 	r.Header.Add("Content-Type", "application/json")
 	resp, err := f.client.Do(r)
@@ -129,7 +133,7 @@ func (f *Firefly) CreateTransaction(t Transaction) error {
 
 // DeleteTransaction deletes a transaction by its ID from the system using a DELETE HTTP request.
 // Returns an error if the transaction ID is invalid, the request fails, or the response status is not 204 No Content.
-func (f *Firefly) DeleteTransaction(transID string) error {
+func (f *Firefly) DeleteTransaction(ctx context.Context, transID string) error {
 	// Verify that a transaction ID is provided
 	if transID == "" {
 		return fmt.Errorf("no Transaction ID Provided")
@@ -137,7 +141,7 @@ func (f *Firefly) DeleteTransaction(transID string) error {
 
 	const path = "/api/v1/transactions/"
 
-	r, _ := http.NewRequest("DELETE", f.url+path+transID, nil)
+	r, _ := http.NewRequestWithContext(ctx, "DELETE", f.url+path+transID, nil)
 
 	r.Header.Add("Authorization", "Bearer "+f.token)
 	r.Header.Add("Content-Type", "application/json")
@@ -160,7 +164,7 @@ func (f *Firefly) DeleteTransaction(transID string) error {
 	return nil
 }
 
-func (f *Firefly) UpdateTransaction(transID string, t Transaction) error {
+func (f *Firefly) UpdateTransaction(ctx context.Context, transID string, t Transaction) error {
 	if transID == "" {
 		return errors.New("missing Transaction ID")
 	}
@@ -183,7 +187,7 @@ func (f *Firefly) UpdateTransaction(transID string, t Transaction) error {
 
 	}
 
-	r, _ := http.NewRequest("PUT", f.url+path+transID, bytes.NewBuffer(body))
+	r, _ := http.NewRequestWithContext(ctx, "PUT", f.url+path+transID, bytes.NewBuffer(body))
 	r.Header.Add("Authorization", "Bearer "+f.token)
 	r.Header.Add("Content-Type", "application/json")
 	resp, err := f.client.Do(r)
@@ -331,7 +335,7 @@ func (f *Firefly) listTxns(w http.ResponseWriter, req *http.Request) {
 		End:   end,
 	}
 
-	txns, err := f.CachedTransactions(key)
+	txns, err := f.CachedTransactions(req.Context(), key)
 	if err != nil {
 		httperror.Send(w, req, http.StatusInternalServerError, fmt.Sprintf("Could not list transactions: %s", err))
 		return
@@ -344,7 +348,7 @@ func (f *Firefly) listTxns(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
-func (f *Firefly) ListTransactions(key TransactionsKey) ([]Transactions, error) {
+func (f *Firefly) ListTransactions(ctx context.Context, key TransactionsKey) ([]Transactions, error) {
 	const path = "/api/v1/transactions"
 	var (
 		page               int
@@ -368,7 +372,7 @@ func (f *Firefly) ListTransactions(key TransactionsKey) ([]Transactions, error)
 		} else {
 			params = fmt.Sprintf("page=%d", page)
 		}
-		req, _ := http.NewRequest("GET", f.url+path+"?"+params, nil)
+		req, _ := http.NewRequestWithContext(ctx, "GET", f.url+path+"?"+params, nil)
 		req.Header.Add("Authorization", "Bearer "+f.token)
 		resp, err := f.client.Do(req)
 		if err != nil {
@@ -543,3 +547,56 @@ func ValidateTransaction(t *Transaction, f *Firefly) (time.Time, error) {
 	}
 	return txnDate, nil
 }
+
+// MarkReconciled flags a single transaction journal as reconciled in Firefly.
+func (f *Firefly) MarkReconciled(ctx context.Context, transID, journalID string) error {
+	return f.PatchTransaction(ctx, transID, journalID, map[string]any{"reconciled": true})
+}
+
+// PatchTransaction updates only the given fields of a transaction journal, leaving the rest untouched.
+// The journal ID is required by Firefly when the transaction group has more than one split.
+func (f *Firefly) PatchTransaction(ctx context.Context, transID, journalID string, fields map[string]any) error {
+	if transID == "" {
+		return errors.New("missing Transaction ID")
+	}
+
+	split := make(map[string]any, len(fields)+1)
+	for k, v := range fields {
+		split[k] = v
+	}
+	if journalID != "" {
+		split["transaction_journal_id"] = journalID
+	}
+
+	doc := struct {
+		ApplyRules   bool             `json:"apply_rules"`
+		Transactions []map[string]any `json:"transactions"`
+	}{
+		ApplyRules:   false,
+		Transactions: []map[string]any{split},
+	}
+
+	const path = "/api/v1/transactions/"
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	r, _ := http.NewRequestWithContext(ctx, "PUT", f.url+path+transID, bytes.NewBuffer(body))
+	r.Header.Add("Authorization", "Bearer "+f.token)
+	r.Header.Add("Content-Type", "application/json")
+	resp, err := f.client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not update transaction, got status %d %s", resp.StatusCode, resp.Status)
+	}
+
+	f.invalidateTransactionsCache()
+	return nil
+}
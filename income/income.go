@@ -0,0 +1,60 @@
+// Package income recognizes common kinds of deposits (interest, dividends, payroll and refunds) from their description
+package income
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Kinds of deposits
+const (
+	Interest  = "interest"
+	Dividends = "dividends"
+	Payroll   = "payroll"
+	Refunds   = "refunds"
+)
+
+// Match is a recognized deposit.
+type Match struct {
+	Kind  string
+	Payer string // Who the money came from, when the description names them
+}
+
+type detector struct {
+	kind     string
+	pattern  *regexp.Regexp
+	hasPayer bool // The rest of the description names the payer (employer, merchant)
+}
+
+// detectors run in order, the first match wins.
+var detectors = []detector{
+	{Dividends, regexp.MustCompile(`(?i)\b(dividends?|div(idend)? (paid|payment|reinvest(ment)?)|qualified div|ordinary div|cash div)\b`), true},
+	{Interest, regexp.MustCompile(`(?i)\b(interest|int (paid|pmt|payment|earned|credit)|apy earned)\b`), false},
+	{Payroll, regexp.MustCompile(`(?i)\b(payroll|direct dep(osit)?|dir dep|salary|paycheck|wages|net pay)\b`), true},
+	{Refunds, regexp.MustCompile(`(?i)\b(refund|return(ed)? (credit|purchase)|reversal|merchant credit|purchase return|chargeback)\b`), true},
+}
+
+var noise = regexp.MustCompile(`(?i)\b(ach|ppd|ccd|credit|deposit|dep|from|id|pmt|payment|co|entry|descr|desc|reinvest(ment)?|\d+)\b|[^a-zA-Z0-9&.' ]+`)
+
+// Detect reports which kind of deposit a description is, if any.
+func Detect(description string) (Match, bool) {
+	for _, d := range detectors {
+		loc := d.pattern.FindStringIndex(description)
+		if loc == nil {
+			continue
+		}
+
+		m := Match{Kind: d.kind}
+		if d.hasPayer {
+			m.Payer = payer(description[:loc[0]] + " " + description[loc[1]:])
+		}
+		return m, true
+	}
+	return Match{}, false
+}
+
+// payer cleans what is left of a description once the keyword is removed, e.g. "ACME CORP" from "ACME CORP PAYROLL PPD".
+func payer(rest string) string {
+	rest = noise.ReplaceAllString(rest, " ")
+	return strings.Join(strings.Fields(rest), " ")
+}
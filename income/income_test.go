@@ -0,0 +1,27 @@
+package income_test
+
+import (
+	"testing"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/income"
+)
+
+func TestDetect(t *testing.T) {
+	tests := map[string]income.Match{
+		"ACME CORP PAYROLL PPD ID: 123":  {Kind: income.Payroll, Payer: "ACME CORP"},
+		"INTEREST PAID":                  {Kind: income.Interest},
+		"VANGUARD DIVIDEND REINVESTMENT": {Kind: income.Dividends, Payer: "VANGUARD"},
+		"AMAZON.COM REFUND":              {Kind: income.Refunds, Payer: "AMAZON.COM"},
+		"Direct Deposit Globex":          {Kind: income.Payroll, Payer: "Globex"},
+	}
+	for description, want := range tests {
+		got, ok := income.Detect(description)
+		if !ok || got != want {
+			t.Fatalf("Got %v (%v) for %q, wanted %v", got, ok, description, want)
+		}
+	}
+
+	if m, ok := income.Detect("TRANSFER FROM SAVINGS"); ok {
+		t.Fatalf("Got %v for a transfer, wanted no match", m)
+	}
+}
@@ -0,0 +1,254 @@
+// Package institution tracks the health of each bank connection behind SimpleFIN, from the errors and balance dates
+// in every SimpleFIN response
+package institution
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/httperror"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/internal/jsonfile"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/simplefin"
+	"github.com/rs/zerolog/log"
+)
+
+// States
+const (
+	Healthy     = "healthy"
+	Degraded    = "degraded"     // Errors, or balances older than the stale threshold
+	NeedsReauth = "needs-reauth" // The bank wants you to log in to SimpleFIN Bridge again
+)
+
+// States lists every state, for metrics.
+var States = []string{Healthy, Degraded, NeedsReauth}
+
+const defaultStaleAfter = 48 * time.Hour
+
+// reauthHints are error fragments meaning the connection needs logging into again.
+var reauthHints = []string{"authenticat", "credential", "log in", "login", "password", "reconnect", "relink", "re-link", "mfa", "verification"}
+
+// Account is an account at an institution.
+type Account struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	BalanceDate time.Time `json:"balance_date"`
+}
+
+// Status is the health of one institution.
+type Status struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Domain   string    `json:"domain,omitempty"`
+	SFINURL  string    `json:"sfin_url,omitempty"`
+	State    string    `json:"state"`
+	Since    time.Time `json:"since"`            // When State started
+	Errors   []string  `json:"errors,omitempty"` // From the latest response
+	Accounts []Account `json:"accounts"`
+	Checked  time.Time `json:"checked"`
+}
+
+// OldestBalance returns the balance date of the account updated longest ago.
+func (s Status) OldestBalance() time.Time {
+	var oldest time.Time
+	for _, a := range s.Accounts {
+		if !a.BalanceDate.IsZero() && (oldest.IsZero() || a.BalanceDate.Before(oldest)) {
+			oldest = a.BalanceDate
+		}
+	}
+	return oldest
+}
+
+// Change is an institution moving from one state to another.
+type Change struct {
+	Status
+	From string // Empty for an institution seen for the first time
+}
+
+// Tracker keeps the state of every institution, persisted so state start times survive restarts.
+type Tracker struct {
+	path         string
+	staleAfter   time.Duration
+	Institutions map[string]*Status `json:"institutions"`
+	Unassigned   []string           `json:"unassigned,omitempty"` // Errors not naming any institution
+	mu           sync.Mutex
+}
+
+// Open loads the health recorded at path, knowing no institutions on first run. staleAfter is how old a balance may
+// get before its institution is degraded (0 = 48h).
+func Open(path string, staleAfter time.Duration) (*Tracker, error) {
+	if staleAfter <= 0 {
+		staleAfter = defaultStaleAfter
+	}
+	t := &Tracker{path: path, staleAfter: staleAfter, Institutions: make(map[string]*Status)}
+
+	if err := jsonfile.Load(path, t); err != nil {
+		return t, fmt.Errorf("could not load institution health: %w", err)
+	}
+	if t.Institutions == nil {
+		t.Institutions = make(map[string]*Status)
+	}
+	return t, nil
+}
+
+// Key identifies an institution: its SimpleFIN ID, or its domain or name if there is none.
+func Key(org simplefin.Org) string {
+	for _, k := range []string{org.ID, org.Domain, org.Name} {
+		if k != "" {
+			return strings.ToLower(k)
+		}
+	}
+	return "unknown"
+}
+
+// Update works out the state of every institution in a SimpleFIN response and returns the institutions whose state
+// changed. Errors are assigned to the institutions they name, or to the only institution if there is just one.
+// Institutions missing from the response keep their last state.
+func (t *Tracker) Update(resp simplefin.AccountsResponse, now time.Time) []Change {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	seen := make(map[string]*Status)
+	var order []string
+	for _, acct := range resp.Accounts {
+		key := Key(acct.Org)
+		s, ok := seen[key]
+		if !ok {
+			name := acct.Org.Name
+			if name == "" {
+				name = acct.Org.Domain
+			}
+			s = &Status{ID: key, Name: name, Domain: acct.Org.Domain, SFINURL: acct.Org.SFINURL, Checked: now}
+			seen[key] = s
+			order = append(order, key)
+		}
+		a := Account{ID: acct.ID, Name: acct.Name}
+		if acct.BalanceDate > 0 {
+			a.BalanceDate = time.Unix(acct.BalanceDate, 0)
+		}
+		s.Accounts = append(s.Accounts, a)
+	}
+
+	t.Unassigned = nil
+	for _, e := range resp.Errors {
+		matched := false
+		for _, key := range order {
+			if s := seen[key]; mentions(e, s) {
+				s.Errors = append(s.Errors, e)
+				matched = true
+			}
+		}
+		switch {
+		case matched:
+		case len(order) == 1:
+			seen[order[0]].Errors = append(seen[order[0]].Errors, e)
+		default:
+			t.Unassigned = append(t.Unassigned, e)
+		}
+	}
+
+	var changes []Change
+	for _, key := range order {
+		s := seen[key]
+		s.State = t.state(s, now)
+		previous, ok := t.Institutions[key]
+		switch {
+		case !ok:
+			s.Since = now
+			changes = append(changes, Change{Status: *s})
+		case previous.State != s.State:
+			s.Since = now
+			changes = append(changes, Change{Status: *s, From: previous.State})
+		default:
+			s.Since = previous.Since
+		}
+		t.Institutions[key] = s
+	}
+	t.save()
+	return changes
+}
+
+// state classifies an institution against the tracker's stale threshold.
+func (t *Tracker) state(s *Status, now time.Time) string {
+	for _, e := range s.Errors {
+		lower := strings.ToLower(e)
+		for _, hint := range reauthHints {
+			if strings.Contains(lower, hint) {
+				return NeedsReauth
+			}
+		}
+	}
+	if len(s.Errors) > 0 {
+		return Degraded
+	}
+	if oldest := s.OldestBalance(); !oldest.IsZero() && now.Sub(oldest) > t.staleAfter {
+		return Degraded
+	}
+	return Healthy
+}
+
+// mentions reports whether an error names an institution by name or domain.
+func mentions(e string, s *Status) bool {
+	lower := strings.ToLower(e)
+	for _, name := range []string{s.Name, s.Domain} {
+		if name != "" && strings.Contains(lower, strings.ToLower(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Statuses returns every institution, sorted by name.
+func (t *Tracker) Statuses() []Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	statuses := make([]Status, 0, len(t.Institutions))
+	for _, s := range t.Institutions {
+		statuses = append(statuses, *s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// UnassignedErrors returns the errors of the latest response that didn't name an institution.
+func (t *Tracker) UnassignedErrors() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.Unassigned...)
+}
+
+// Report is the body of the institutions endpoint.
+type Report struct {
+	Institutions []Status `json:"institutions"`
+	Unassigned   []string `json:"unassigned_errors,omitempty"`
+}
+
+// HandleInstitutions returns every institution's health as JSON, worst first.
+func (t *Tracker) HandleInstitutions(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		httperror.Send(w, req, http.StatusNotImplemented, fmt.Sprintf("Unsupported method %s", req.Method))
+		return
+	}
+
+	rank := map[string]int{NeedsReauth: 0, Degraded: 1, Healthy: 2}
+	report := Report{Institutions: t.Statuses(), Unassigned: t.UnassignedErrors()}
+	sort.SliceStable(report.Institutions, func(i, j int) bool {
+		return rank[report.Institutions[i].State] < rank[report.Institutions[j].State]
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Err(err).Msg("Failed to encode institution health")
+	}
+}
+
+// save persists every institution's state so their start times survive restarts. Called with t.mu held.
+func (t *Tracker) save() {
+	if err := jsonfile.Save(t.path, t); err != nil {
+		log.Error().Err(err).Msg("Could not save institution health")
+	}
+}
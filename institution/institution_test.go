@@ -0,0 +1,100 @@
+package institution_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/institution"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/simplefin"
+)
+
+var (
+	chase = simplefin.Org{ID: "ORG-1", Name: "Chase", Domain: "chase.com", SFINURL: "https://beta-bridge.simplefin.org/simplefin"}
+	ally  = simplefin.Org{ID: "ORG-2", Name: "Ally Bank", Domain: "ally.com"}
+)
+
+func response(balanceDate time.Time, errs ...string) simplefin.AccountsResponse {
+	return simplefin.AccountsResponse{
+		Errors: errs,
+		Accounts: []simplefin.Accounts{
+			{ID: "ACT-1", Name: "Checking", Org: chase, BalanceDate: balanceDate.Unix()},
+			{ID: "ACT-2", Name: "Savings", Org: chase, BalanceDate: balanceDate.Unix()},
+			{ID: "ACT-3", Name: "Savings", Org: ally, BalanceDate: balanceDate.Unix()},
+		},
+	}
+}
+
+func states(t *institution.Tracker) map[string]string {
+	got := make(map[string]string)
+	for _, s := range t.Statuses() {
+		got[s.ID] = s.State
+	}
+	return got
+}
+
+func TestUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "institutions.json")
+	tracker, err := institution.Open(path, 0)
+	if err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	changes := tracker.Update(response(now.Add(-time.Hour)), now)
+	if len(changes) != 2 {
+		t.Fatalf("Got %d changes, wanted both institutions seen for the first time", len(changes))
+	}
+	if got := states(tracker); got["org-1"] != institution.Healthy || got["org-2"] != institution.Healthy {
+		t.Fatalf("Got states %v, wanted both healthy", got)
+	}
+
+	later := now.Add(24 * time.Hour)
+	changes = tracker.Update(response(later.Add(-time.Hour), "Connection to Chase needs you to re-authenticate", "Temporary problem at ally.com", "Something else"), later)
+	if len(changes) != 2 {
+		t.Fatalf("Got %d changes, wanted 2", len(changes))
+	}
+	if got := states(tracker); got["org-1"] != institution.NeedsReauth || got["org-2"] != institution.Degraded {
+		t.Fatalf("Got states %v, wanted Chase to need reauthentication and Ally degraded", got)
+	}
+	if got := tracker.UnassignedErrors(); len(got) != 1 || got[0] != "Something else" {
+		t.Fatalf("Got unassigned errors %v, wanted the one naming no institution", got)
+	}
+
+	// State start times survive a restart
+	reopened, err := institution.Open(path, 0)
+	if err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+	evenLater := later.Add(time.Hour)
+	reopened.Update(response(evenLater.Add(-time.Hour), "Connection to Chase needs you to re-authenticate"), evenLater)
+	for _, s := range reopened.Statuses() {
+		if s.ID == "org-1" && !s.Since.Equal(later) {
+			t.Fatalf("Got Chase needing reauthentication since %s, wanted %s", s.Since, later)
+		}
+		if s.ID == "org-2" && (s.State != institution.Healthy || !s.Since.Equal(evenLater)) {
+			t.Fatalf("Got Ally %s since %s, wanted healthy since %s", s.State, s.Since, evenLater)
+		}
+	}
+}
+
+func TestStaleBalance(t *testing.T) {
+	tracker, _ := institution.Open("", 48*time.Hour)
+	now := time.Now()
+	tracker.Update(response(now.Add(-72*time.Hour)), now)
+	if got := states(tracker); got["org-1"] != institution.Degraded {
+		t.Fatalf("Got states %v, wanted balances three days old degraded", got)
+	}
+}
+
+func TestSingleInstitutionGetsErrors(t *testing.T) {
+	tracker, _ := institution.Open("", 0)
+	now := time.Now()
+	tracker.Update(simplefin.AccountsResponse{
+		Errors:   []string{"Please log in again"},
+		Accounts: []simplefin.Accounts{{ID: "ACT-1", Org: simplefin.Org{Domain: "bank.example"}, BalanceDate: now.Unix()}},
+	}, now)
+	if got := states(tracker); got["bank.example"] != institution.NeedsReauth {
+		t.Fatalf("Got states %v, wanted the only institution to need reauthentication", got)
+	}
+}
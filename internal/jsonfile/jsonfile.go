@@ -0,0 +1,53 @@
+// Package jsonfile loads and saves the JSON state files kept in the data directory.
+package jsonfile
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// Load reads the JSON file at path into v. A missing file is not an error and leaves v as it was.
+func Load(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Save writes v to path as indented JSON, creating the directory if needed. The data goes to a temporary file that
+// is then renamed over path, so a crash never leaves a truncated file behind. An empty path saves nothing.
+func Save(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return write(path, data)
+}
+
+// SaveCompact is Save without indentation, for large files nobody reads by hand.
+func SaveCompact(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return write(path, data)
+}
+
+func write(path string, data []byte) error {
+	if path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
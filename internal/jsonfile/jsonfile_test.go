@@ -0,0 +1,44 @@
+package jsonfile_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/internal/jsonfile"
+)
+
+type state struct {
+	Count int               `json:"count"`
+	Names map[string]string `json:"names"`
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "state.json")
+
+	got := state{Count: 7}
+	if err := jsonfile.Load(path, &got); err != nil || got.Count != 7 {
+		t.Fatalf("Got %v, %v, wanted a missing file to leave the state alone", got, err)
+	}
+
+	if err := jsonfile.Save(path, state{Count: 3, Names: map[string]string{"a": "b"}}); err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+	if _, err := os.Stat(path + ".tmp"); err == nil {
+		t.Fatalf("Got a leftover temporary file, wanted it renamed")
+	}
+	if err := jsonfile.Load(path, &got); err != nil || got.Count != 3 || got.Names["a"] != "b" {
+		t.Fatalf("Got %v, %v, wanted the saved state", got, err)
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0o644); err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+	if err := jsonfile.Load(path, &got); err == nil {
+		t.Fatalf("Got no error for a truncated file, wanted one")
+	}
+
+	if err := jsonfile.SaveCompact("", got); err != nil {
+		t.Fatalf("Got error %v, wanted an empty path to save nothing", err)
+	}
+}
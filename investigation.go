@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/config"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/duration"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/firefly"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/httperror"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/logging"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/reconcile"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/simplefin"
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+// Investigator explains balance mismatches by comparing SimpleFIN and Firefly transactions.
+// It keeps the SimpleFIN accounts from the most recent sync so investigations can run on demand.
+type Investigator struct {
+	firefly  *firefly.Firefly
+	config   *config.MasterConfig
+	accounts []simplefin.Accounts
+	mu       sync.Mutex
+}
+
+// NewInvestigator creates an Investigator with no SimpleFIN data; SetAccounts is called after every sync.
+func NewInvestigator(ff *firefly.Firefly, cfg *config.MasterConfig) *Investigator {
+	return &Investigator{
+		firefly: ff,
+		config:  cfg,
+	}
+}
+
+// SetAccounts stores the SimpleFIN accounts of the most recent sync.
+func (i *Investigator) SetAccounts(accounts []simplefin.Accounts) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.accounts = accounts
+}
+
+// Investigate runs an investigation for a Firefly account ID or SimpleFIN account ID.
+func (i *Investigator) Investigate(ctx context.Context, accountID string) (reconcile.Report, error) {
+	i.mu.Lock()
+	accounts := i.accounts
+	i.mu.Unlock()
+
+	for _, acct := range accounts {
+		if acct.ID == accountID || i.config.Accounts[acct.ID] == accountID {
+			return i.InvestigateAccount(ctx, acct)
+		}
+	}
+	return reconcile.Report{}, fmt.Errorf("no SimpleFIN account found for %s", accountID)
+}
+
+// InvestigateAccount compares a SimpleFIN account with its Firefly account and explains the balance difference.
+func (i *Investigator) InvestigateAccount(ctx context.Context, acct simplefin.Accounts) (reconcile.Report, error) {
+	ffAccount, err := GetAccount(i.firefly, i.config.Accounts[acct.ID])
+	if err != nil {
+		return reconcile.Report{}, err
+	}
+
+	balance, pending, err := expectedBalance(ctx, i.firefly, i.config, acct, ffAccount.ID)
+	if err != nil {
+		return reconcile.Report{}, err
+	}
+	difference := acct.Balance.Sub(balance.Sub(pending))
+
+	// Look a little further back than SimpleFIN does, so late-dated Firefly transactions are included
+	t, err := duration.ParseDuration(cli.SimplefinLoopbackDuration)
+	if err != nil {
+		return reconcile.Report{}, err
+	}
+	balanceDate := simplefinBalanceDate(acct)
+	txns, err := i.firefly.AccountTransactionsRange(ctx, ffAccount.ID, firefly.TransactionsKey{
+		Start: balanceDate.Add(-t - (5 * 24 * time.Hour)).Format(time.DateOnly),
+		End:   balanceDate.Format(time.DateOnly),
+	})
+	if err != nil {
+		return reconcile.Report{}, err
+	}
+
+	report := reconcile.Investigate(acct, ffAccount.ID, txns, difference, decimal.NewFromFloat(i.config.Reconciliation.Tolerance))
+	report.AccountName = ffAccount.Attributes.Name
+	return report, nil
+}
+
+// LogReport writes the findings of an investigation to the log.
+func LogReport(ctx context.Context, report reconcile.Report) {
+	for _, finding := range report.Findings {
+		logging.Ctx(ctx).Warn().
+			Str("Type", "Investigation").
+			Str("Account", report.AccountName).
+			Str("Kind", finding.Kind).
+			Str("ExternalID", finding.ExternalID).
+			Str("FireflyID", finding.FireflyID).
+			Str("Description", finding.Description).
+			Str("Date", finding.Date).
+			Float64("Amount", finding.Amount.InexactFloat64()).
+			Float64("Impact", finding.Impact.InexactFloat64()).
+			Msg("🔍 Balance mismatch finding")
+	}
+
+	event := logging.Ctx(ctx).Warn()
+	if report.Explained {
+		event = logging.Ctx(ctx).Info()
+	}
+	explanation := make([]string, 0, len(report.Explanation))
+	for _, finding := range report.Explanation {
+		explanation = append(explanation, fmt.Sprintf("%s %s (%s)", finding.Kind, finding.Description, finding.Impact))
+	}
+	event.
+		Str("Type", "Investigation").
+		Str("Account", report.AccountName).
+		Float64("Difference", report.Difference.InexactFloat64()).
+		Int("Findings", len(report.Findings)).
+		Bool("Explained", report.Explained).
+		Strs("Explanation", explanation).
+		Msgf("🔍 Investigated balance mismatch for %s", report.AccountName)
+}
+
+// HandleInvestigate runs an investigation for the account query parameter and returns the report as JSON.
+func (i *Investigator) HandleInvestigate(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		httperror.Send(w, req, http.StatusNotImplemented, fmt.Sprintf("Unsupported method %s", req.Method))
+		return
+	}
+
+	accountID := req.URL.Query().Get("account")
+	if accountID == "" {
+		httperror.Send(w, req, http.StatusBadRequest, "Missing account parameter")
+		return
+	}
+
+	report, err := i.Investigate(req.Context(), accountID)
+	if err != nil {
+		httperror.Send(w, req, http.StatusInternalServerError, fmt.Sprintf("Could not investigate account: %s", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(report); err != nil {
+		log.Err(err).Msg("Failed to encode investigation report")
+	}
+}
+
+// runInvestigateCommand fetches fresh SimpleFIN data, investigates a single account, and prints the report to out.
+func runInvestigateCommand(ctx context.Context, sf *simplefin.Simplefin, investigator *Investigator, accountID string, out io.Writer) error {
+	t, err := duration.ParseDuration(cli.SimplefinLoopbackDuration)
+	if err != nil {
+		return err
+	}
+	sf.SetFilter(simplefin.Filter{
+		StartDate: time.Now().Add(-t - (24 * time.Hour)).Unix(),
+		Pending:   true,
+	})
+
+	resp, err := sf.Accounts(ctx)
+	if err != nil {
+		return err
+	}
+	investigator.SetAccounts(resp.Accounts)
+
+	report, err := investigator.Investigate(ctx, accountID)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, string(data))
+	return err
+}
@@ -0,0 +1,164 @@
+// Package learn is a local categorizer that learns merchants and categories from transactions already in Firefly
+package learn
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/internal/jsonfile"
+)
+
+// Example is a categorized transaction to learn from.
+type Example struct {
+	Description string
+	Merchant    string
+	Category    string
+}
+
+// Prediction is the most likely merchant and category for a description.
+type Prediction struct {
+	Merchant   string
+	Category   string
+	Confidence float64 // Probability of the prediction, between 0 and 1
+}
+
+// label is everything learned about one merchant and category pair.
+type label struct {
+	Merchant string         `json:"merchant"`
+	Category string         `json:"category"`
+	Docs     int            `json:"docs"`
+	Tokens   map[string]int `json:"tokens"`
+	Total    int            `json:"total"`
+}
+
+// Model is a multinomial naive Bayes classifier over description tokens. The zero value predicts nothing.
+type Model struct {
+	Labels  map[string]*label `json:"labels"`
+	Vocab   map[string]int    `json:"vocab"` // Token -> number of examples it appeared in
+	Docs    int               `json:"docs"`
+	Trained time.Time         `json:"trained"`
+}
+
+// Train builds a model from examples. Examples without a description, merchant or category are ignored.
+func Train(examples []Example) *Model {
+	m := &Model{
+		Labels:  make(map[string]*label),
+		Vocab:   make(map[string]int),
+		Trained: time.Now(),
+	}
+
+	for _, ex := range examples {
+		m.Add(ex)
+	}
+	return m
+}
+
+// Add learns a single example, e.g. a correction made by hand. Examples without a description, merchant or
+// category are ignored.
+func (m *Model) Add(ex Example) {
+	tokens := Tokenize(ex.Description)
+	if len(tokens) == 0 || ex.Merchant == "" || ex.Category == "" {
+		return
+	}
+	if m.Labels == nil {
+		m.Labels = make(map[string]*label)
+		m.Vocab = make(map[string]int)
+	}
+
+	key := ex.Merchant + "\x00" + ex.Category
+	l, ok := m.Labels[key]
+	if !ok {
+		l = &label{Merchant: ex.Merchant, Category: ex.Category, Tokens: make(map[string]int)}
+		m.Labels[key] = l
+	}
+
+	l.Docs++
+	m.Docs++
+	seen := make(map[string]bool)
+	for _, tok := range tokens {
+		l.Tokens[tok]++
+		l.Total++
+		if !seen[tok] {
+			m.Vocab[tok]++
+			seen[tok] = true
+		}
+	}
+}
+
+// Predict returns the most likely merchant and category for a description. It returns false if the model is
+// empty or none of the description's tokens were seen in training.
+func (m *Model) Predict(description string) (Prediction, bool) {
+	if m == nil || m.Docs == 0 {
+		return Prediction{}, false
+	}
+
+	var tokens []string
+	for _, tok := range Tokenize(description) {
+		if m.Vocab[tok] > 0 {
+			tokens = append(tokens, tok)
+		}
+	}
+	if len(tokens) == 0 {
+		return Prediction{}, false
+	}
+
+	// Log probabilities with Laplace smoothing
+	vocab := float64(len(m.Vocab))
+	scores := make(map[*label]float64, len(m.Labels))
+	var best *label
+	for _, l := range m.Labels {
+		score := math.Log(float64(l.Docs) / float64(m.Docs))
+		for _, tok := range tokens {
+			score += math.Log((float64(l.Tokens[tok]) + 1) / (float64(l.Total) + vocab))
+		}
+		scores[l] = score
+		if best == nil || score > scores[best] || (score == scores[best] && l.Docs > best.Docs) {
+			best = l
+		}
+	}
+
+	// Normalize into a probability
+	var sum float64
+	for _, score := range scores {
+		sum += math.Exp(score - scores[best])
+	}
+
+	return Prediction{
+		Merchant:   best.Merchant,
+		Category:   best.Category,
+		Confidence: 1 / sum,
+	}, true
+}
+
+// Tokenize lowercases a description and splits it into words, dropping numbers and single characters.
+func Tokenize(description string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(description), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	var tokens []string
+	for _, f := range fields {
+		if len([]rune(f)) < 2 || strings.IndexFunc(f, unicode.IsLetter) == -1 {
+			continue
+		}
+		tokens = append(tokens, f)
+	}
+	return tokens
+}
+
+// Load reads a model saved at path, or returns nil if nothing has been saved yet.
+func Load(path string) (*Model, error) {
+	var m *Model
+	if err := jsonfile.Load(path, &m); err != nil {
+		return nil, fmt.Errorf("could not load learned model: %w", err)
+	}
+	return m, nil
+}
+
+// Save writes the model to path.
+func (m *Model) Save(path string) error {
+	return jsonfile.SaveCompact(path, m)
+}
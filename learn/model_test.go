@@ -0,0 +1,42 @@
+package learn_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/learn"
+)
+
+func TestPredict(t *testing.T) {
+	m := learn.Train([]learn.Example{
+		{Description: "NETFLIX.COM 866-579-7172 CA", Merchant: "Netflix", Category: "Entertainment"},
+		{Description: "NETFLIX.COM LOS GATOS", Merchant: "Netflix", Category: "Entertainment"},
+		{Description: "SHELL OIL 57442 HOUSTON", Merchant: "Shell", Category: "Fuel"},
+		{Description: "SHELL SERVICE STATION", Merchant: "Shell", Category: "Fuel"},
+		{Description: "KROGER #123", Merchant: "Kroger", Category: "Groceries"},
+	})
+
+	p, ok := m.Predict("NETFLIX.COM 123456")
+	if !ok || p.Merchant != "Netflix" || p.Category != "Entertainment" {
+		t.Fatalf("Got %v, wanted Netflix / Entertainment", p)
+	}
+	if p.Confidence < 0.5 || p.Confidence > 1 {
+		t.Fatalf("Got confidence %f, wanted between 0.5 and 1", p.Confidence)
+	}
+
+	if _, ok = m.Predict("ACME 99"); ok {
+		t.Fatalf("Got a prediction for unseen words, wanted none")
+	}
+
+	path := filepath.Join(t.TempDir(), "model.json")
+	if err := m.Save(path); err != nil {
+		t.Fatalf("Got error %v saving, wanted none", err)
+	}
+	loaded, err := learn.Load(path)
+	if err != nil {
+		t.Fatalf("Got error %v loading, wanted none", err)
+	}
+	if p, ok = loaded.Predict("shell station"); !ok || p.Merchant != "Shell" {
+		t.Fatalf("Got %v from the loaded model, wanted Shell", p)
+	}
+}
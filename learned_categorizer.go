@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/config"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/duration"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/firefly"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/learn"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/simplefin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	learnedModeFirst = "first" // Use the learned model ahead of the AI provider
+	learnedModeOnly  = "only"  // Use the learned model instead of the AI provider
+
+	defaultMinConfidence   = 0.8
+	defaultRetrainInterval = "1d"
+	defaultLearnHistory    = "365d"
+)
+
+// directions are the Firefly transaction types the learner keeps a model for. Withdrawals and deposits are learned
+// apart, so a refund from a merchant isn't categorized like a purchase there.
+var directions = []string{"withdrawal", "deposit"}
+
+// direction returns the Firefly transaction type of a deposit or withdrawal.
+func direction(deposit bool) string {
+	if deposit {
+		return "deposit"
+	}
+	return "withdrawal"
+}
+
+// Learner keeps the learned categorizer trained on Firefly history and saved in the data directory, one model per
+// direction.
+type Learner struct {
+	firefly *firefly.Firefly
+	config  *config.MasterConfig
+	dir     string
+	models  map[string]*learn.Model // By direction
+	mu      sync.RWMutex
+}
+
+// NewLearner loads the saved model, or returns nil if the learned categorizer is disabled.
+func NewLearner(ff *firefly.Firefly, cfg *config.MasterConfig) *Learner {
+	if !cfg.LearnedCategorizer.Enabled {
+		return nil
+	}
+
+	l := &Learner{
+		firefly: ff,
+		config:  cfg,
+		dir:     cli.DataPath,
+		models:  make(map[string]*learn.Model),
+	}
+
+	for _, dir := range directions {
+		model, err := learn.Load(l.path(dir))
+		if err != nil {
+			log.Error().Err(err).Str("Direction", dir).Msg("Unable to load learned model, it will be retrained")
+		}
+		l.models[dir] = model
+	}
+	return l
+}
+
+// path is where the model of a direction is saved, empty for a learner kept in memory.
+func (l *Learner) path(direction string) string {
+	if l.dir == "" {
+		return ""
+	}
+	return filepath.Join(l.dir, "learned_model_"+direction+".json")
+}
+
+// Only reports whether the learned model replaces the AI provider entirely.
+func (l *Learner) Only() bool {
+	return l != nil && l.config.LearnedCategorizer.Mode == learnedModeOnly
+}
+
+// Predict returns the learned merchant and category for a transaction, if the model of its direction is confident
+// enough.
+func (l *Learner) Predict(trans simplefin.Transactions) (learn.Prediction, bool) {
+	if l == nil {
+		return learn.Prediction{}, false
+	}
+
+	l.mu.RLock()
+	p, ok := l.models[direction(isDeposit(trans))].Predict(trans.Description)
+	l.mu.RUnlock()
+
+	minConfidence := l.config.LearnedCategorizer.MinConfidence
+	if minConfidence == 0 {
+		minConfidence = defaultMinConfidence
+	}
+	if !ok || p.Confidence < minConfidence {
+		return p, false
+	}
+	return p, true
+}
+
+// Learn adds a single example to the model of a direction right away, without waiting for the next retrain.
+func (l *Learner) Learn(direction string, ex learn.Example) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	model := l.models[direction]
+	if model == nil {
+		model = learn.Train(nil)
+		l.models[direction] = model
+	}
+	model.Add(ex)
+	if err := model.Save(l.path(direction)); err != nil {
+		log.Error().Err(err).Msg("Could not save learned model")
+	}
+}
+
+// Retrain learns from every categorized withdrawal and deposit in Firefly within the configured history,
+// then saves the models.
+func (l *Learner) Retrain() error {
+	models, err := l.train(nil)
+	if err != nil {
+		return err
+	}
+
+	var docs, labels int
+	for dir, model := range models {
+		if err = model.Save(l.path(dir)); err != nil {
+			log.Error().Err(err).Msg("Could not save learned model")
+		}
+		docs += model.Docs
+		labels += len(model.Labels)
+	}
+
+	l.mu.Lock()
+	l.models = models
+	l.mu.Unlock()
+
+	log.Info().Int("Examples", docs).Int("Labels", labels).Msg("🧠 Retrained learned categorizer")
+	return nil
+}
+
+// holdout returns a learner kept in memory, trained without the transaction journals in exclude, so it can be
+// evaluated on them. Returns nil if l is.
+func (l *Learner) holdout(exclude map[string]bool) (*Learner, error) {
+	if l == nil {
+		return nil, nil
+	}
+	models, err := l.train(exclude)
+	if err != nil {
+		return nil, err
+	}
+	return &Learner{firefly: l.firefly, config: l.config, models: models}, nil
+}
+
+// train builds a model per direction from the categorized withdrawals and deposits in Firefly within the configured
+// history, leaving out the transaction journals in exclude.
+func (l *Learner) train(exclude map[string]bool) (map[string]*learn.Model, error) {
+	history := l.config.LearnedCategorizer.History
+	if history == "" {
+		history = defaultLearnHistory
+	}
+	d, err := duration.ParseDuration(history)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	txns, err := l.firefly.ListTransactions(context.Background(), firefly.TransactionsKey{
+		Start: now.Add(-d).Format(time.DateOnly),
+		End:   now.Format(time.DateOnly),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	examples := make(map[string][]learn.Example)
+	for _, group := range txns {
+		for _, t := range group.Attributes.Transactions {
+			merchant := t.DestinationName
+			switch t.Type {
+			case "withdrawal":
+			case "deposit":
+				merchant = t.SourceName
+			default:
+				continue
+			}
+			if merchant == defaultAccountName || exclude[t.JournalID] {
+				continue
+			}
+			examples[t.Type] = append(examples[t.Type], learn.Example{
+				Description: t.Description,
+				Merchant:    merchant,
+				Category:    t.CategoryName,
+			})
+		}
+	}
+
+	models := make(map[string]*learn.Model, len(directions))
+	for _, dir := range directions {
+		models[dir] = learn.Train(examples[dir])
+	}
+	return models, nil
+}
+
+// Schedule retrains the model now if it is missing or stale, then on every retrain interval until quit is closed.
+func (l *Learner) Schedule(quit <-chan struct{}) {
+	if l == nil {
+		return
+	}
+
+	interval := l.config.LearnedCategorizer.RetrainInterval
+	if interval == "" {
+		interval = defaultRetrainInterval
+	}
+	every, err := duration.ParseDuration(interval)
+	if err != nil || every <= 0 {
+		log.Error().Err(err).Str("Interval", interval).Msg("Invalid retrain interval, using " + defaultRetrainInterval)
+		every, _ = duration.ParseDuration(defaultRetrainInterval)
+	}
+
+	l.mu.RLock()
+	stale := false
+	for _, dir := range directions {
+		if model := l.models[dir]; model == nil || time.Since(model.Trained) >= every {
+			stale = true
+		}
+	}
+	l.mu.RUnlock()
+	if stale {
+		if err = l.Retrain(); err != nil {
+			log.Error().Err(err).Msg("Could not train learned categorizer")
+		}
+	}
+
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err = l.Retrain(); err != nil {
+				log.Error().Err(err).Msg("Could not retrain learned categorizer")
+			}
+		case <-quit:
+			return
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/config"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/learn"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/simplefin"
+	"github.com/shopspring/decimal"
+)
+
+func TestLearnerDirections(t *testing.T) {
+	l := &Learner{config: &config.MasterConfig{}, dir: t.TempDir(), models: make(map[string]*learn.Model)}
+	for range 3 {
+		l.Learn(direction(false), learn.Example{Description: "AMAZON MKTPLACE", Merchant: "Amazon", Category: "Shopping"})
+		l.Learn(direction(true), learn.Example{Description: "AMAZON MKTPLACE", Merchant: "Amazon", Category: "Refunds"})
+	}
+
+	purchase := simplefin.Transactions{Description: "AMAZON MKTPLACE", Amount: decimal.NewFromInt(-20)}
+	if p, ok := l.Predict(purchase); !ok || p.Category != "Shopping" {
+		t.Fatalf("Got %+v, %v, wanted a withdrawal categorized as Shopping", p, ok)
+	}
+	refund := simplefin.Transactions{Description: "AMAZON MKTPLACE", Amount: decimal.NewFromInt(20)}
+	if p, ok := l.Predict(refund); !ok || p.Category != "Refunds" {
+		t.Fatalf("Got %+v, %v, wanted a deposit categorized as Refunds", p, ok)
+	}
+
+	l.models = map[string]*learn.Model{}
+	l.Learn(direction(false), learn.Example{Description: "SHELL OIL", Merchant: "Shell", Category: "Gas"})
+	if p, ok := l.Predict(simplefin.Transactions{Description: "SHELL OIL", Amount: decimal.NewFromInt(5)}); ok {
+		t.Fatalf("Got %+v, wanted a deposit not to be predicted from withdrawals", p)
+	}
+}
@@ -0,0 +1,92 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// rotateRetry is how long a file that could not be rotated is written to as it is before rotating it is tried again.
+const rotateRetry = time.Minute
+
+// File is a log file rotated by size. When a write would take it past MaxSize, it is renamed to path.1, the previous
+// path.1 to path.2 and so on, and the oldest beyond MaxFiles is removed.
+type File struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	mu       sync.Mutex
+	f        *os.File
+	size     int64
+	retryAt  time.Time // Rotation failed, don't try again before then
+}
+
+// OpenFile opens the log file at path, appending to it. maxSize 0 never rotates it.
+func OpenFile(path string, maxSize int64, maxFiles int) (*File, error) {
+	f := &File{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("could not create log directory: %w", err)
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the file for appending and picks up its current size.
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("could not open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("could not open log file: %w", err)
+	}
+	f.f, f.size = file, info.Size()
+	return nil
+}
+
+// Write writes a log line, rotating the file first if it would grow past its maximum size. If rotating fails, the
+// line is still written, the error is reported on stderr, and rotating is tried again after rotateRetry.
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize && !time.Now().Before(f.retryAt) {
+		if err := f.rotate(); err != nil {
+			f.retryAt = time.Now().Add(rotateRetry)
+			_, _ = fmt.Fprintf(os.Stderr, "could not rotate log file %s, retrying in %s: %v\n", f.path, rotateRetry, err)
+		}
+	}
+	n, err := f.f.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the rotated files along and starts a new file, under f.mu. The file at path is opened again even
+// if closing or renaming it fails, so logging goes on in the file as it is.
+func (f *File) rotate() error {
+	closeErr := f.f.Close()
+	var renameErr error
+	if f.maxFiles <= 0 {
+		_ = os.Remove(f.path)
+	} else {
+		_ = os.Remove(fmt.Sprintf("%s.%d", f.path, f.maxFiles))
+		for i := f.maxFiles - 1; i > 0; i-- {
+			_ = os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		}
+		renameErr = os.Rename(f.path, f.path+".1")
+	}
+	return errors.Join(closeErr, renameErr, f.open())
+}
+
+// Close closes the file.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.f.Close()
+}
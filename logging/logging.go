@@ -0,0 +1,96 @@
+// Package logging sets up the importer's logger and the loggers of a sync run, carried by its context with fields such
+// as the run and account IDs added to every line they log.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Formats
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console" // Human-readable, colored
+)
+
+// Options configure the logger.
+type Options struct {
+	Level    string // trace, debug, info, warn or error (default info)
+	Format   string // json or console (default json)
+	File     string // Also write JSON lines here, if set
+	MaxSize  int64  // Bytes before the file is rotated (0 = never)
+	MaxFiles int    // Rotated files kept
+}
+
+// New creates the logger. Log lines go to stderr in the chosen format and, if there is a file, to it as JSON. The
+// returned closer closes the file.
+func New(opts Options) (zerolog.Logger, io.Closer, error) {
+	level := zerolog.InfoLevel
+	if opts.Level != "" {
+		var err error
+		if level, err = zerolog.ParseLevel(opts.Level); err != nil {
+			return zerolog.Nop(), nil, fmt.Errorf("invalid log level %q", opts.Level)
+		}
+	}
+
+	var out io.Writer
+	switch opts.Format {
+	case "", FormatJSON:
+		out = os.Stderr
+	case FormatConsole:
+		out = zerolog.ConsoleWriter{Out: os.Stderr}
+	default:
+		return zerolog.Nop(), nil, fmt.Errorf("invalid log format %q", opts.Format)
+	}
+
+	var closer io.Closer = io.NopCloser(nil)
+	if opts.File != "" {
+		f, err := OpenFile(opts.File, opts.MaxSize, opts.MaxFiles)
+		if err != nil {
+			return zerolog.Nop(), nil, err
+		}
+		out = zerolog.MultiLevelWriter(out, f)
+		closer = f
+	}
+
+	logger := zerolog.New(out).Level(level).With().Timestamp().Caller().Logger()
+	return logger, closer, nil
+}
+
+// loggerKey is the context key of a logger added by With.
+type loggerKey struct{}
+
+// With returns a copy of ctx whose logger adds key and value to every line. The logger is the one ctx already
+// carries, or the global logger.
+func With(ctx context.Context, key, value string) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger(ctx).With().Str(key, value).Logger())
+}
+
+// Ctx returns the logger carried by ctx, or the global logger. Its lines also carry ctx itself, so hooks can read
+// the trace span of the line from it.
+func Ctx(ctx context.Context) *zerolog.Logger {
+	l := logger(ctx).With().Ctx(ctx).Logger()
+	return &l
+}
+
+// logger returns the logger carried by ctx, or the global logger.
+func logger(ctx context.Context) zerolog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(zerolog.Logger); ok {
+		return l
+	}
+	return log.Logger
+}
+
+// NewRunID returns a random ID for a sync run.
+func NewRunID() string {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,141 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/logging"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func TestWith(t *testing.T) {
+	var buf bytes.Buffer
+	global := log.Logger
+	log.Logger = zerolog.New(&buf)
+	defer func() { log.Logger = global }()
+
+	run := logging.With(t.Context(), "run_id", "abc123")
+	account := logging.With(run, "account_id", "ACT-1")
+	// Another account of the same run, at the same time
+	other := logging.With(run, "account_id", "ACT-2")
+	logging.Ctx(account).Info().Msg("account")
+	logging.Ctx(other).Info().Msg("other")
+	logging.Ctx(run).Info().Msg("run")
+	logging.Ctx(t.Context()).Info().Msg("idle")
+
+	var got []map[string]string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var fields map[string]string
+		_ = json.Unmarshal([]byte(line), &fields)
+		got = append(got, fields)
+	}
+	if got[0]["run_id"] != "abc123" || got[0]["account_id"] != "ACT-1" {
+		t.Fatalf("Got %v, wanted the run and account IDs", got[0])
+	}
+	if got[1]["run_id"] != "abc123" || got[1]["account_id"] != "ACT-2" {
+		t.Fatalf("Got %v, wanted the run and other account IDs", got[1])
+	}
+	if got[2]["run_id"] != "abc123" || got[2]["account_id"] != "" {
+		t.Fatalf("Got %v, wanted only the run ID", got[2])
+	}
+	if got[3]["run_id"] != "" {
+		t.Fatalf("Got %v, wanted no run ID outside the run", got[3])
+	}
+}
+
+func TestCtxHooks(t *testing.T) {
+	var buf bytes.Buffer
+	global := log.Logger
+	log.Logger = zerolog.New(&buf).Hook(zerolog.HookFunc(func(e *zerolog.Event, _ zerolog.Level, _ string) {
+		if v, ok := e.GetCtx().Value(spanKey{}).(string); ok {
+			e.Str("span", v)
+		}
+	}))
+	defer func() { log.Logger = global }()
+
+	ctx := logging.With(context.WithValue(t.Context(), spanKey{}, "sync"), "run_id", "abc123")
+	logging.Ctx(context.WithValue(ctx, spanKey{}, "account")).Info().Msg("account")
+
+	var fields map[string]string
+	_ = json.Unmarshal(bytes.TrimSpace(buf.Bytes()), &fields)
+	if fields["span"] != "account" || fields["run_id"] != "abc123" {
+		t.Fatalf("Got %v, wanted the line's own context passed to hooks", fields)
+	}
+}
+
+type spanKey struct{}
+
+func TestNew(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "importer.log")
+	logger, closer, err := logging.New(logging.Options{Level: "warn", Format: logging.FormatConsole, File: path})
+	if err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+	logger.Info().Msg("dropped")
+	logger.Warn().Str("event", "balance.mismatch").Msg("kept")
+	_ = closer.Close()
+
+	data, _ := os.ReadFile(path)
+	var fields map[string]string
+	if err = json.Unmarshal(bytes.TrimSpace(data), &fields); err != nil || fields["event"] != "balance.mismatch" || fields["level"] != "warn" {
+		t.Fatalf("Got %q, wanted only the warning as JSON", data)
+	}
+
+	if _, _, err = logging.New(logging.Options{Level: "loud"}); err == nil {
+		t.Fatalf("Got no error for an invalid level, wanted one")
+	}
+	if _, _, err = logging.New(logging.Options{Format: "xml"}); err == nil {
+		t.Fatalf("Got no error for an invalid format, wanted one")
+	}
+}
+
+func TestFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "importer.log")
+	f, err := logging.OpenFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err = f.Write([]byte(line)); err != nil {
+			t.Fatalf("Got error %v, wanted none", err)
+		}
+	}
+	_ = f.Close()
+
+	for name, want := range map[string]string{"importer.log": "fourth\n", "importer.log.1": "third\n", "importer.log.2": "second\n"} {
+		if got, _ := os.ReadFile(filepath.Join(filepath.Dir(path), name)); string(got) != want {
+			t.Fatalf("Got %s = %q, wanted %q", name, got, want)
+		}
+	}
+	if _, err = os.Stat(path + ".3"); err == nil {
+		t.Fatalf("Got %s.3, wanted only 2 rotated files kept", path)
+	}
+}
+
+func TestFileRotationFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "importer.log")
+	f, err := logging.OpenFile(path, 10, 1)
+	if err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+	defer f.Close()
+
+	// A non-empty directory where the rotated file goes can't be replaced
+	if err = os.MkdirAll(filepath.Join(path+".1", "busy"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n"} {
+		if _, err = f.Write([]byte(line)); err != nil {
+			t.Fatalf("Got error %v, wanted the line written even though rotating failed", err)
+		}
+	}
+	if got, _ := os.ReadFile(path); string(got) != "first\nsecond\nthird\n" {
+		t.Fatalf("Got %q, wanted every line kept in the file that couldn't be rotated", got)
+	}
+}
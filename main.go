@@ -3,9 +3,11 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
@@ -13,16 +15,25 @@ import (
 
 	"github.com/alecthomas/kong"
 	"github.com/helpcomp/firefly-iii-simplefin-importer/config"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/dedupe"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/duration"
 	"github.com/helpcomp/firefly-iii-simplefin-importer/firefly"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/institution"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/logging"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/merchant"
 	"github.com/helpcomp/firefly-iii-simplefin-importer/prom"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/reconcile"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/review"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/shadow"
 	"github.com/helpcomp/firefly-iii-simplefin-importer/simplefin"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/tracing"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/usage"
 	"github.com/prometheus/client_golang/prometheus"
 	versioncollector "github.com/prometheus/client_golang/prometheus/collectors/version"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/version"
 	"github.com/prometheus/exporter-toolkit/web"
 	"github.com/rs/zerolog/log"
-	"github.com/sashabaranov/go-openai"
 )
 
 const AppName = "firefly-iii-simplefin-importer"
@@ -31,6 +42,7 @@ const AppDesc = "Go-based service that connects your SimpleFIN-enabled financial
 var cli struct {
 	MetricsPath                 string `env:"EXPORTER_METRICS_PATH" help:"${env} - Path under which to expose metrics" default:"/metrics"`
 	ConfigPath                  string `env:"CONFIG_PATH" help:"${env} - Path to config file" default:"./config.yml"`
+	DataPath                    string `env:"DATA_PATH" help:"${env} - Directory where state (reconciliation history, etc...) is stored" default:"./data"`
 	ListenAddress               string `env:"EXPORTER_LISTEN_ADDRESS" help:"${env} - Address to listen on for web interface and telemetry" default:"9717"`
 	FireflyToken                string `env:"FIREFLY_TOKEN" help:"${env} - Firefly Token" required:""`
 	FireflyBase                 string `env:"FIREFLY_URL" help:"${env} - Firefly URL" required:""`
@@ -41,41 +53,261 @@ var cli struct {
 	OpenAIAPIKey                string `env:"OPENAI_API_KEY" help:"${env} - API Key for OpenAI. If none is provided, OpenAI support is disabled"`
 	OpenAIModel                 string `env:"OPENAI_MODEL" help:"${env} - OpenAI Model type. Default is gpt-3.5-turbo-instruct" default:"gpt-3.5-turbo-instruct"`
 	RefreshTime                 uint16 `env:"REFRESH_TIME" help:"${env} - Time in minutes for refresh (Default 1440 / 1 day)" default:"1440"`
+	HealthMaxSyncAge            uint16 `env:"HEALTH_MAX_SYNC_AGE" help:"${env} - Minutes since the last successful sync before /health/ready fails (Default twice REFRESH_TIME)" default:"0"`
+	MetricsRefreshTime          uint16 `env:"METRICS_REFRESH_TIME" help:"${env} - Time in minutes between reading account and category metrics from Firefly (Default 5)" default:"5"`
+	LogLevel                    string `env:"LOG_LEVEL" help:"${env} - Minimum level logged: trace, debug, info, warn or error" enum:"trace,debug,info,warn,error" default:"info"`
+	LogFormat                   string `env:"LOG_FORMAT" help:"${env} - Log format on stderr: json or console" enum:"json,console" default:"json"`
+	LogFile                     string `env:"LOG_FILE" help:"${env} - Also write JSON logs to this file"`
+	LogMaxSize                  uint16 `env:"LOG_MAX_SIZE" help:"${env} - Megabytes before the log file is rotated, 0 to never rotate (Default 10)" default:"10"`
+	LogMaxFiles                 uint16 `env:"LOG_MAX_FILES" help:"${env} - Rotated log files kept (Default 5)" default:"5"`
 	EnablePrometheus            bool   `env:"ENABLE_PROMETHEUS" help:"${env} - Enable Prometheus metrics" default:"true"`
 	FireflyEnableReconciliation bool   `env:"ENABLE_AUTO_RECONCILIATION" help:"${env} - Enables Automatic Reconciliation of the accounts" default:"false"`
 	AutoRemoveTransactions      bool   `env:"ENABLE_AUTO_TRANSACTION_REMOVAL" help:"${env} - Removes transactions that no longer exist in SimpleFIN" default:"false"`
 	CacheOnly                   bool   `env:"DEBUG_CACHE_ONLY" help:"${env} - Cache Only - Does not query Simplefin unless no cache exists (Debug)" default:"false"`
 	DoNotUpdateTransactions     bool   `env:"DEBUG_DO_NOT_UPDATE_TRANSACTIONS" help:"${env} - Do not update / post new transactions (Debug)" default:"false"`
+
+	// Commands
+	Run         struct{} `cmd:"" default:"1" help:"Sync SimpleFIN into Firefly on a schedule (default)"`
+	Investigate struct {
+		Account string `arg:"" help:"Firefly or SimpleFIN account ID"`
+	} `cmd:"" help:"Explain a balance mismatch for a single account and exit"`
+	Review struct {
+		List    struct{} `cmd:"" help:"List categorizations waiting on review"`
+		Approve struct {
+			ID string `arg:"" help:"SimpleFIN transaction ID"`
+		} `cmd:"" help:"Approve a categorization as is"`
+		Correct struct {
+			ID       string `arg:"" help:"SimpleFIN transaction ID"`
+			Merchant string `help:"Correct merchant"`
+			Category string `help:"Correct category"`
+		} `cmd:"" help:"Correct the merchant and/or category of a transaction"`
+	} `cmd:"" help:"Review low-confidence categorizations"`
+	Categorize struct {
+		Description string `arg:"" help:"Bank transaction description"`
+		Amount      string `help:"Transaction amount, negative for withdrawals (e.g. --amount=-12.50)" default:"-1.00"`
+		Account     string `help:"Firefly asset account ID"`
+		Explain     bool   `help:"Print the prompt that would be sent instead of categorizing"`
+	} `cmd:"" help:"Categorize a transaction description and exit"`
+	Eval struct {
+		Chain   []string `help:"Categorizer chain to evaluate, from chains in the config (repeatable, default current)"`
+		Sample  int      `help:"How many categorized transactions to sample from Firefly" default:"100"`
+		History string   `help:"How far back to sample from" default:"90d"`
+		Seed    int64    `help:"Seed of the random sample" default:"1"`
+		Fixture string   `help:"Run offline against a recorded fixture file instead of Firefly" type:"path"`
+		Record  string   `help:"Save the sampled transactions as a fixture file" type:"path"`
+	} `cmd:"" help:"Score categorizer chains against transactions already categorized in Firefly"`
+	Shadow struct {
+		Report struct{} `cmd:"" help:"Print where the shadow chain disagreed with the categorizer"`
+	} `cmd:"" help:"Inspect shadow mode"`
+	Merchants struct {
+		Dedupe struct {
+			Threshold float64 `help:"How similar names must be, from 0 to 1" default:"0.9"`
+			Yes       bool    `help:"Merge without asking"`
+		} `cmd:"" help:"Merge expense accounts with similar names"`
+	} `cmd:"" help:"Manage merchant (expense) accounts"`
+	Cache struct {
+		List struct {
+			Filter string `arg:"" optional:"" help:"Only list entries containing this text"`
+		} `cmd:"" help:"List cached categorizations"`
+		Purge struct {
+			Filter    string `arg:"" optional:"" help:"Only purge entries containing this text"`
+			All       bool   `help:"Purge every entry"`
+			OlderThan string `help:"Only purge entries older than this duration (e.g. 30d)"`
+		} `cmd:"" help:"Remove cached categorizations"`
+	} `cmd:"" help:"Inspect or purge the categorization cache"`
 }
 
 func main() {
 	// Variable Setup //
 	///////////////////
-	kong.Parse(&cli,
+	ktx := kong.Parse(&cli,
 		kong.Name(AppName),
 		kong.Description(AppDesc),
 	)
-	log.Logger = log.Output(os.Stderr).With().Caller().Logger()                                   // Logger
-	var oai *openai.Client                                                                        // OpenAI
-	sf := simplefin.New(cli.SimplefinAccessURL, cli.CacheOnly)                                    // Simplefin
-	ff := firefly.New(&http.Client{Timeout: time.Second * 30}, cli.FireflyToken, cli.FireflyBase) // Firefly
-	cfg := config.InitConfig(cli.ConfigPath)                                                      // Config
+	// Deferred first so it runs last, after everything else has been flushed
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+	logger, logFile, err := logging.New(logging.Options{
+		Level:    cli.LogLevel,
+		Format:   cli.LogFormat,
+		File:     cli.LogFile,
+		MaxSize:  int64(cli.LogMaxSize) << 20,
+		MaxFiles: int(cli.LogMaxFiles),
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Unable to set up logging")
+	}
+	defer logFile.Close()
+	log.Logger = logger.Hook(prom.ErrorHook{}, tracing.LogHook{})                                          // Logger
+	sf := simplefin.New(prom.NewClient("simplefin", 2*time.Minute), cli.SimplefinAccessURL, cli.CacheOnly) // Simplefin
+	ff := firefly.New(prom.NewClient("firefly", 30*time.Second), cli.FireflyToken, cli.FireflyBase)        // Firefly
+	cfg := config.InitConfig(cli.ConfigPath)                                                               // Config
 	var simplefinAccounts []simplefin.Accounts
 
+	// Reconciliation History
+	history, err := reconcile.NewHistory(filepath.Join(cli.DataPath, "reconciliation.json"), cfg.Reconciliation.HistorySize)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to load reconciliation history, starting a new one")
+	}
+
+	// Manually entered transaction matching, remembering adoptions while SimpleFIN may still return them
+	adoptionWindow, err := duration.ParseDuration(cli.SimplefinLoopbackDuration)
+	if err != nil {
+		log.Error().Err(err).Msg("Error parsing Simplefin Loopback Duration, keeping adopted transactions forever")
+	} else {
+		adoptionWindow += 24 * time.Hour
+	}
+	duplicates, err := dedupe.NewStore(filepath.Join(cli.DataPath, "duplicates.json"), adoptionWindow)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to load duplicate review list, starting a new one")
+	}
+
+	investigator := NewInvestigator(ff, cfg)
+	cache := openCategorizationCache(cfg)
+
 	// AI Setup //
 	/////////////
-	// OpenAI
-	if cli.OpenAIAPIKey != "" {
-		oai = openai.NewClient(cli.OpenAIAPIKey)
-	}
-	// AzureAI
-	if cli.AzureAIAPIKey != "" {
-		if cli.AzureEndpoint == "" {
-			log.Error().Msg("Azure Endpoint is required if Azure API Key is provided")
-		} else {
-			oai = openai.NewClientWithConfig(openai.DefaultAzureConfig(cli.AzureAIAPIKey, cli.AzureEndpoint))
+	provider := newProviderClient(cfg) // OpenAI or Azure
+	oai := NewAIClient(cfg, provider)  // Then the fallback models
+
+	// Merchant aliases
+	aliases, err := merchant.Open(filepath.Join(cli.DataPath, "merchant_aliases.json"))
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to load merchant aliases, starting a new table")
+	}
+
+	// AI usage against the monthly budget
+	budget := usage.Limits{Tokens: cfg.AI.Budget.MonthlyTokens, Dollars: cfg.AI.Budget.MonthlyDollars}
+	tracker, err := usage.Open(filepath.Join(cli.DataPath, "ai_usage.json"), budget)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to load AI usage, starting from zero")
+	}
+
+	notifier := buildNotifier(cfg)
+	defer notifier.Close() // Sends what is still queued on exit, after commands too
+	learner := NewLearner(ff, cfg)
+	embedder := NewEmbedder(ff, cfg, provider, tracker, notifier)
+	categorizer := NewCategorizer(ff, cfg, oai, cache, learner, embedder, aliases, tracker, notifier)
+	categorizer.logBatching()
+
+	// Review queue
+	reviewQueue, err := review.Open(filepath.Join(cli.DataPath, "review.json"))
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to load review queue, starting a new one")
+	}
+	reviewer := NewReviewer(ff, cfg, reviewQueue, categorizer)
+
+	// Shadow categorizer chain
+	shadowLog, err := shadow.Open(filepath.Join(cli.DataPath, "shadow.json"), cfg.Shadow.Disagreements)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to load shadow comparisons, starting new ones")
+	}
+	shadower := NewShadow(categorizer, cfg, shadowLog)
+	defer flushState(cache, tracker, shadowLog) // On exit, after commands too
+
+	// Bank connection health
+	var staleAfter time.Duration
+	if cfg.Institutions.StaleAfter != "" {
+		if staleAfter, err = duration.ParseDuration(cfg.Institutions.StaleAfter); err != nil {
+			log.Error().Err(err).Str("StaleAfter", cfg.Institutions.StaleAfter).Msg("Invalid institutions.stale_after, using the default")
 		}
 	}
+	institutions, err := institution.Open(filepath.Join(cli.DataPath, "institutions.json"), staleAfter)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to load institution health, starting over")
+	}
+	prom.Institutions.Set(institutions.Statuses())
+
+	// Commands //
+	/////////////
+	// Commands return their error rather than exiting, so the deferred notifier and state flushes still run
+	runCommand := func(command string) error {
+		switch command {
+		case "cache list", "cache list <filter>":
+			if err := runCacheListCommand(cache, cli.Cache.List.Filter, os.Stdout); err != nil {
+				return fmt.Errorf("could not list the categorization cache: %w", err)
+			}
+		case "cache purge", "cache purge <filter>":
+			if err := runCachePurgeCommand(cache, cli.Cache.Purge.Filter, cli.Cache.Purge.All, cli.Cache.Purge.OlderThan, os.Stdout); err != nil {
+				return fmt.Errorf("could not purge the categorization cache: %w", err)
+			}
+		case "review list":
+			if err := runReviewListCommand(reviewer, os.Stdout); err != nil {
+				return fmt.Errorf("could not list the review queue: %w", err)
+			}
+		case "review approve <id>":
+			if err := reviewer.Resolve(context.Background(), cli.Review.Approve.ID, "", ""); err != nil {
+				return fmt.Errorf("could not approve the categorization: %w", err)
+			}
+		case "review correct <id>":
+			if err := reviewer.Resolve(context.Background(), cli.Review.Correct.ID, cli.Review.Correct.Merchant, cli.Review.Correct.Category); err != nil {
+				return fmt.Errorf("could not correct the categorization: %w", err)
+			}
+		case "categorize <description>":
+			c := cli.Categorize
+			if err := runCategorizeCommand(categorizer, buildNormalizer(cfg), c.Description, c.Amount, c.Account, c.Explain, os.Stdout); err != nil {
+				return fmt.Errorf("could not categorize the description: %w", err)
+			}
+		case "eval":
+			e := cli.Eval
+			opts := evalOptions{Chains: e.Chain, Sample: e.Sample, History: e.History, Seed: e.Seed, Fixture: e.Fixture, Record: e.Record}
+			if err := runEvalCommand(categorizer, opts, os.Stdout); err != nil {
+				return fmt.Errorf("evaluation failed: %w", err)
+			}
+		case "shadow report":
+			if err := runShadowReportCommand(shadowLog, cfg.Shadow.Chain, os.Stdout); err != nil {
+				return fmt.Errorf("could not print the shadow report: %w", err)
+			}
+		case "merchants dedupe":
+			if err := runMerchantsDedupeCommand(ff, aliases, cfg.Matching.Synonyms, cli.Merchants.Dedupe.Threshold, cli.Merchants.Dedupe.Yes, os.Stdin, os.Stdout); err != nil {
+				return fmt.Errorf("could not dedupe merchants: %w", err)
+			}
+		case "investigate <account>":
+			if err := runInvestigateCommand(context.Background(), sf, investigator, cli.Investigate.Account, os.Stdout); err != nil {
+				return fmt.Errorf("investigation failed: %w", err)
+			}
+		default:
+			return fmt.Errorf("unknown command %q", command)
+		}
+		return nil
+	}
+	if command := ktx.Command(); command != "run" {
+		if err := runCommand(command); err != nil {
+			log.Error().Err(err).Str("Command", command).Msg("Command failed")
+			exitCode = 1
+		}
+		return
+	}
+
+	// Create SyncApp once for all syncs (avoids rebuilding transferBypasses map for each account)
+	syncApp := NewSyncApp(ff, cfg, categorizer, history, investigator, duplicates, reviewer, shadower, notifier, institutions)
+
+	// Tracing
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		Headers:     cfg.Tracing.Headers,
+		File:        cfg.Tracing.File,
+		SampleRatio: cfg.Tracing.SampleRatio,
+		Service:     AppName,
+		Version:     version.Version,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to set up tracing, sync runs will not be traced")
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		log.Info().Msg("Flushing traces")
+		if err := shutdownTracing(ctx); err != nil {
+			log.Error().Err(err).Msg("Could not flush traces")
+		}
+	}()
 
 	// Start //
 	///////////
@@ -91,9 +323,22 @@ func main() {
 	ticker := time.NewTicker(time.Duration(cli.RefreshTime) * time.Minute)
 	quit := make(chan struct{})
 
+	// Retrain the learned categorizer on a schedule
+	go learner.Schedule(quit)
+
+	// Index Firefly history for the embedding categorizer on a schedule
+	go embedder.Schedule(quit)
+
+	// Save cache hits, AI usage and shadow comparisons in the background
+	go scheduleFlush(stateFlushInterval, quit, cache, tracker, shadowLog)
+
+	// Account and category metrics, read from Firefly in the background
+	exporter := prom.NewExporter(AppName, ff, cfg, simplefinAccounts)
+
 	// Immediately start a refresh of the data in the background
 	go func() {
-		simplefinAccounts = startUpdate(sf, ff, cfg, oai)
+		simplefinAccounts = startUpdate(context.Background(), sf, syncApp)
+		exporter.SetSimpleFinAccounts(simplefinAccounts)
 	}()
 
 	// No Prometheus Support, refresh only
@@ -102,7 +347,7 @@ func main() {
 		for {
 			select {
 			case <-ticker.C:
-				simplefinAccounts = startUpdate(sf, ff, cfg, oai)
+				simplefinAccounts = startUpdate(context.Background(), sf, syncApp)
 			case <-quit:
 				ticker.Stop()
 				return
@@ -119,20 +364,38 @@ func main() {
 		for {
 			select {
 			case <-ticker.C:
-				simplefinAccounts = startUpdate(sf, ff, cfg, oai)
+				simplefinAccounts = startUpdate(context.Background(), sf, syncApp)
+				exporter.SetSimpleFinAccounts(simplefinAccounts)
 			case <-quit:
 				ticker.Stop()
 				return
 			}
 		}
 	}()
+	go exporter.Schedule(time.Duration(max(cli.MetricsRefreshTime, 1))*time.Minute, quit)
 
 	// Metric Registration
 	prometheus.MustRegister(
 		versioncollector.NewCollector(AppName),
-		prom.NewExporter(AppName, ff, cfg, simplefinAccounts),
+		exporter,
 	)
 
+	// Readiness checks
+	maxSyncAge := time.Duration(cli.HealthMaxSyncAge) * time.Minute
+	if maxSyncAge == 0 {
+		maxSyncAge = 2 * time.Duration(cli.RefreshTime) * time.Minute
+	}
+	health := prom.NewHealth()
+	health.Add("firefly", ff.CheckUser)
+	health.Add("simplefin", sf.Info)
+	health.Add("sync", prom.SyncCheck(maxSyncAge))
+	// The config is only read at startup, so its check reports what was loaded then
+	var configErr error
+	if len(cfg.Accounts) == 0 {
+		configErr = errors.New("no accounts are mapped")
+	}
+	health.Add("config", func() error { return configErr })
+
 	// HTTP Server
 	http.Handle(cli.MetricsPath, promhttp.Handler())
 	if cli.MetricsPath != "/" && cli.MetricsPath != "" {
@@ -146,8 +409,36 @@ func main() {
 					Text:    "Metrics",
 				},
 				{
-					Address: "/health",
-					Text:    "Health",
+					Address: "/health/live",
+					Text:    "Liveness",
+				},
+				{
+					Address: "/health/ready",
+					Text:    "Readiness",
+				},
+				{
+					Address: "/reconciliation",
+					Text:    "Reconciliation History",
+				},
+				{
+					Address: "/duplicates",
+					Text:    "Possible Duplicates (Review)",
+				},
+				{
+					Address: "/review",
+					Text:    "Categorizations Waiting on Review",
+				},
+				{
+					Address: "/shadow",
+					Text:    "Shadow Categorizer Disagreements",
+				},
+				{
+					Address: "/institutions",
+					Text:    "Bank Connection Health",
+				},
+				{
+					Address: "/investigate",
+					Text:    "Investigate Balance Mismatch (?account=ID)",
 				},
 			},
 		}
@@ -158,6 +449,14 @@ func main() {
 		}
 		http.Handle("/", landingPage)
 		http.HandleFunc("/health", prom.HealthHandler)
+		http.HandleFunc("/health/live", health.HandleLive)
+		http.HandleFunc("/health/ready", health.HandleReady)
+		http.HandleFunc("/reconciliation", history.HandleHistory)
+		http.HandleFunc("/investigate", investigator.HandleInvestigate)
+		http.HandleFunc("/duplicates", duplicates.HandleReviews)
+		http.HandleFunc("/review", reviewer.HandleReview)
+		http.HandleFunc("/shadow", shadower.HandleShadow)
+		http.HandleFunc("/institutions", institutions.HandleInstitutions)
 	}
 
 	log.Info().Msgf("Starting HTTP server on listen address :%s and metric path %s", cli.ListenAddress, cli.MetricsPath)
@@ -0,0 +1,150 @@
+// Package match finds the closest name in a list, for AI answers that are near but not exactly a Firefly name
+package match
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/forPelevin/gomoji"
+)
+
+// Reasons a name matched
+const (
+	ReasonExact        = "exact"
+	ReasonNormalized   = "normalized"
+	ReasonSynonym      = "synonym"
+	ReasonEditDistance = "edit distance"
+	ReasonTokenOverlap = "token overlap"
+)
+
+// Result is the best match for a name.
+type Result struct {
+	Index  int     // Position in the candidate list
+	Name   string  // The matched candidate
+	Score  float64 // From 0 to 1
+	Reason string
+}
+
+var stopWords = map[string]bool{"and": true, "the": true, "of": true, "for": true, "a": true}
+
+// Normalize removes emoji, case, punctuation and extra whitespace from a name, and reduces simple plurals,
+// so "🛒 Groceries" and "grocery" both become "grocery".
+func Normalize(name string) string {
+	return strings.Join(tokens(name), " ")
+}
+
+// tokens splits a name into normalized words.
+func tokens(name string) []string {
+	name = strings.ReplaceAll(gomoji.RemoveEmojis(strings.ToLower(name)), "&", " and ")
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, w := range words {
+		words[i] = singular(w)
+	}
+	return words
+}
+
+// singular reduces common English plurals.
+func singular(w string) string {
+	switch {
+	case len(w) > 4 && strings.HasSuffix(w, "ies"):
+		return w[:len(w)-3] + "y"
+	case len(w) > 3 && strings.HasSuffix(w, "s") && !strings.HasSuffix(w, "ss"):
+		return w[:len(w)-1]
+	}
+	return w
+}
+
+// Best returns the candidate closest to name scoring at least threshold. synonyms maps a candidate to other
+// names that mean it. Exact and normalized matches win outright, then synonyms, then the higher of the
+// edit-distance and token-overlap scores.
+func Best(name string, candidates []string, synonyms map[string][]string, threshold float64) (Result, bool) {
+	if name == "" {
+		return Result{}, false
+	}
+
+	norm := Normalize(name)
+	for i, c := range candidates {
+		if c == name {
+			return Result{Index: i, Name: c, Score: 1, Reason: ReasonExact}, true
+		}
+	}
+	for i, c := range candidates {
+		if Normalize(c) == norm {
+			return Result{Index: i, Name: c, Score: 1, Reason: ReasonNormalized}, true
+		}
+	}
+	for i, c := range candidates {
+		for _, s := range synonyms[c] {
+			if Normalize(s) == norm {
+				return Result{Index: i, Name: c, Score: 1, Reason: ReasonSynonym}, true
+			}
+		}
+	}
+
+	best := Result{Index: -1}
+	for i, c := range candidates {
+		normC := Normalize(c)
+		if score := editScore(norm, normC); score > best.Score {
+			best = Result{Index: i, Name: c, Score: score, Reason: ReasonEditDistance}
+		}
+		if score := overlapScore(tokens(name), tokens(c)); score > best.Score {
+			best = Result{Index: i, Name: c, Score: score, Reason: ReasonTokenOverlap}
+		}
+	}
+	return best, best.Index >= 0 && best.Score >= threshold
+}
+
+// editScore is 1 minus the Levenshtein distance divided by the longer length.
+func editScore(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 0
+	}
+
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return 1 - float64(prev[len(rb)])/float64(longest)
+}
+
+// overlapScore is the share of meaningful words in common, relative to the name with more of them.
+func overlapScore(a, b []string) float64 {
+	setA, setB := wordSet(a), wordSet(b)
+	if len(setA) == 0 || len(setB) == 0 {
+		return 0
+	}
+
+	common := 0
+	for w := range setA {
+		if setB[w] {
+			common++
+		}
+	}
+	return float64(common) / float64(max(len(setA), len(setB)))
+}
+
+func wordSet(words []string) map[string]bool {
+	set := make(map[string]bool, len(words))
+	for _, w := range words {
+		if !stopWords[w] {
+			set[w] = true
+		}
+	}
+	return set
+}
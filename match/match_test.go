@@ -0,0 +1,31 @@
+package match_test
+
+import (
+	"testing"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/match"
+)
+
+func TestBest(t *testing.T) {
+	categories := []string{"🛒 Groceries", "Restaurants & Dining", "Gas & Fuel", "Utilities"}
+	synonyms := map[string][]string{"Restaurants & Dining": {"Dining Out"}}
+
+	tests := []struct {
+		name, want, reason string
+	}{
+		{"Grocery", "🛒 Groceries", match.ReasonNormalized},
+		{"dining out", "Restaurants & Dining", match.ReasonSynonym},
+		{"Utilites", "Utilities", match.ReasonEditDistance},
+		{"Fuel and Gas", "Gas & Fuel", match.ReasonTokenOverlap},
+	}
+	for _, tt := range tests {
+		r, ok := match.Best(tt.name, categories, synonyms, 0.8)
+		if !ok || r.Name != tt.want || r.Reason != tt.reason {
+			t.Fatalf("Got %v (%v) for %q, wanted %s by %s", r, ok, tt.name, tt.want, tt.reason)
+		}
+	}
+
+	if r, ok := match.Best("Travel", categories, synonyms, 0.8); ok {
+		t.Fatalf("Got %v for Travel, wanted no match", r)
+	}
+}
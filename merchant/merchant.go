@@ -0,0 +1,144 @@
+// Package merchant maps the many spellings of a merchant onto one Firefly account
+package merchant
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/internal/jsonfile"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/match"
+	"github.com/rs/zerolog/log"
+)
+
+// suffixes are dropped from the end of names when building keys, so "Amazon.com" and "Amazon Inc" match "Amazon".
+var suffixes = []string{"com", "net", "org", "inc", "llc", "ltd", "co", "corp", "corporation", "company", "marketplace", "mktp", "store", "online"}
+
+// Key reduces a merchant name to lowercase letters and digits, without common corporate and web suffixes.
+func Key(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for len(words) > 1 && isSuffix(words[len(words)-1]) {
+		words = words[:len(words)-1]
+	}
+	return strings.Join(words, "")
+}
+
+func isSuffix(word string) bool {
+	for _, s := range suffixes {
+		if word == s {
+			return true
+		}
+	}
+	return false
+}
+
+// similar reports whether two merchant names are the same key, or match at least threshold either way round (see
+// the match package).
+func similar(a, b string, synonyms map[string][]string, threshold float64) bool {
+	ka, kb := Key(a), Key(b)
+	if ka == "" || kb == "" {
+		return false
+	}
+	if ka == kb {
+		return true
+	}
+	_, ab := match.Best(a, []string{b}, synonyms, threshold)
+	_, ba := match.Best(b, []string{a}, synonyms, threshold)
+	return ab || ba
+}
+
+// Group clusters accounts (ID -> name) whose names are similar, using the matching synonyms and threshold. Only
+// clusters of two or more are returned, each sorted by ID, and the clusters sorted by their first ID.
+func Group(names map[string]string, synonyms map[string][]string, threshold float64) [][]string {
+	ids := make([]string, 0, len(names))
+	for id := range names {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	// Union-find over every similar pair
+	parent := make(map[string]string, len(ids))
+	var find func(string) string
+	find = func(id string) string {
+		if parent[id] == "" || parent[id] == id {
+			return id
+		}
+		parent[id] = find(parent[id])
+		return parent[id]
+	}
+	for i := range ids {
+		for j := i + 1; j < len(ids); j++ {
+			if similar(names[ids[i]], names[ids[j]], synonyms, threshold) {
+				parent[find(ids[j])] = find(ids[i])
+			}
+		}
+	}
+
+	clusters := make(map[string][]string)
+	for _, id := range ids {
+		root := find(id)
+		clusters[root] = append(clusters[root], id)
+	}
+
+	var groups [][]string
+	for _, c := range clusters {
+		if len(c) > 1 {
+			groups = append(groups, c)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0] < groups[j][0] })
+	return groups
+}
+
+// Table is the learned alias table: variant names mapped to the Firefly account they were merged into.
+type Table struct {
+	path    string
+	Aliases map[string]string `json:"aliases"` // Key(variant) -> Firefly account ID
+	mu      sync.Mutex
+}
+
+// Open loads the learned aliases saved at path; there are none until a merge is recorded.
+func Open(path string) (*Table, error) {
+	t := &Table{
+		path:    path,
+		Aliases: make(map[string]string),
+	}
+
+	if err := jsonfile.Load(path, t); err != nil {
+		return t, fmt.Errorf("could not load merchant aliases: %w", err)
+	}
+	if t.Aliases == nil {
+		t.Aliases = make(map[string]string)
+	}
+	return t, nil
+}
+
+// Lookup returns the account a merchant name is an alias of.
+func (t *Table) Lookup(name string) (string, bool) {
+	if t == nil {
+		return "", false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	id, ok := t.Aliases[Key(name)]
+	return id, ok
+}
+
+// Set records name as an alias of a Firefly account.
+func (t *Table) Set(name, accountID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Aliases[Key(name)] = accountID
+	t.save()
+}
+
+// save persists the learned aliases, with t.mu held.
+func (t *Table) save() {
+	if err := jsonfile.Save(t.path, t); err != nil {
+		log.Error().Err(err).Msg("Could not save merchant aliases")
+	}
+}
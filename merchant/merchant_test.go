@@ -0,0 +1,38 @@
+package merchant_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/merchant"
+)
+
+func TestGroup(t *testing.T) {
+	if k := merchant.Key("Amazon.com, Inc."); k != "amazon" {
+		t.Fatalf("Got key %q, wanted amazon", k)
+	}
+
+	groups := merchant.Group(map[string]string{
+		"1": "Amazon",
+		"2": "Amazon.com",
+		"3": "AMZN Marketplace",
+		"4": "Shell",
+		"5": "Kroger",
+		"6": "Krogers",
+	}, map[string][]string{"Amazon": {"AMZN Marketplace"}}, 0.9)
+	if !reflect.DeepEqual(groups, [][]string{{"1", "2", "3"}, {"5", "6"}}) {
+		t.Fatalf("Got %v, wanted [[1 2 3] [5 6]]", groups)
+	}
+
+	path := filepath.Join(t.TempDir(), "aliases.json")
+	table, _ := merchant.Open(path)
+	table.Set("AMZN Marketplace", "1")
+	table, err := merchant.Open(path)
+	if err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+	if id, ok := table.Lookup("amzn"); !ok || id != "1" {
+		t.Fatalf("Got %q (%v), wanted 1", id, ok)
+	}
+}
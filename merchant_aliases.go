@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/firefly"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/logging"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/match"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/merchant"
+)
+
+const defaultDedupeThreshold = 0.9
+
+// CanonicalMerchant replaces the merchant of a categorization with the Firefly account it is an alias of, so
+// "Amazon.com" and "AMZN Marketplace" both land on the one "Amazon" account. Aliases are looked up in the config,
+// then the learned alias table, then among the existing accounts of accountType (expense or revenue) by name,
+// first exactly and then fuzzily.
+func (c *Categorizer) CanonicalMerchant(ctx context.Context, extracted ExtractedData, accountType string) ExtractedData {
+	if extracted.CompanyID != "" || extracted.Company == "" || extracted.Company == defaultAccountName {
+		return extracted
+	}
+
+	accounts, err := c.firefly.CachedAccounts()
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Str("event", "categorization.failed").Msg("Error getting cached accounts")
+		return extracted
+	}
+	if acct, ok := accounts.AccountsByName[extracted.Company]; ok && acct.Attributes.Type == accountType {
+		return extracted // Already an account
+	}
+
+	key := merchant.Key(extracted.Company)
+	accountID := c.configuredAlias(key)
+	if accountID == "" {
+		accountID, _ = c.aliases.Lookup(extracted.Company)
+	}
+	if accountID == "" {
+		for _, acct := range accounts.Accounts {
+			if acct.Attributes.Type == accountType && merchant.Key(acct.Attributes.Name) == key {
+				accountID = acct.ID
+				break
+			}
+		}
+	}
+	if accountID == "" && extracted.Source != sourceRule && extracted.Source != sourceReview {
+		accountID = c.fuzzyMerchant(ctx, extracted.Company, accountType, accounts.Accounts)
+	}
+
+	acct, ok := accounts.AccountsByID[accountID]
+	if !ok || acct.Attributes.Type != accountType {
+		return extracted
+	}
+
+	logging.Ctx(ctx).Debug().Str("Merchant", extracted.Company).Str("Account", acct.Attributes.Name).Msg("Merchant alias applied")
+	extracted.Company = acct.Attributes.Name
+	extracted.CompanyID = acct.ID
+	return extracted
+}
+
+// configuredAlias returns the account of the first configured merchant alias with a name matching key, or "" if
+// there is none.
+func (c *Categorizer) configuredAlias(key string) string {
+	for _, alias := range c.config.MerchantAliases {
+		for _, name := range alias.Names {
+			if merchant.Key(name) == key {
+				return alias.AccountID
+			}
+		}
+	}
+	return ""
+}
+
+// fuzzyMerchant returns the ID of the account of accountType whose name is closest to name, if it is close enough.
+func (c *Categorizer) fuzzyMerchant(ctx context.Context, name, accountType string, accounts []firefly.Account) string {
+	var names, ids []string
+	for _, acct := range accounts {
+		if acct.Attributes.Type == accountType {
+			names = append(names, acct.Attributes.Name)
+			ids = append(ids, acct.ID)
+		}
+	}
+
+	result, ok := match.Best(name, names, c.config.Matching.Synonyms, c.matchThreshold())
+	if !ok {
+		return ""
+	}
+	logging.Ctx(ctx).Info().Str("Merchant", name).Str("Matched", result.Name).Str("Reason", result.Reason).Float64("Score", result.Score).Msg("Matched merchant")
+	return ids[result.Index]
+}
+
+// counterpartType returns the Firefly account type on the other side of a transaction from the asset account.
+func counterpartType(t firefly.Transaction) string {
+	if t.Type == "deposit" {
+		return "revenue"
+	}
+	return "expense"
+}
+
+// runMerchantsDedupeCommand finds groups of expense accounts with similar names (see merchant.Group) and, once
+// confirmed, moves their transactions onto the account with the most transactions. The merged names are remembered as aliases.
+// The emptied accounts are left in Firefly to be deleted by hand.
+func runMerchantsDedupeCommand(ff *firefly.Firefly, aliases *merchant.Table, synonyms map[string][]string, threshold float64, yes bool, in io.Reader, out io.Writer) error {
+	if threshold <= 0 {
+		threshold = defaultDedupeThreshold
+	}
+
+	accounts, err := ff.ListAccounts("expense")
+	if err != nil {
+		return err
+	}
+	names := make(map[string]string, len(accounts))
+	for _, acct := range accounts {
+		names[acct.ID] = acct.Attributes.Name
+	}
+
+	groups := merchant.Group(names, synonyms, threshold)
+	if len(groups) == 0 {
+		_, _ = fmt.Fprintln(out, "No similar expense accounts found")
+		return nil
+	}
+
+	reader := bufio.NewReader(in)
+	for _, group := range groups {
+		// The account with the most transactions is kept
+		txns := make(map[string][]firefly.Transactions, len(group))
+		canonical := group[0]
+		for _, id := range group {
+			if txns[id], err = ff.AccountTransactionsRange(context.Background(), id, firefly.TransactionsKey{}); err != nil {
+				return err
+			}
+			if len(txns[id]) > len(txns[canonical]) {
+				canonical = id
+			}
+		}
+
+		_, _ = fmt.Fprintf(out, "\nKeep %q (%s, %d transactions) and merge:\n", names[canonical], canonical, len(txns[canonical]))
+		for _, id := range group {
+			if id != canonical {
+				_, _ = fmt.Fprintf(out, "  %q (%s, %d transactions)\n", names[id], id, len(txns[id]))
+			}
+		}
+
+		if !yes {
+			_, _ = fmt.Fprint(out, "Merge? [y/N] ")
+			answer, _ := reader.ReadString('\n')
+			if !strings.EqualFold(strings.TrimSpace(answer), "y") {
+				continue
+			}
+		}
+
+		for _, id := range group {
+			if id == canonical {
+				continue
+			}
+			moved := 0
+			for _, t := range txns[id] {
+				for _, split := range t.Attributes.Transactions {
+					if split.DestinationID != id {
+						continue
+					}
+					if err = ff.PatchTransaction(context.Background(), t.ID, split.JournalID, map[string]any{"destination_id": canonical}); err != nil {
+						return fmt.Errorf("could not move transaction %s: %w", t.ID, err)
+					}
+					moved++
+				}
+			}
+			aliases.Set(names[id], canonical)
+			_, _ = fmt.Fprintf(out, "Moved %d transactions from %q to %q\n", moved, names[id], names[canonical])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/config"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/normalize"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/simplefin"
+	"github.com/rs/zerolog/log"
+)
+
+// buildNormalizer compiles the description normalizer, or returns nil if normalization is disabled.
+// Invalid configured patterns are logged and the built-in patterns are used on their own.
+func buildNormalizer(cfg *config.MasterConfig) *normalize.Normalizer {
+	if cfg.Normalization.Disabled {
+		return nil
+	}
+
+	n, err := normalize.New(cfg.Normalization.Patterns)
+	if err != nil {
+		log.Error().Err(err).Msg("Ignoring configured normalization patterns")
+		n, _ = normalize.New(nil)
+	}
+	return n
+}
+
+// NormalizeDescriptions replaces the description of every SimpleFIN transaction with its normalized form. The
+// original is kept in RawDescription, for bypass rules written against it and for the Firefly notes.
+func (s *SyncApp) NormalizeDescriptions(accounts []simplefin.Accounts) {
+	if s.normalizer == nil {
+		return
+	}
+
+	for i := range accounts {
+		for j := range accounts[i].Transactions {
+			trans := &accounts[i].Transactions[j]
+			if trans.RawDescription != "" {
+				continue // Already normalized
+			}
+			trans.RawDescription = trans.Description
+			trans.Description = s.normalizer.Clean(trans.Description)
+		}
+	}
+}
+
+// descriptionContains reports whether the normalized or raw description of a transaction contains text.
+func descriptionContains(trans simplefin.Transactions, text string) bool {
+	return strings.Contains(trans.Description, text) || (trans.RawDescription != "" && strings.Contains(trans.RawDescription, text))
+}
+
+// rawDescriptionNote returns the bank's original description when normalization changed it, for the Firefly notes.
+func rawDescriptionNote(trans simplefin.Transactions) string {
+	if trans.RawDescription == "" || trans.RawDescription == trans.Description {
+		return ""
+	}
+	return trans.RawDescription
+}
@@ -0,0 +1,72 @@
+// Package normalize cleans up bank transaction descriptions into something close to the merchant name
+package normalize
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// states are the US state, territory, and Canadian province codes banks append to descriptions.
+const states = `AL|AK|AZ|AR|CA|CO|CT|DE|DC|FL|GA|HI|ID|IL|IN|IA|KS|KY|LA|ME|MD|MA|MI|MN|MS|MO|MT|NE|NV|NH|NJ|NM|NY|NC|ND|OH|OK|OR|PA|RI|SC|SD|TN|TX|UT|VT|VA|WA|WV|WI|WY|PR|VI|GU|AB|BC|MB|NB|NL|NS|ON|PE|QC|SK`
+
+// Builtin is the pattern library applied before any configured patterns. Every match is removed.
+var Builtin = []string{
+	// Payment processor prefixes: "SQ *BLUE BOTTLE", "TST* JOES PIZZA", "PAYPAL *SPOTIFY"
+	`^(?i)(SQ|SQU|TST|SP|PY|IC|DD|DNH|PAYPAL|PP|GOOGLE|GGL|AMZN|APL|APPLE\.COM/BILL|IN|BT|CKE|LS|POS|ACH|DEBIT|PURCHASE|RECURRING)\s*\*\s*`,
+	`^(?i)(POS|DEBIT CARD|CHECKCARD|CHECK CARD|PURCHASE|RECURRING PAYMENT)\s+(PURCHASE\s+)?`,
+	// Dates: "03/15", "03/15/25", "2025-03-15"
+	`\b\d{1,2}/\d{1,2}(/\d{2,4})?\b`,
+	`\b\d{4}-\d{2}-\d{2}\b`,
+	// Card number fragments: "XXXX1234", "*1234", "CARD 1234", "x1234"
+	`(?i)\b(X{2,}|\*{2,})\d{2,4}\b`,
+	`(?i)\bCARD\s*(ENDING\s*(IN)?\s*)?#?\s*\d{4}\b`,
+	`(?i)(^|\s)[*x]\d{4}\b`,
+	// City and state suffix after a phone or store number: "1234 OAKLAND CA", "#5521 SAN JOSE CA". Without the
+	// number in front, the last words are as likely to be part of the merchant, as in "HOME DEPOT CA" or "CHECK IN".
+	`(\b\d{3}[-.]\d{3}[-.]\d{4}|#\s*\d+|\b\d{3,})(\s+[A-Z][A-Z.'-]*){0,2}\s+(` + states + `)\s*$`,
+	// Phone numbers: "866-579-7172"
+	`\b\d{3}[-.]\d{3}[-.]\d{4}\b`,
+	// Store numbers: "#1234", "1234", "NO. 12". Bare numbers only on their own, as digits joined to a word are part of
+	// the merchant, as in "1-800-FLOWERS".
+	`(?i)\b(STORE|NO\.?)\s*#?\s*\d+\b`,
+	`#\s*\d+\b`,
+	`(^|\s)\d{3,}(\s+\d{3,})*(\s|$)`,
+}
+
+var (
+	spaces   = regexp.MustCompile(`\s+`)
+	trailing = regexp.MustCompile(`^[\s*#.,:;/-]+|[\s*#.,:;/-]+$`)
+)
+
+// Normalizer removes noise from descriptions using the built-in patterns plus any extra ones.
+type Normalizer struct {
+	patterns []*regexp.Regexp
+}
+
+// New compiles the built-in patterns followed by extra. Returns an error for an invalid extra pattern.
+func New(extra []string) (*Normalizer, error) {
+	n := &Normalizer{}
+	for _, p := range append(append([]string{}, Builtin...), extra...) {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return n, fmt.Errorf("invalid normalization pattern %q: %w", p, err)
+		}
+		n.patterns = append(n.patterns, re)
+	}
+	return n, nil
+}
+
+// Clean returns the normalized description. If normalizing would leave nothing, the trimmed original is returned.
+func (n *Normalizer) Clean(description string) string {
+	clean := description
+	for _, re := range n.patterns {
+		clean = re.ReplaceAllString(clean, " ")
+		clean = trailing.ReplaceAllString(spaces.ReplaceAllString(clean, " "), "")
+	}
+
+	if clean == "" {
+		return strings.TrimSpace(description)
+	}
+	return clean
+}
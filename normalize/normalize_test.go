@@ -0,0 +1,44 @@
+package normalize_test
+
+import (
+	"testing"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/normalize"
+)
+
+func TestClean(t *testing.T) {
+	n, err := normalize.New([]string{`(?i)\bONLINE\b`})
+	if err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+
+	tests := map[string]string{
+		"SQ *BLUE BOTTLE 1234 OAKLAND CA":       "BLUE BOTTLE",
+		"TST* JOES PIZZA":                       "JOES PIZZA",
+		"PAYPAL *SPOTIFY":                       "SPOTIFY",
+		"NETFLIX.COM 866-579-7172 CA":           "NETFLIX.COM",
+		"SHELL OIL 57442 03/15 CARD 4321":       "SHELL OIL",
+		"AMAZON ONLINE XXXX1234":                "AMAZON",
+		"KROGER #123":                           "KROGER",
+		"1234":                                  "1234",
+		"Transfer to Savings":                   "Transfer to Savings",
+		"POS PURCHASE WALGREENS #5521 TAMPA FL": "WALGREENS",
+		"HOME DEPOT #4521 SAN JOSE CA":          "HOME DEPOT",
+		"HOME DEPOT CA":                         "HOME DEPOT CA",
+		"SHELL OIL TX":                          "SHELL OIL TX",
+		"AMAZON PRIME WA":                       "AMAZON PRIME WA",
+		"CHECK IN":                              "CHECK IN",
+		"1-800-FLOWERS 4412":                    "1-800-FLOWERS",
+		"3M 1002 3004":                          "3M",
+		"7-ELEVEN 35127":                        "7-ELEVEN",
+	}
+	for raw, want := range tests {
+		if got := n.Clean(raw); got != want {
+			t.Fatalf("Got %q for %q, wanted %q", got, raw, want)
+		}
+	}
+
+	if _, err = normalize.New([]string{"("}); err == nil {
+		t.Fatalf("Got no error for an invalid pattern, wanted one")
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/config"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/duration"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/institution"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/notify"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/prom"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/reconcile"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/usage"
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+const defaultDedupeWindow = "1d"
+
+// buildNotifier creates the configured notification sinks and routes, or returns nil if there are none. Invalid
+// sinks are logged and left out, along with the routes to them.
+func buildNotifier(cfg *config.MasterConfig) *notify.Notifier {
+	ncfg := cfg.Notifications
+	if len(ncfg.Sinks) == 0 {
+		return nil
+	}
+
+	sinks := make(map[string]notify.Sink, len(ncfg.Sinks))
+	for name, s := range ncfg.Sinks {
+		sink, err := buildSink(s)
+		if err != nil {
+			log.Error().Err(err).Str("Sink", name).Msg("Ignoring notification sink")
+			continue
+		}
+		sinks[name] = sink
+	}
+
+	routes := make(map[string][]string, len(ncfg.Routes))
+	for event, names := range ncfg.Routes {
+		for _, name := range names {
+			if _, ok := sinks[name]; !ok {
+				log.Error().Str("Event", event).Str("Sink", name).Msg("Notification route to an unknown sink, ignoring it")
+				continue
+			}
+			routes[event] = append(routes[event], name)
+		}
+	}
+
+	opts := notify.Options{Routes: routes, Templates: make(map[string]notify.Template, len(ncfg.Templates))}
+	for event, t := range ncfg.Templates {
+		opts.Templates[event] = notify.Template{Title: t.Title, Body: t.Body}
+	}
+
+	window := ncfg.DedupeWindow
+	if window == "" {
+		window = defaultDedupeWindow
+	}
+	var err error
+	if opts.DedupeWindow, err = duration.ParseDuration(window); err != nil {
+		log.Error().Err(err).Str("DedupeWindow", window).Msg("Invalid notification dedupe window, using " + defaultDedupeWindow)
+		opts.DedupeWindow, _ = duration.ParseDuration(defaultDedupeWindow)
+	}
+
+	if q := ncfg.QuietHours; q.Start != "" || q.End != "" {
+		start, errStart := clockOffset(q.Start)
+		end, errEnd := clockOffset(q.End)
+		if errStart != nil || errEnd != nil {
+			log.Error().Str("Start", q.Start).Str("End", q.End).Msg("Invalid quiet hours, expected HH:MM, notifications are sent at any time")
+		} else {
+			opts.QuietStart, opts.QuietEnd = start, end
+		}
+	}
+
+	n, err := notify.New(sinks, opts)
+	if err != nil {
+		log.Error().Err(err).Msg("Notifications are disabled")
+		return nil
+	}
+	return n
+}
+
+// buildSink creates one notification sink from its config.
+func buildSink(s config.NotifySinkConfig) (notify.Sink, error) {
+	// Bounded by the notifier's timeout. Paths such as an ntfy topic are secret, so requests are counted by sink type.
+	client := &http.Client{Transport: &prom.Transport{Service: "notify", Endpoint: s.Type}}
+	switch s.Type {
+	case "webhook":
+		if s.URL == "" {
+			return nil, fmt.Errorf("webhook needs a url")
+		}
+		return &notify.Webhook{URL: s.URL, Headers: s.Headers, Client: client}, nil
+	case notify.FormatNtfy, notify.FormatGotify:
+		if s.URL == "" {
+			return nil, fmt.Errorf("%s needs a url", s.Type)
+		}
+		return &notify.Push{Format: s.Type, URL: s.URL, Token: s.Token, Priority: s.Priority, Client: client}, nil
+	case "email":
+		if s.SMTP == "" || s.From == "" || len(s.To) == 0 {
+			return nil, fmt.Errorf("email needs smtp, from and to")
+		}
+		return &notify.Email{Addr: s.SMTP, Username: s.Username, Password: s.Password, From: s.From, To: s.To}, nil
+	case "command":
+		if s.Command == "" {
+			return nil, fmt.Errorf("command needs a command")
+		}
+		return &notify.Command{Command: s.Command}, nil
+	}
+	return nil, fmt.Errorf("unknown sink type %q", s.Type)
+}
+
+// clockOffset parses HH:MM into the time since midnight.
+func clockOffset(clock string) (time.Duration, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// notifyBalanceMismatch sends a balance mismatch, with the investigation's explanation if there is one. Repeats are
+// only dropped while the difference stays the same.
+func notifyBalanceMismatch(n *notify.Notifier, name, id string, expected, actual decimal.Decimal, report *reconcile.Report) {
+	difference := actual.Sub(expected)
+	message := fmt.Sprintf("Firefly expects %s, SimpleFIN reports %s (difference %s).", expected.StringFixed(2), actual.StringFixed(2), difference.StringFixed(2))
+	if report != nil && len(report.Explanation) > 0 {
+		explanation := make([]string, 0, len(report.Explanation))
+		for _, finding := range report.Explanation {
+			explanation = append(explanation, fmt.Sprintf("%s %s (%s)", finding.Kind, finding.Description, finding.Impact.StringFixed(2)))
+		}
+		message += " Likely cause: " + strings.Join(explanation, "; ")
+	}
+
+	n.Notify(notify.Event{
+		Type:    notify.BalanceMismatch,
+		Title:   "Balance mismatch for " + name,
+		Message: message,
+		Key:     id + "|" + difference.String(),
+		Fields:  map[string]string{"account": name, "account_id": id, "expected": expected.StringFixed(2), "actual": actual.StringFixed(2)},
+	})
+}
+
+// notifyBankErrors sends the institutions that need attention, with the errors SimpleFIN reported for them, and the
+// errors that didn't name an institution.
+func notifyBankErrors(n *notify.Notifier, statuses []institution.Status, unassigned []string) {
+	for _, s := range statuses {
+		var title, message string
+		switch {
+		case s.State == institution.NeedsReauth:
+			title = s.Name + " needs you to log in to SimpleFIN Bridge again"
+		case s.State == institution.Degraded && len(s.Errors) > 0:
+			title = s.Name + " connection has errors"
+		case s.State == institution.Degraded:
+			title = s.Name + " balances are stale"
+			message = fmt.Sprintf("The oldest balance is from %s.", s.OldestBalance().Format(time.DateTime))
+		default:
+			continue
+		}
+		if len(s.Errors) > 0 {
+			message = strings.Join(s.Errors, "\n")
+		}
+
+		n.Notify(notify.Event{
+			Type:    notify.BankError,
+			Title:   title,
+			Message: message,
+			Key:     s.ID + "|" + s.State + "|" + message,
+			Fields:  map[string]string{"institution": s.Name, "institution_id": s.ID, "state": s.State, "since": s.Since.Format(time.RFC3339)},
+		})
+	}
+	for _, e := range unassigned {
+		n.Notify(notify.Event{Type: notify.BankError, Title: "SimpleFIN bank connection error", Message: e})
+	}
+}
+
+// notifyBudgetExceeded sends the monthly AI budget being used up, once a month.
+func notifyBudgetExceeded(n *notify.Notifier, month usage.Month, budget config.AIBudgetConfig) {
+	n.Notify(notify.Event{
+		Type:    notify.BudgetExceeded,
+		Title:   "Monthly AI budget used up",
+		Message: fmt.Sprintf("%d tokens and $%.2f spent in %s. Transactions are categorized without the AI provider until next month.", month.Tokens(), month.Cost, month.Month),
+		Key:     month.Month,
+		Fields: map[string]string{
+			"month":          month.Month,
+			"tokens":         strconv.FormatInt(month.Tokens(), 10),
+			"cost":           strconv.FormatFloat(month.Cost, 'f', 2, 64),
+			"budget_tokens":  strconv.FormatInt(budget.MonthlyTokens, 10),
+			"budget_dollars": strconv.FormatFloat(budget.MonthlyDollars, 'f', 2, 64),
+		},
+	})
+}
+
+// notifySyncFailed sends a sync that couldn't run.
+func notifySyncFailed(n *notify.Notifier, err error) {
+	n.Notify(notify.Event{Type: notify.SyncFailed, Title: "Sync failed", Message: err.Error(), Key: "sync"})
+}
+
+// notifyTransactionFailed sends a transaction that couldn't be created or updated in Firefly.
+func notifyTransactionFailed(n *notify.Notifier, action, id, description, account string, err error) {
+	n.Notify(notify.Event{
+		Type:    notify.TransactionFailed,
+		Title:   fmt.Sprintf("Could not %s transaction %s", action, description),
+		Message: err.Error(),
+		Key:     action + "|" + id,
+		Fields:  map[string]string{"account": account, "transaction_id": id, "description": description},
+	})
+}
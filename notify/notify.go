@@ -0,0 +1,350 @@
+// Package notify sends events that need attention, such as balance mismatches and bank errors, to webhooks, push
+// services, email and commands
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Event types
+const (
+	BalanceMismatch   = "balance_mismatch"   // Firefly's balance doesn't match SimpleFIN's after a sync
+	BankError         = "bank_error"         // SimpleFIN reported a problem with a bank connection
+	BudgetExceeded    = "budget_exceeded"    // The monthly AI budget is used up
+	SyncFailed        = "sync_failed"        // The sync couldn't run at all
+	TransactionFailed = "transaction_failed" // A transaction couldn't be created or updated in Firefly
+
+	AllEvents = "*" // Route matching every event type
+)
+
+const (
+	defaultSendTimeout = 30 * time.Second
+	queueSize          = 100 // Messages waiting to be sent; more are dropped
+	defaultTitle       = "{{.Title}}"
+	defaultBody        = "{{.Message}}{{range $k, $v := .Fields}}\n{{$k}}: {{$v}}{{end}}"
+)
+
+// Event is something that happened and needs attention.
+type Event struct {
+	Type    string
+	Title   string
+	Message string
+	Key     string            // Identifies repeats of the same event (default Type, Title and Message)
+	Fields  map[string]string // Details, such as the account name
+	Time    time.Time         // Defaults to now
+}
+
+func (e Event) key() string {
+	if e.Key != "" {
+		return e.Type + "|" + e.Key
+	}
+	return e.Type + "|" + e.Title + "|" + e.Message
+}
+
+// Message is an event with its title and body rendered for sinks.
+type Message struct {
+	Event
+	Subject string
+	Body    string
+}
+
+// Sink delivers messages somewhere.
+type Sink interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// Template renders an event's title and body. Both are text/template strings given the Event.
+type Template struct {
+	Title string
+	Body  string
+}
+
+// Options configure a Notifier.
+type Options struct {
+	Routes       map[string][]string // Event type (or *) to the names of the sinks it is sent to
+	Templates    map[string]Template // By event type; missing ones use the event's title and message
+	DedupeWindow time.Duration       // Repeats of an event within it are dropped (0 = never dropped)
+	QuietStart   time.Duration       // Quiet hours as offsets from midnight; messages are held until they end
+	QuietEnd     time.Duration       // Equal to QuietStart for no quiet hours
+	Timeout      time.Duration       // Per sink (default 30s)
+	Now          func() time.Time    // Default time.Now
+}
+
+type templates struct {
+	title, body *template.Template
+}
+
+// delivery is a message and the sinks it goes to.
+type delivery struct {
+	sinks []string
+	m     Message
+}
+
+// Notifier routes events to sinks. Messages are sent in the background, one at a time, so a slow sink never holds
+// up the caller. A nil Notifier drops every event.
+type Notifier struct {
+	sinks     map[string]Sink
+	opts      Options
+	templates map[string]templates
+	fallback  templates
+	sent      map[string]time.Time // Last time each event key was sent, within the dedupe window
+	held      []delivery
+	flush     *time.Timer
+	queue     chan delivery
+	pending   sync.WaitGroup // Messages queued and not sent yet
+	done      chan struct{}  // Closed when the worker stops
+	closed    bool
+	mu        sync.Mutex
+}
+
+// New returns a Notifier sending to sinks by name, and starts its worker. It fails if a route names a missing sink
+// or a template doesn't parse. Close it to send what is still queued.
+func New(sinks map[string]Sink, opts Options) (*Notifier, error) {
+	if opts.Timeout == 0 {
+		opts.Timeout = defaultSendTimeout
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	for event, names := range opts.Routes {
+		for _, name := range names {
+			if _, ok := sinks[name]; !ok {
+				return nil, fmt.Errorf("route %s: no sink named %q", event, name)
+			}
+		}
+	}
+
+	n := &Notifier{
+		sinks:     sinks,
+		opts:      opts,
+		templates: make(map[string]templates),
+		sent:      make(map[string]time.Time),
+		queue:     make(chan delivery, queueSize),
+		done:      make(chan struct{}),
+	}
+	var err error
+	if n.fallback, err = parseTemplates("default", Template{}); err != nil {
+		return nil, err
+	}
+	for event, t := range opts.Templates {
+		if n.templates[event], err = parseTemplates(event, t); err != nil {
+			return nil, err
+		}
+	}
+	go n.work()
+	return n, nil
+}
+
+func parseTemplates(event string, t Template) (templates, error) {
+	if t.Title == "" {
+		t.Title = defaultTitle
+	}
+	if t.Body == "" {
+		t.Body = defaultBody
+	}
+	title, err := template.New(event + " title").Parse(t.Title)
+	if err != nil {
+		return templates{}, fmt.Errorf("template %s: %w", event, err)
+	}
+	body, err := template.New(event + " body").Parse(t.Body)
+	if err != nil {
+		return templates{}, fmt.Errorf("template %s: %w", event, err)
+	}
+	return templates{title: title, body: body}, nil
+}
+
+// Notify queues e for the sinks routed for its type, unless it was already sent within the dedupe window. During
+// quiet hours it is held and queued when they end. Failures are logged.
+func (n *Notifier) Notify(e Event) {
+	if n == nil {
+		return
+	}
+
+	n.mu.Lock()
+	now := n.opts.Now()
+	if e.Time.IsZero() {
+		e.Time = now
+	}
+	sinks := n.route(e.Type)
+	if len(sinks) == 0 {
+		n.mu.Unlock()
+		return
+	}
+	if n.opts.DedupeWindow > 0 {
+		for key, last := range n.sent {
+			if now.Sub(last) >= n.opts.DedupeWindow {
+				delete(n.sent, key)
+			}
+		}
+		key := e.key()
+		if _, ok := n.sent[key]; ok {
+			n.mu.Unlock()
+			log.Debug().Str("Event", e.Type).Str("Title", e.Title).Msg("Notification already sent, skipping")
+			return
+		}
+		n.sent[key] = now
+	}
+
+	m, err := n.render(e)
+	if err != nil {
+		n.mu.Unlock()
+		log.Error().Err(err).Str("Event", e.Type).Msg("Could not render notification")
+		return
+	}
+
+	if wait := n.quietFor(now); wait > 0 {
+		n.held = append(n.held, delivery{sinks: sinks, m: m})
+		if n.flush == nil {
+			n.flush = time.AfterFunc(wait, n.Flush)
+		}
+		n.mu.Unlock()
+		log.Debug().Str("Event", e.Type).Dur("For", wait).Msg("Quiet hours, holding notification")
+		return
+	}
+	n.enqueue(delivery{sinks: sinks, m: m})
+	n.mu.Unlock()
+}
+
+// Flush queues the messages held during quiet hours.
+func (n *Notifier) Flush() {
+	if n == nil {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, d := range n.held {
+		n.enqueue(d)
+	}
+	n.held = nil
+	if n.flush != nil {
+		n.flush.Stop()
+		n.flush = nil
+	}
+}
+
+// Wait blocks until every queued message has been sent or has failed.
+func (n *Notifier) Wait() {
+	if n == nil {
+		return
+	}
+	n.pending.Wait()
+}
+
+// Close sends the queued messages and stops the worker. Messages held for quiet hours, and events notified
+// afterwards, are dropped.
+func (n *Notifier) Close() {
+	if n == nil {
+		return
+	}
+
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.queue)
+		if n.flush != nil {
+			n.flush.Stop()
+		}
+	}
+	n.mu.Unlock()
+	<-n.done
+}
+
+// enqueue hands d to the worker, dropping it if the queue is full or closed; n.mu must be held.
+func (n *Notifier) enqueue(d delivery) {
+	if n.closed {
+		return
+	}
+	n.pending.Add(1)
+	select {
+	case n.queue <- d:
+	default:
+		n.pending.Done()
+		log.Warn().Str("Event", d.m.Type).Msg("Notification queue full, dropping notification")
+	}
+}
+
+// work sends queued messages until the queue is closed.
+func (n *Notifier) work() {
+	defer close(n.done)
+	for d := range n.queue {
+		n.send(d.sinks, d.m)
+		n.pending.Done()
+	}
+}
+
+// route returns the sorted names of the sinks for an event type.
+func (n *Notifier) route(event string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, name := range append(n.opts.Routes[event], n.opts.Routes[AllEvents]...) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (n *Notifier) render(e Event) (Message, error) {
+	t, ok := n.templates[e.Type]
+	if !ok {
+		t = n.fallback
+	}
+	var title, body bytes.Buffer
+	if err := t.title.Execute(&title, e); err != nil {
+		return Message{}, err
+	}
+	if err := t.body.Execute(&body, e); err != nil {
+		return Message{}, err
+	}
+	return Message{Event: e, Subject: strings.TrimSpace(title.String()), Body: strings.TrimSpace(body.String())}, nil
+}
+
+// quietFor returns how long the quiet hours containing now last, or 0 outside them.
+func (n *Notifier) quietFor(now time.Time) time.Duration {
+	start, end := n.opts.QuietStart, n.opts.QuietEnd
+	if start == end {
+		return 0
+	}
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	offset := now.Sub(midnight)
+
+	switch {
+	case start < end && offset >= start && offset < end: // e.g. 01:00 to 06:00
+		return end - offset
+	case start > end && offset >= start: // e.g. 22:00 to 07:00, before midnight
+		return 24*time.Hour - offset + end
+	case start > end && offset < end: // After midnight
+		return end - offset
+	}
+	return 0
+}
+
+func (n *Notifier) send(sinks []string, m Message) {
+	var wg sync.WaitGroup
+	for _, name := range sinks {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), n.opts.Timeout)
+			defer cancel()
+			if err := n.sinks[name].Send(ctx, m); err != nil {
+				log.Error().Err(err).Str("Sink", name).Str("Event", m.Type).Msg("Could not send notification")
+				return
+			}
+			log.Debug().Str("Sink", name).Str("Event", m.Type).Msg("Notification sent")
+		}(name)
+	}
+	wg.Wait()
+}
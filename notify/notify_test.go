@@ -0,0 +1,160 @@
+package notify_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/notify"
+)
+
+// recorder is a Sink keeping what it was sent.
+type recorder struct {
+	mu       sync.Mutex
+	messages []notify.Message
+}
+
+func (r *recorder) Send(_ context.Context, m notify.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, m)
+	return nil
+}
+
+func (r *recorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.messages)
+}
+
+func TestRoutesAndTemplates(t *testing.T) {
+	phone, mail := &recorder{}, &recorder{}
+	n, err := notify.New(map[string]notify.Sink{"phone": phone, "mail": mail}, notify.Options{
+		Routes: map[string][]string{
+			notify.BankError: {"phone"},
+			notify.AllEvents: {"mail"},
+		},
+		Templates: map[string]notify.Template{
+			notify.BankError: {Title: "🏦 {{.Fields.bank}}", Body: "Bank said: {{.Message}}"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+
+	n.Notify(notify.Event{Type: notify.BankError, Title: "Bank error", Message: "Re-authenticate", Fields: map[string]string{"bank": "Chase"}})
+	n.Notify(notify.Event{Type: notify.SyncFailed, Title: "Sync failed", Message: "timeout"})
+	n.Wait()
+
+	if phone.count() != 1 || mail.count() != 2 {
+		t.Fatalf("Got %d phone and %d mail messages, wanted 1 and 2", phone.count(), mail.count())
+	}
+	if m := phone.messages[0]; m.Subject != "🏦 Chase" || m.Body != "Bank said: Re-authenticate" {
+		t.Fatalf("Got %q / %q, wanted the template rendered", m.Subject, m.Body)
+	}
+	if m := mail.messages[1]; m.Subject != "Sync failed" || m.Body != "timeout" {
+		t.Fatalf("Got %q / %q, wanted the event's title and message", m.Subject, m.Body)
+	}
+}
+
+func TestUnknownSink(t *testing.T) {
+	_, err := notify.New(nil, notify.Options{Routes: map[string][]string{notify.BankError: {"pager"}}})
+	if err == nil {
+		t.Fatalf("Got no error for a route to a missing sink, wanted one")
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	sink := &recorder{}
+	n, _ := notify.New(map[string]notify.Sink{"s": sink}, notify.Options{
+		Routes:       map[string][]string{notify.AllEvents: {"s"}},
+		DedupeWindow: time.Hour,
+		Now:          func() time.Time { return now },
+	})
+
+	mismatch := notify.Event{Type: notify.BalanceMismatch, Title: "Checking", Message: "off by 12.00", Key: "1"}
+	n.Notify(mismatch)
+	n.Notify(mismatch)
+	now = now.Add(2 * time.Hour)
+	n.Notify(mismatch)
+	n.Wait()
+
+	if sink.count() != 2 {
+		t.Fatalf("Got %d messages, wanted 2 (the repeat within the window dropped)", sink.count())
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	now := time.Date(2024, 5, 1, 23, 30, 0, 0, time.UTC)
+	sink := &recorder{}
+	n, _ := notify.New(map[string]notify.Sink{"s": sink}, notify.Options{
+		Routes:     map[string][]string{notify.AllEvents: {"s"}},
+		QuietStart: 22 * time.Hour,
+		QuietEnd:   7 * time.Hour,
+		Now:        func() time.Time { return now },
+	})
+
+	n.Notify(notify.Event{Type: notify.SyncFailed, Title: "Sync failed"})
+	n.Wait()
+	if sink.count() != 0 {
+		t.Fatalf("Got %d messages during quiet hours, wanted 0", sink.count())
+	}
+
+	n.Flush()
+	n.Wait()
+	if sink.count() != 1 {
+		t.Fatalf("Got %d messages after quiet hours, wanted 1", sink.count())
+	}
+
+	now = time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+	n.Notify(notify.Event{Type: notify.SyncFailed, Title: "Sync failed again"})
+	n.Wait()
+	if sink.count() != 2 {
+		t.Fatalf("Got %d messages, wanted the one outside quiet hours sent right away", sink.count())
+	}
+}
+
+// blocked is a Sink that waits until it is released.
+type blocked struct {
+	release chan struct{}
+	recorder
+}
+
+func (b *blocked) Send(ctx context.Context, m notify.Message) error {
+	<-b.release
+	return b.recorder.Send(ctx, m)
+}
+
+func TestNotifyDoesNotWait(t *testing.T) {
+	sink := &blocked{release: make(chan struct{})}
+	n, _ := notify.New(map[string]notify.Sink{"s": sink}, notify.Options{Routes: map[string][]string{notify.AllEvents: {"s"}}})
+
+	done := make(chan struct{})
+	go func() {
+		n.Notify(notify.Event{Type: notify.SyncFailed, Title: "Sync failed"})
+		n.Notify(notify.Event{Type: notify.BankError, Title: "Bank error"})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Got Notify blocked on a slow sink, wanted it to return right away")
+	}
+
+	close(sink.release)
+	n.Close()
+	if sink.count() != 2 {
+		t.Fatalf("Got %d messages, wanted Close to send both queued ones", sink.count())
+	}
+	n.Notify(notify.Event{Type: notify.SyncFailed, Title: "After close"})
+}
+
+func TestNilNotifier(t *testing.T) {
+	var n *notify.Notifier
+	n.Notify(notify.Event{Type: notify.SyncFailed})
+	n.Flush()
+	n.Wait()
+	n.Close()
+}
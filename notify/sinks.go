@@ -0,0 +1,176 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Webhook POSTs every message as JSON to URL.
+type Webhook struct {
+	URL     string
+	Headers map[string]string
+	Client  *http.Client // Default http.DefaultClient
+}
+
+type webhookPayload struct {
+	Type    string            `json:"type"`
+	Title   string            `json:"title"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+	Time    time.Time         `json:"time"`
+}
+
+// Send implements Sink.
+func (w *Webhook) Send(ctx context.Context, m Message) error {
+	body, err := json.Marshal(webhookPayload{Type: m.Type, Title: m.Subject, Message: m.Body, Fields: m.Fields, Time: m.Time})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	return do(w.Client, req)
+}
+
+// Push formats
+const (
+	FormatNtfy   = "ntfy"
+	FormatGotify = "gotify"
+)
+
+// Push sends messages to an ntfy topic or a Gotify server.
+type Push struct {
+	Format   string // ntfy or gotify
+	URL      string // ntfy topic URL, or Gotify server URL
+	Token    string // ntfy access token, or Gotify application token
+	Priority int    // 0 = the server's default
+	Client   *http.Client
+}
+
+// Send implements Sink.
+func (p *Push) Send(ctx context.Context, m Message) error {
+	var req *http.Request
+	var err error
+	switch p.Format {
+	case FormatNtfy, "":
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, p.URL, strings.NewReader(m.Body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Title", mime.QEncoding.Encode("utf-8", m.Subject))
+		req.Header.Set("Tags", m.Type)
+		if p.Priority > 0 {
+			req.Header.Set("Priority", strconv.Itoa(p.Priority))
+		}
+		if p.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+p.Token)
+		}
+	case FormatGotify:
+		body, _ := json.Marshal(map[string]any{"title": m.Subject, "message": m.Body, "priority": p.Priority})
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(p.URL, "/")+"/message", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Gotify-Key", p.Token)
+	default:
+		return fmt.Errorf("unknown push format %q", p.Format)
+	}
+	return do(p.Client, req)
+}
+
+func do(client *http.Client, req *http.Request) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("got status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Email sends messages through an SMTP server, using STARTTLS when the server offers it.
+type Email struct {
+	Addr     string // host:port
+	Username string // Empty for no authentication
+	Password string
+	From     string
+	To       []string
+}
+
+// Send implements Sink.
+func (e *Email) Send(ctx context.Context, m Message) error {
+	host, _, err := net.SplitHostPort(e.Addr)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if e.Username != "" {
+		auth = smtp.PlainAuth("", e.Username, e.Password, host)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	subject := strings.Join(strings.Fields(m.Subject), " ") // No line breaks in a header
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", m.Time.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(m.Body, "\n", "\r\n"))
+	msg.WriteString("\r\n")
+
+	// net/smtp has no context; give up waiting on it when ctx is done
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(e.Addr, auth, e.From, e.To, msg.Bytes())
+	}()
+	select {
+	case err = <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Command runs a shell command for every message. The message is on its stdin, and its type, title and fields are
+// in NOTIFY_TYPE, NOTIFY_TITLE and NOTIFY_<FIELD> environment variables.
+type Command struct {
+	Command string
+}
+
+// Send implements Sink.
+func (c *Command) Send(ctx context.Context, m Message) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", c.Command)
+	cmd.Stdin = strings.NewReader(m.Body)
+	cmd.Env = append(os.Environ(), "NOTIFY_TYPE="+m.Type, "NOTIFY_TITLE="+m.Subject, "NOTIFY_MESSAGE="+m.Body)
+	for k, v := range m.Fields {
+		cmd.Env = append(cmd.Env, "NOTIFY_"+strings.ToUpper(k)+"="+v)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package notify_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/notify"
+)
+
+var message = notify.Message{
+	Event: notify.Event{
+		Type:   notify.BalanceMismatch,
+		Fields: map[string]string{"account": "Checking"},
+		Time:   time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	},
+	Subject: "Balance mismatch for Checking",
+	Body:    "Firefly has 100.00, SimpleFIN has 88.00",
+}
+
+func TestWebhook(t *testing.T) {
+	var got map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Secret") != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer server.Close()
+
+	sink := &notify.Webhook{URL: server.URL, Headers: map[string]string{"X-Secret": "s3cret"}}
+	if err := sink.Send(context.Background(), message); err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+	if got["type"] != notify.BalanceMismatch || got["title"] != message.Subject || got["message"] != message.Body {
+		t.Fatalf("Got payload %v, wanted the message", got)
+	}
+
+	sink.Headers = nil
+	if err := sink.Send(context.Background(), message); err == nil {
+		t.Fatalf("Got no error for status 401, wanted one")
+	}
+}
+
+func TestPush(t *testing.T) {
+	var headers http.Header
+	var path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers, path = r.Header, r.URL.Path
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+	}))
+	defer server.Close()
+
+	ntfy := &notify.Push{Format: notify.FormatNtfy, URL: server.URL + "/finance", Token: "tk", Priority: 4}
+	if err := ntfy.Send(context.Background(), message); err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+	if path != "/finance" || body != message.Body || headers.Get("Title") != message.Subject || headers.Get("Priority") != "4" || headers.Get("Authorization") != "Bearer tk" {
+		t.Fatalf("Got %s %q with headers %v, wanted an ntfy message", path, body, headers)
+	}
+
+	gotify := &notify.Push{Format: notify.FormatGotify, URL: server.URL, Token: "app"}
+	if err := gotify.Send(context.Background(), message); err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+	if path != "/message" || headers.Get("X-Gotify-Key") != "app" || !strings.Contains(body, `"title":"Balance mismatch for Checking"`) {
+		t.Fatalf("Got %s %q with headers %v, wanted a Gotify message", path, body, headers)
+	}
+}
+
+// smtpServer accepts one message without authentication and returns its DATA on the channel.
+func smtpServer(t *testing.T) (string, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+	data := make(chan string, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { _, _ = conn.Write([]byte(s + "\r\n")) }
+
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case cmd == "DATA":
+				reply("354 go ahead")
+				var msg strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil || l == ".\r\n" {
+						break
+					}
+					msg.WriteString(l)
+				}
+				data <- msg.String()
+				reply("250 queued")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	return l.Addr().String(), data
+}
+
+func TestEmail(t *testing.T) {
+	addr, data := smtpServer(t)
+	sink := &notify.Email{Addr: addr, From: "importer@example.com", To: []string{"me@example.com"}}
+	if err := sink.Send(context.Background(), message); err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+
+	msg := <-data
+	if !strings.Contains(msg, "Subject: Balance mismatch for Checking\r\n") || !strings.Contains(msg, "To: me@example.com\r\n") || !strings.Contains(msg, message.Body) {
+		t.Fatalf("Got message %q, wanted the subject, recipient and body", msg)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	sink := &notify.Command{Command: `printf '%s|%s|' "$NOTIFY_TYPE" "$NOTIFY_ACCOUNT" > ` + out + ` && cat >> ` + out}
+	if err := sink.Send(context.Background(), message); err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+
+	got, _ := os.ReadFile(out)
+	if want := "balance_mismatch|Checking|" + message.Body; string(got) != want {
+		t.Fatalf("Got %q, wanted %q", got, want)
+	}
+
+	if err := (&notify.Command{Command: "echo broken >&2; exit 3"}).Send(context.Background(), message); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("Got error %v, wanted the command's output", err)
+	}
+}
@@ -1,11 +1,11 @@
 package prom
 
 import (
-	"sync"
+	"strconv"
+	"time"
 
-	"github.com/helpcomp/firefly-iii-simplefin-importer/firefly"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/institution"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/shopspring/decimal"
 )
 
 const maxConcurrentWorkers = 10
@@ -15,178 +15,169 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 	e.CollectSys(ch)      // Program Collector (API calls, etc...)
 }
 
+// CollectAccounts sends the account and category metrics of the latest snapshot, without querying Firefly.
 func (e *Exporter) CollectAccounts(ch chan<- prometheus.Metric) {
-	var wg sync.WaitGroup                           // Used for goroutines - Wait for multiple goroutines to finish
-	cachedAccounts, _ := e.ff.ListAccounts("asset") // Get Accounts
-	cats, _ := e.ff.CachedCategories()
-
-	// Create job channels
-	type accountJob struct {
-		account firefly.Account
-	}
-	type categoryJob struct {
-		category firefly.Category
-	}
-
-	accountJobs := make(chan accountJob, len(cachedAccounts))
-	categoryJobs := make(chan categoryJob, len(cats))
-
-	// Start a worker pool for accounts
-	for i := 0; i < maxConcurrentWorkers; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for job := range accountJobs {
-				e.collectAccountTransactions(job.account.ID, job.account.Attributes.Name, job.account.Attributes.Type, ch)
-			}
-		}()
+	snapshot := e.snapshot.Load()
+	if snapshot == nil {
+		return
 	}
 
 	// Accounts //
 	//////////////
-	// Send account jobs to workers
-	for _, account := range cachedAccounts {
-		accountJobs <- accountJob{account: account}
-
-		// Account Balance (send directly, no need to go through worker)
+	for _, account := range snapshot.Accounts {
+		ch <- prometheus.MustNewConstMetric(
+			e.AccountTransactions,
+			prometheus.CounterValue,
+			float64(account.Transactions),
+			account.ID, account.Name, account.Type,
+		)
 		ch <- prometheus.MustNewConstMetric(
 			e.AccountBalance,
 			prometheus.GaugeValue,
-			account.Attributes.CurrentBalance.InexactFloat64(),
-			account.ID, account.Attributes.Name, account.Attributes.Type,
+			account.Balance,
+			account.ID, account.Name, account.Type,
 		)
-
-		// Account Balance Date
-		for _, acct := range e.SimpleFinAccounts {
-			if e.config.Accounts[acct.ID] == account.ID {
-				ch <- prometheus.MustNewConstMetric(
-					e.AccountRefreshTime,
-					prometheus.GaugeValue,
-					float64(acct.BalanceDate),
-					account.ID, account.Attributes.Name, account.Attributes.Type,
-				)
-				break
-			}
+		if account.RefreshTime > 0 {
+			ch <- prometheus.MustNewConstMetric(
+				e.AccountRefreshTime,
+				prometheus.GaugeValue,
+				float64(account.RefreshTime),
+				account.ID, account.Name, account.Type,
+			)
 		}
 	}
-	close(accountJobs) // Signal no more account jobs
-
-	// Wait for account workers to finish
-	wg.Wait()
 
 	// Category //
 	/////////////
-	// Start worker pool for categories
-	for i := 0; i < maxConcurrentWorkers; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for job := range categoryJobs {
-				e.collectCategoryTransactions(job.category.ID, job.category.Name, ch)
-			}
-		}()
-	}
-
-	// Send category jobs to workers
-	for _, cat := range cats {
-		categoryJobs <- categoryJob{category: cat}
-	}
-	close(categoryJobs) // Signal no more category jobs
-
-	// Wait for category workers to finish
-	wg.Wait()
-}
-
-// collectAccountTransactions Scrapes Firefly for Account Transactions based on the given account ID
-func (e *Exporter) collectAccountTransactions(id string, name string, acctType string, ch chan<- prometheus.Metric) {
-	accountTrans, _ := e.ff.ListAccountTransactions(id)
-
-	ch <- prometheus.MustNewConstMetric(
-		e.AccountTransactions,
-		prometheus.CounterValue,
-		float64(accountTrans.Meta.Pagination.Total),
-		id, name, acctType,
-	)
-}
-
-// collectCategoryTransactions Scrapes Firefly for Category Transactions based on the given category ID
-func (e *Exporter) collectCategoryTransactions(id int, name string, ch chan<- prometheus.Metric) {
-	categoryTrans, _ := e.ff.ListCategoryTransactions(id)
-	CatAmt := decimal.Decimal{}
-
-	for _, categoryTransP := range categoryTrans.Data {
-		for _, catTransC := range categoryTransP.Attributes.Transactions {
-			CatAmt = CatAmt.Add(catTransC.Amount)
-		}
+	for _, cat := range snapshot.Categories {
+		ch <- prometheus.MustNewConstMetric(
+			e.categoryActivity,
+			prometheus.GaugeValue,
+			float64(cat.Transactions),
+			cat.Name,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			e.categoryBalance,
+			prometheus.GaugeValue,
+			cat.Balance,
+			cat.Name,
+		)
 	}
 
-	ch <- prometheus.MustNewConstMetric(
-		e.categoryActivity,
-		prometheus.GaugeValue,
-		float64(categoryTrans.Meta.Pagination.Total),
-		name,
-	)
-	ch <- prometheus.MustNewConstMetric(
-		e.categoryBalance,
-		prometheus.GaugeValue,
-		CatAmt.InexactFloat64(),
-		name,
-	)
+	// Snapshot freshness
+	ch <- prometheus.MustNewConstMetric(e.SnapshotTime, prometheus.GaugeValue, float64(snapshot.Taken.Unix()))
+	ch <- prometheus.MustNewConstMetric(e.SnapshotAge, prometheus.GaugeValue, time.Since(snapshot.Taken).Seconds())
+	ch <- prometheus.MustNewConstMetric(e.SnapshotDuration, prometheus.GaugeValue, snapshot.Duration.Seconds())
 }
 
 // CollectSys Collects Program information (API calls, etc...)
 func (e *Exporter) CollectSys(ch chan<- prometheus.Metric) {
-	/*ch <- prometheus.MustNewConstMetric(
-		e.APICalls,
-		prometheus.CounterValue,
-		APICalls.Firefly,
-		"firefly",
-	)
 	ch <- prometheus.MustNewConstMetric(
-		e.APICalls,
+		e.CategorizationCache,
 		prometheus.CounterValue,
-		simplefin.APICalls,
-		"simplefin",
+		float64(CategorizationCache.Hits.Load()),
+		"hit",
 	)
 	ch <- prometheus.MustNewConstMetric(
-		e.APICalls,
+		e.CategorizationCache,
 		prometheus.CounterValue,
-		APICalls.OpenAI,
-		"openai",
+		float64(CategorizationCache.Misses.Load()),
+		"miss",
 	)
+	ch <- prometheus.MustNewConstMetric(e.EmbeddingLookups, prometheus.CounterValue, float64(Embeddings.Hits.Load()), "hit")
+	ch <- prometheus.MustNewConstMetric(e.EmbeddingLookups, prometheus.CounterValue, float64(Embeddings.Misses.Load()), "miss")
+	ch <- prometheus.MustNewConstMetric(e.EmbeddingIndexSize, prometheus.GaugeValue, float64(Embeddings.Indexed.Load()))
+	for rule, n := range Redactions.Snapshot() {
+		ch <- prometheus.MustNewConstMetric(e.Redactions, prometheus.CounterValue, float64(n), rule)
+	}
+	for key, n := range Shadow.Snapshot() {
+		ch <- prometheus.MustNewConstMetric(e.ShadowComparisons, prometheus.CounterValue, float64(n), key[0], key[1])
+	}
+
+	// AI provider
 	ch <- prometheus.MustNewConstMetric(
 		e.OpenAITokens,
 		prometheus.CounterValue,
-		float64(OaiUsage.CompletionTokens),
-		"completion",
+		float64(AI.PromptTokens.Load()),
+		"prompt",
 	)
 	ch <- prometheus.MustNewConstMetric(
 		e.OpenAITokens,
 		prometheus.CounterValue,
-		float64(OaiUsage.TotalTokens),
-		"total",
+		float64(AI.CompletionTokens.Load()),
+		"completion",
 	)
 	ch <- prometheus.MustNewConstMetric(
 		e.OpenAITokens,
 		prometheus.CounterValue,
-		float64(OaiUsage.PromptTokens),
-		"prompt",
-	)
-	ch <- prometheus.MustNewConstMetric(
-		e.APIErrors,
-		prometheus.CounterValue,
-		APIErrors.Firefly,
-		"firefly",
-	)
-	ch <- prometheus.MustNewConstMetric(
-		e.APIErrors,
-		prometheus.CounterValue,
-		APIErrors.Simplefin,
-		"simplefin",
+		float64(AI.PromptTokens.Load()+AI.CompletionTokens.Load()),
+		"total",
 	)
-	ch <- prometheus.MustNewConstMetric(
-		e.ProgramErrors,
-		prometheus.CounterValue,
-		ProgramErrors,
-	)*/
+	for failure, n := range AI.Failures.Snapshot() {
+		ch <- prometheus.MustNewConstMetric(e.OpenAIResponseFailure, prometheus.CounterValue, float64(n), failure)
+	}
+	ch <- prometheus.MustNewConstSummary(e.OpenAILatency, AI.Requests.Load(), AI.Latency(), nil)
+	ch <- prometheus.MustNewConstMetric(e.OpenAICost, prometheus.CounterValue, AI.Cost())
+	budgetExceeded := 0.0
+	if AI.BudgetExceeded.Load() {
+		budgetExceeded = 1
+	}
+	ch <- prometheus.MustNewConstMetric(e.OpenAIBudgetExceeded, prometheus.GaugeValue, budgetExceeded)
+	ch <- prometheus.MustNewConstMetric(e.OpenAIRetries, prometheus.CounterValue, float64(AI.Retries.Load()))
+	ch <- prometheus.MustNewConstMetric(e.OpenAIFallbacks, prometheus.CounterValue, float64(AI.Fallbacks.Load()))
+	for model, open := range AI.CircuitOpen.Snapshot() {
+		ch <- prometheus.MustNewConstMetric(e.OpenAICircuitOpen, prometheus.GaugeValue, open, model)
+	}
+
+	// API calls
+	calls := make(map[string]uint64)
+	errs := make(map[string]uint64)
+	rateLimited := make(map[string]uint64)
+	for key, n := range HTTP.Requests() {
+		ch <- prometheus.MustNewConstMetric(e.HTTPRequests, prometheus.CounterValue, float64(n), key.Service, key.Endpoint, key.Code)
+		calls[key.Service] += n
+		if code, err := strconv.Atoi(key.Code); err != nil || code >= 400 {
+			errs[key.Service] += n
+		}
+		if key.Code == "429" {
+			rateLimited[key.Service] += n
+		}
+	}
+	for service, n := range calls {
+		ch <- prometheus.MustNewConstMetric(e.APICalls, prometheus.CounterValue, float64(n), service)
+		ch <- prometheus.MustNewConstMetric(e.APIErrors, prometheus.CounterValue, float64(errs[service]), service)
+		ch <- prometheus.MustNewConstMetric(e.RateLimit, prometheus.CounterValue, float64(rateLimited[service]), service)
+	}
+	HTTP.collectLatency(e.HTTPRequestDuration, ch)
+	ch <- prometheus.MustNewConstMetric(e.ProgramErrors, prometheus.CounterValue, float64(ProgramErrors.Load()))
+
+	// Institutions
+	for _, inst := range Institutions.Snapshot() {
+		for _, state := range institution.States {
+			current := 0.0
+			if inst.State == state {
+				current = 1
+			}
+			ch <- prometheus.MustNewConstMetric(e.InstitutionState, prometheus.GaugeValue, current, inst.ID, inst.Name, state)
+		}
+		ch <- prometheus.MustNewConstMetric(e.InstitutionSince, prometheus.GaugeValue, float64(inst.Since.Unix()), inst.ID, inst.Name)
+		if oldest := inst.OldestBalance(); !oldest.IsZero() {
+			ch <- prometheus.MustNewConstMetric(e.InstitutionBalanceAge, prometheus.GaugeValue, time.Since(oldest).Seconds(), inst.ID, inst.Name)
+		}
+		ch <- prometheus.MustNewConstMetric(e.InstitutionErrors, prometheus.GaugeValue, float64(len(inst.Errors)), inst.ID, inst.Name)
+	}
+
+	// Syncs
+	if last := Sync.LastSuccess.Load(); last > 0 {
+		ch <- prometheus.MustNewConstMetric(e.RefreshTime, prometheus.GaugeValue, float64(last))
+	}
+	if d := Sync.LastDuration.Load(); d > 0 {
+		ch <- prometheus.MustNewConstMetric(e.SyncDuration, prometheus.GaugeValue, time.Duration(d).Seconds())
+	}
+	for result, n := range Sync.Runs.Snapshot() {
+		ch <- prometheus.MustNewConstMetric(e.SyncRuns, prometheus.CounterValue, float64(n), result)
+	}
+	for key, n := range Sync.Transactions() {
+		ch <- prometheus.MustNewConstMetric(e.SyncTransactions, prometheus.CounterValue, float64(n), key.AccountID, key.AccountName, key.Action)
+	}
 }
@@ -1,10 +1,183 @@
 package prom
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"sync"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/httperror"
 )
 
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+
+	defaultCheckTimeout = 5 * time.Second
+	defaultCheckTTL     = 30 * time.Second
+)
+
+// HealthHandler answers 200 as long as the process is up. Kept for existing health checks; see HandleLive.
 func HealthHandler(w http.ResponseWriter, _ *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
+
+// CheckResult is the outcome of one readiness check.
+type CheckResult struct {
+	Status  string    `json:"status"`
+	Error   string    `json:"error,omitempty"`
+	Checked time.Time `json:"checked"`
+}
+
+// HealthReport is the body of /health/live and /health/ready.
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Uptime string                 `json:"uptime"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+type check struct {
+	name string
+	fn   func() error
+}
+
+// Health runs the readiness checks. Results are cached for TTL so frequent probes don't hammer Firefly or SimpleFIN,
+// and a check taking longer than Timeout fails.
+type Health struct {
+	Timeout time.Duration
+	TTL     time.Duration
+	started time.Time
+	checks  []check
+	mu      sync.Mutex
+	results map[string]CheckResult
+	checked time.Time
+}
+
+// NewHealth returns a Health without checks.
+func NewHealth() *Health {
+	return &Health{Timeout: defaultCheckTimeout, TTL: defaultCheckTTL, started: time.Now()}
+}
+
+// Add registers a readiness check. fn returns nil when the dependency is usable.
+func (h *Health) Add(name string, fn func() error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, check{name: name, fn: fn})
+	h.results = nil
+}
+
+// Ready runs the checks, or returns their cached results, and reports whether all of them passed.
+func (h *Health) Ready() (bool, map[string]CheckResult) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.results == nil || time.Since(h.checked) >= h.TTL {
+		h.results = h.run()
+		h.checked = time.Now()
+	}
+
+	ready := true
+	results := make(map[string]CheckResult, len(h.results))
+	for name, r := range h.results {
+		results[name] = r
+		if r.Status != StatusOK {
+			ready = false
+		}
+	}
+	return ready, results
+}
+
+// run runs every check at once, waiting at most Timeout for them.
+func (h *Health) run() map[string]CheckResult {
+	type outcome struct {
+		name string
+		err  error
+	}
+	done := make(chan outcome, len(h.checks))
+	for _, c := range h.checks {
+		go func(c check) {
+			done <- outcome{name: c.name, err: c.fn()}
+		}(c)
+	}
+
+	now := time.Now()
+	results := make(map[string]CheckResult, len(h.checks))
+	timeout := time.NewTimer(h.Timeout)
+	defer timeout.Stop()
+	for range h.checks {
+		select {
+		case o := <-done:
+			results[o.name] = result(o.err, now)
+		case <-timeout.C:
+			for _, c := range h.checks {
+				if _, ok := results[c.name]; !ok {
+					results[c.name] = result(fmt.Errorf("no answer within %s", h.Timeout), now)
+				}
+			}
+			return results
+		}
+	}
+	return results
+}
+
+func result(err error, checked time.Time) CheckResult {
+	if err != nil {
+		return CheckResult{Status: StatusFail, Error: err.Error(), Checked: checked}
+	}
+	return CheckResult{Status: StatusOK, Checked: checked}
+}
+
+// HandleLive answers 200 while the process is running. It checks nothing outside the process, so a restart is only
+// triggered when the importer itself is stuck.
+func (h *Health) HandleLive(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		httperror.Send(w, req, http.StatusNotImplemented, fmt.Sprintf("Unsupported method %s", req.Method))
+		return
+	}
+	writeReport(w, http.StatusOK, HealthReport{Status: StatusOK, Uptime: time.Since(h.started).Round(time.Second).String()})
+}
+
+// HandleReady answers 200 when every readiness check passes and 503 otherwise, with each check's status and error.
+func (h *Health) HandleReady(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		httperror.Send(w, req, http.StatusNotImplemented, fmt.Sprintf("Unsupported method %s", req.Method))
+		return
+	}
+
+	ready, results := h.Ready()
+	report := HealthReport{Status: StatusOK, Uptime: time.Since(h.started).Round(time.Second).String(), Checks: results}
+	status := http.StatusOK
+	if !ready {
+		report.Status = StatusFail
+		status = http.StatusServiceUnavailable
+	}
+	writeReport(w, status, report)
+}
+
+func writeReport(w http.ResponseWriter, status int, report HealthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+// SyncCheck fails when the last successful sync is older than maxAge. Before the first sync has succeeded, it fails
+// once the process has been up for maxAge.
+func SyncCheck(maxAge time.Duration) func() error {
+	started := time.Now()
+	return func() error {
+		last := Sync.LastSuccess.Load()
+		if last == 0 {
+			if time.Since(started) > maxAge {
+				return errors.New("no successful sync since the importer started")
+			}
+			return nil
+		}
+		if age := time.Since(time.Unix(last, 0)); age > maxAge {
+			return fmt.Errorf("last successful sync was %s ago, over %s", age.Round(time.Second), maxAge)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,73 @@
+package prom_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/prom"
+)
+
+func TestHandleReady(t *testing.T) {
+	var calls atomic.Int64
+	health := prom.NewHealth()
+	health.Timeout = 50 * time.Millisecond
+	health.Add("firefly", func() error { calls.Add(1); return nil })
+	health.Add("simplefin", func() error { return errors.New("invalid HTTP Status Code 502") })
+	health.Add("slow", func() error { time.Sleep(time.Second); return nil })
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		health.HandleReady(w, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+		if w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("Got status %d, wanted %d", w.Code, http.StatusServiceUnavailable)
+		}
+
+		var report prom.HealthReport
+		if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+			t.Fatalf("Got error %v, wanted none", err)
+		}
+		if report.Status != prom.StatusFail {
+			t.Fatalf("Got status %s, wanted %s", report.Status, prom.StatusFail)
+		}
+		if c := report.Checks["firefly"]; c.Status != prom.StatusOK || c.Error != "" {
+			t.Fatalf("Got firefly check %+v, wanted ok", c)
+		}
+		if c := report.Checks["simplefin"]; c.Status != prom.StatusFail || c.Error != "invalid HTTP Status Code 502" {
+			t.Fatalf("Got simplefin check %+v, wanted the error", c)
+		}
+		if c := report.Checks["slow"]; c.Status != prom.StatusFail {
+			t.Fatalf("Got slow check %+v, wanted it to time out", c)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("Got %d firefly checks, wanted 1 (the second probe cached)", calls.Load())
+	}
+}
+
+func TestHandleLive(t *testing.T) {
+	health := prom.NewHealth()
+	health.Add("firefly", func() error { return errors.New("down") })
+
+	w := httptest.NewRecorder()
+	health.HandleLive(w, httptest.NewRequest(http.MethodGet, "/health/live", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Got status %d, wanted %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestSyncCheck(t *testing.T) {
+	if err := prom.SyncCheck(time.Hour)(); err != nil {
+		t.Fatalf("Got error %v before the first sync, wanted none", err)
+	}
+
+	prom.Sync.LastSuccess.Store(time.Now().Add(-2 * time.Hour).Unix())
+	defer prom.Sync.LastSuccess.Store(0)
+	if err := prom.SyncCheck(time.Hour)(); err == nil {
+		t.Fatalf("Got no error for a sync two hours ago, wanted one")
+	}
+}
@@ -0,0 +1,184 @@
+package prom
+
+import (
+	"sync"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/simplefin"
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+// AccountSnapshot holds the metrics of one Firefly asset account.
+type AccountSnapshot struct {
+	ID           string
+	Name         string
+	Type         string
+	Balance      float64
+	Transactions int
+	RefreshTime  int64 // SimpleFIN balance date, 0 if the account isn't synced
+}
+
+// categoriesSince is the start of the period category totals cover, so they include everything.
+var categoriesSince = time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// CategorySnapshot holds the metrics of one Firefly category.
+type CategorySnapshot struct {
+	Name         string
+	Transactions int
+	Balance      float64
+}
+
+// Snapshot is the Firefly state served on scrape, taken in the background so scrapes never query Firefly.
+type Snapshot struct {
+	Accounts   []AccountSnapshot
+	Categories []CategorySnapshot
+	Taken      time.Time
+	Duration   time.Duration
+}
+
+// SetSimpleFinAccounts replaces the SimpleFIN accounts whose balance dates are exported, after every sync.
+func (e *Exporter) SetSimpleFinAccounts(accounts []simplefin.Accounts) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.SimpleFinAccounts = accounts
+}
+
+// Snapshot returns the latest snapshot, or nil if none has been taken yet.
+func (e *Exporter) Snapshot() *Snapshot {
+	return e.snapshot.Load()
+}
+
+// Refresh takes a new snapshot of Firefly's accounts and categories. Accounts or categories that can't be read
+// keep their values from the previous snapshot, and if the account list can't be read the snapshot is kept as is.
+func (e *Exporter) Refresh() {
+	start := time.Now()
+	previous := e.snapshot.Load()
+	if previous == nil {
+		previous = &Snapshot{}
+	}
+
+	accounts, err := e.ff.ListAccounts("asset")
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to list accounts for metrics, keeping the previous snapshot")
+		return
+	}
+	cats, err := e.ff.CachedCategories()
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to list categories for metrics")
+	}
+
+	e.mu.Lock()
+	balanceDates := make(map[string]int64)
+	for _, acct := range e.SimpleFinAccounts {
+		if id, ok := e.config.Accounts[acct.ID]; ok {
+			balanceDates[id] = acct.BalanceDate
+		}
+	}
+	e.mu.Unlock()
+
+	snapshot := &Snapshot{
+		Accounts:   make([]AccountSnapshot, len(accounts)),
+		Categories: make([]CategorySnapshot, len(cats)),
+	}
+
+	// Accounts //
+	//////////////
+	oldAccounts := make(map[string]AccountSnapshot, len(previous.Accounts))
+	for _, a := range previous.Accounts {
+		oldAccounts[a.ID] = a
+	}
+	parallel(len(accounts), func(i int) {
+		account := accounts[i]
+		s := AccountSnapshot{
+			ID:          account.ID,
+			Name:        account.Attributes.Name,
+			Type:        account.Attributes.Type,
+			Balance:     account.Attributes.CurrentBalance.InexactFloat64(),
+			RefreshTime: balanceDates[account.ID],
+		}
+		trans, err := e.ff.ListAccountTransactions(account.ID)
+		if err != nil {
+			log.Warn().Err(err).Str("Account", account.ID).Msg("Unable to count account transactions for metrics")
+			s.Transactions = oldAccounts[account.ID].Transactions
+		} else {
+			s.Transactions = trans.Meta.Pagination.Total
+		}
+		snapshot.Accounts[i] = s
+	})
+
+	// Category //
+	/////////////
+	oldCategories := make(map[string]CategorySnapshot, len(previous.Categories))
+	for _, c := range previous.Categories {
+		oldCategories[c.Name] = c
+	}
+	// The count comes from the pagination of the first page, and the amount from Firefly's own totals, so neither
+	// needs the category's whole history
+	parallel(len(cats), func(i int) {
+		cat := cats[i]
+		s := oldCategories[cat.Name]
+		s.Name = cat.Name
+
+		trans, err := e.ff.ListCategoryTransactions(cat.ID)
+		if err != nil {
+			log.Warn().Err(err).Str("Category", cat.Name).Msg("Unable to count category transactions for metrics")
+		} else {
+			s.Transactions = trans.Meta.Pagination.Total
+		}
+
+		totals, err := e.ff.FetchCategoryTotal(cat.ID, categoriesSince, time.Now())
+		if err != nil {
+			log.Warn().Err(err).Str("Category", cat.Name).Msg("Unable to read category totals for metrics")
+		} else {
+			amount := decimal.Decimal{}
+			for _, total := range totals {
+				amount = amount.Add(total.Spent.Abs()).Add(total.Earned.Abs())
+			}
+			s.Balance = amount.InexactFloat64()
+		}
+		snapshot.Categories[i] = s
+	})
+
+	snapshot.Taken = time.Now()
+	snapshot.Duration = snapshot.Taken.Sub(start)
+	e.snapshot.Store(snapshot)
+	log.Debug().Dur("Duration", snapshot.Duration).Int("Accounts", len(accounts)).Int("Categories", len(cats)).Msg("Metrics snapshot taken")
+}
+
+// Schedule takes a snapshot now, then every interval until quit is closed.
+func (e *Exporter) Schedule(interval time.Duration, quit <-chan struct{}) {
+	e.Refresh()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.Refresh()
+		case <-quit:
+			return
+		}
+	}
+}
+
+// parallel calls fn for 0 to n-1 on up to maxConcurrentWorkers goroutines.
+func parallel(n int, fn func(i int)) {
+	jobs := make(chan int, n)
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+
+	var wg sync.WaitGroup
+	for w := 0; w < min(n, maxConcurrentWorkers); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
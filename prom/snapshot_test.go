@@ -0,0 +1,109 @@
+package prom_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/config"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/firefly"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/prom"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/simplefin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeFirefly serves one asset account with 120 transactions, and a category with 57 transactions over three pages
+// totalling 20.5 in two currencies.
+func fakeFirefly(requests *atomic.Int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch {
+		case r.URL.Path == "/api/v1/accounts":
+			fmt.Fprint(w, `{"data":[{"id":"1","attributes":{"name":"Checking","type":"asset","current_balance":"250.50"}}],
+				"meta":{"pagination":{"total":1,"current_page":1,"total_pages":1}}}`)
+		case r.URL.Path == "/api/v1/accounts/1/transactions":
+			fmt.Fprint(w, `{"data":[],"meta":{"pagination":{"total":120,"current_page":1,"total_pages":3}}}`)
+		case r.URL.Path == "/api/v1/autocomplete/categories":
+			fmt.Fprint(w, `[{"id":"7","name":"Groceries"}]`)
+		case r.URL.Path == "/api/v1/categories/7/transactions":
+			if page := r.URL.Query().Get("page"); page != "" && page != "1" {
+				http.Error(w, "only the first page is needed", http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, `{"data":[{"id":"1","attributes":{"transactions":[{"amount":"10.25"}]}}],
+				"meta":{"pagination":{"total":57,"current_page":1,"total_pages":3}}}`)
+		case r.URL.Path == "/api/v1/categories/7":
+			fmt.Fprint(w, `{"data":{"id":"7","attributes":{"name":"Groceries","spent":[{"sum":"-10.00"},{"sum":"-5.50"}],"earned":[{"sum":"5.00"}]}}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestSnapshot(t *testing.T) {
+	var requests atomic.Int64
+	server := fakeFirefly(&requests)
+	defer server.Close()
+
+	ff := firefly.New(http.DefaultClient, "token", server.URL)
+	cfg := &config.MasterConfig{Accounts: map[string]string{"ACT-1": "1"}}
+	exporter := prom.NewExporter("test", ff, cfg, nil)
+	exporter.SetSimpleFinAccounts([]simplefin.Accounts{{ID: "ACT-1", BalanceDate: 1700000000}})
+	exporter.Refresh()
+
+	snapshot := exporter.Snapshot()
+	if snapshot == nil || len(snapshot.Accounts) != 1 || len(snapshot.Categories) != 1 {
+		t.Fatalf("Got snapshot %+v, wanted one account and one category", snapshot)
+	}
+	if a := snapshot.Accounts[0]; a.Transactions != 120 || a.Balance != 250.5 || a.RefreshTime != 1700000000 {
+		t.Fatalf("Got account %+v, wanted 120 transactions, balance 250.5 and refresh time 1700000000", a)
+	}
+	if c := snapshot.Categories[0]; c.Transactions != 57 || c.Balance != 20.5 {
+		t.Fatalf("Got category %+v, wanted 57 transactions from the pagination and 20.5 from the totals", c)
+	}
+	if requests.Load() != 5 {
+		t.Fatalf("Got %d requests to Firefly, wanted 5 (one per list and total)", requests.Load())
+	}
+
+	// Scrapes are served from the snapshot
+	before := requests.Load()
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(exporter)
+	for i := 0; i < 3; i++ {
+		if _, err := registry.Gather(); err != nil {
+			t.Fatalf("Got error %v, wanted none", err)
+		}
+	}
+	if requests.Load() != before {
+		t.Fatalf("Got %d requests to Firefly while scraping, wanted 0", requests.Load()-before)
+	}
+
+	expected := `
+# HELP test_category_balance Balance of Category
+# TYPE test_category_balance gauge
+test_category_balance{type="Groceries"} 20.5
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "test_category_balance"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotKeptOnError(t *testing.T) {
+	var requests atomic.Int64
+	server := fakeFirefly(&requests)
+
+	ff := firefly.New(http.DefaultClient, "token", server.URL)
+	exporter := prom.NewExporter("test", ff, &config.MasterConfig{}, nil)
+	exporter.Refresh()
+	taken := exporter.Snapshot().Taken
+
+	server.Close()
+	exporter.Refresh()
+	if s := exporter.Snapshot(); !s.Taken.Equal(taken) || len(s.Accounts) != 1 {
+		t.Fatalf("Got snapshot %+v, wanted the previous one kept", s)
+	}
+}
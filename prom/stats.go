@@ -0,0 +1,254 @@
+package prom
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/institution"
+	"github.com/rs/zerolog"
+)
+
+// Program statistics. These are updated by the importer as it runs and exported by CollectSys.
+
+// CacheStats counts lookups in a cache.
+type CacheStats struct {
+	Hits   atomic.Uint64
+	Misses atomic.Uint64
+}
+
+// CategorizationCache counts categorization cache lookups
+var CategorizationCache CacheStats
+
+// EmbeddingStats counts lookups in the embedding index.
+type EmbeddingStats struct {
+	CacheStats              // Hits had labelled neighbours, misses went on to the AI provider
+	Indexed    atomic.Int64 // Labelled descriptions in the index
+}
+
+// Embeddings counts embedding index lookups
+var Embeddings EmbeddingStats
+
+// LabeledCounts counts events by label.
+type LabeledCounts struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+// Add adds n to the count of label.
+func (l *LabeledCounts) Add(label string, n uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.counts == nil {
+		l.counts = make(map[string]uint64)
+	}
+	l.counts[label] += n
+}
+
+// Snapshot returns a copy of the counts.
+func (l *LabeledCounts) Snapshot() map[string]uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	counts := make(map[string]uint64, len(l.counts))
+	for label, n := range l.counts {
+		counts[label] = n
+	}
+	return counts
+}
+
+// LabeledGauges holds a value by label.
+type LabeledGauges struct {
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// Set sets the value of label.
+func (l *LabeledGauges) Set(label string, v float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.values == nil {
+		l.values = make(map[string]float64)
+	}
+	l.values[label] = v
+}
+
+// Snapshot returns a copy of the values.
+func (l *LabeledGauges) Snapshot() map[string]float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	values := make(map[string]float64, len(l.values))
+	for label, v := range l.values {
+		values[label] = v
+	}
+	return values
+}
+
+// Redactions counts personal information redacted from AI prompts, by rule
+var Redactions LabeledCounts
+
+// AIStats counts requests to the AI provider.
+type AIStats struct {
+	Requests         atomic.Uint64
+	PromptTokens     atomic.Uint64
+	CompletionTokens atomic.Uint64
+	Failures         LabeledCounts // By type: timeout, rate_limit, server, client, network, invalid_json, budget, circuit_open
+	BudgetExceeded   atomic.Bool
+	Retries          atomic.Uint64
+	Fallbacks        atomic.Uint64 // Requests answered by a model after the first
+	CircuitOpen      LabeledGauges // 1 while a model's circuit breaker is open, by model
+
+	mu      sync.Mutex
+	latency float64 // Seconds, summed over Requests
+	cost    float64 // Dollars
+}
+
+// Observe records a finished request.
+func (s *AIStats) Observe(latency time.Duration, cost float64) {
+	s.Requests.Add(1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency += latency.Seconds()
+	s.cost += cost
+}
+
+// Latency returns the total seconds spent waiting on requests.
+func (s *AIStats) Latency() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.latency
+}
+
+// Cost returns the dollars spent since the importer started.
+func (s *AIStats) Cost() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cost
+}
+
+// AI counts AI provider requests
+var AI AIStats
+
+// ShadowStats counts shadow categorizer comparisons by category and result (agree, disagree).
+type ShadowStats struct {
+	mu     sync.Mutex
+	counts map[[2]string]uint64
+}
+
+// Add counts a comparison.
+func (s *ShadowStats) Add(category, result string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counts == nil {
+		s.counts = make(map[[2]string]uint64)
+	}
+	s.counts[[2]string{category, result}]++
+}
+
+// Snapshot returns a copy of the counts, keyed by category and result.
+func (s *ShadowStats) Snapshot() map[[2]string]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[[2]string]uint64, len(s.counts))
+	for key, n := range s.counts {
+		counts[key] = n
+	}
+	return counts
+}
+
+// Shadow counts shadow categorizer comparisons
+var Shadow ShadowStats
+
+// Sync actions counted per account
+const (
+	SyncCreated = "created"
+	SyncUpdated = "updated"
+	SyncDeleted = "deleted"
+)
+
+// SyncKey identifies the transactions counted together.
+type SyncKey struct {
+	AccountID   string
+	AccountName string
+	Action      string // SyncCreated, SyncUpdated or SyncDeleted
+}
+
+// SyncStats describes the syncs from SimpleFIN to Firefly.
+type SyncStats struct {
+	LastSuccess  atomic.Int64  // Unix time the last successful sync finished
+	LastDuration atomic.Int64  // How long the last sync took, in nanoseconds
+	Runs         LabeledCounts // By result: success, failure
+
+	mu           sync.Mutex
+	transactions map[SyncKey]uint64
+}
+
+// Finish records a sync that started at start.
+func (s *SyncStats) Finish(start time.Time, ok bool) {
+	s.LastDuration.Store(int64(time.Since(start)))
+	if !ok {
+		s.Runs.Add("failure", 1)
+		return
+	}
+	s.Runs.Add("success", 1)
+	s.LastSuccess.Store(time.Now().Unix())
+}
+
+// Count counts a transaction created, updated or deleted in a Firefly account.
+func (s *SyncStats) Count(accountID, accountName, action string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.transactions == nil {
+		s.transactions = make(map[SyncKey]uint64)
+	}
+	s.transactions[SyncKey{AccountID: accountID, AccountName: accountName, Action: action}]++
+}
+
+// Transactions returns a copy of the transaction counts.
+func (s *SyncStats) Transactions() map[SyncKey]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[SyncKey]uint64, len(s.transactions))
+	for key, n := range s.transactions {
+		counts[key] = n
+	}
+	return counts
+}
+
+// Sync describes the syncs
+var Sync SyncStats
+
+// InstitutionStats holds the health of every bank connection behind SimpleFIN, as of the latest sync.
+type InstitutionStats struct {
+	mu       sync.Mutex
+	statuses []institution.Status
+}
+
+// Set replaces the institutions' health.
+func (s *InstitutionStats) Set(statuses []institution.Status) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses = statuses
+}
+
+// Snapshot returns the institutions' health.
+func (s *InstitutionStats) Snapshot() []institution.Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]institution.Status(nil), s.statuses...)
+}
+
+// Institutions holds the health of SimpleFIN's bank connections
+var Institutions InstitutionStats
+
+// ProgramErrors counts errors logged
+var ProgramErrors atomic.Uint64
+
+// ErrorHook is a zerolog hook counting the errors logged in ProgramErrors.
+type ErrorHook struct{}
+
+// Run counts error, fatal and panic events.
+func (ErrorHook) Run(_ *zerolog.Event, level zerolog.Level, _ string) {
+	if level >= zerolog.ErrorLevel && level <= zerolog.PanicLevel {
+		ProgramErrors.Add(1)
+	}
+}
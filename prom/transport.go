@@ -0,0 +1,156 @@
+package prom
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Transport is an http.RoundTripper counting the requests made to a service, their status codes and latency.
+type Transport struct {
+	Service  string            // firefly, simplefin or openai
+	Endpoint string            // Label for every request, instead of one made from the path, when it is secret
+	Base     http.RoundTripper // Default http.DefaultTransport
+}
+
+// NewClient returns an HTTP client instrumented as service.
+func NewClient(service string, timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: &Transport{Service: service}}
+}
+
+// RoundTrip sends the request through Base and records it, in a span under the request context's span. Latency is measured until the response headers arrive.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	endpoint := t.Endpoint
+	if endpoint == "" {
+		endpoint = Endpoint(req.URL.Path)
+	}
+	span := tracing.StartRequest(req, t.Service, endpoint)
+	defer span.End()
+
+	start := time.Now()
+	resp, err := base.RoundTrip(req)
+	code := "error"
+	switch {
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	default:
+		code = strconv.Itoa(resp.StatusCode)
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+		if resp.StatusCode >= 400 {
+			span.SetStatus(codes.Error, resp.Status)
+		}
+	}
+	HTTP.Observe(t.Service, endpoint, code, time.Since(start))
+	return resp, err
+}
+
+// Endpoint turns a request path into a metric label, replacing IDs with {id} so every account or transaction
+// doesn't get a label of its own.
+func Endpoint(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i, s := range segments {
+		if isID(s) {
+			segments[i] = "{id}"
+		}
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// isID reports whether a path segment is a number, or a long token with digits in it such as a UUID.
+func isID(segment string) bool {
+	digits := 0
+	for _, r := range segment {
+		if unicode.IsDigit(r) {
+			digits++
+		}
+	}
+	return digits > 0 && (digits == len(segment) || len(segment) >= 16)
+}
+
+// HTTPKey identifies the requests counted together.
+type HTTPKey struct {
+	Service  string
+	Endpoint string
+	Code     string // Status code, or "error" when no response came back
+}
+
+// HTTPStats counts HTTP requests by service, endpoint and status code, with a latency histogram by service and
+// endpoint.
+type HTTPStats struct {
+	mu       sync.Mutex
+	requests map[HTTPKey]uint64
+	latency  map[[2]string]*histogram
+}
+
+type histogram struct {
+	count   uint64
+	sum     float64
+	buckets []uint64 // Cumulative, by prometheus.DefBuckets
+}
+
+// Observe records a finished request.
+func (s *HTTPStats) Observe(service, endpoint, code string, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.requests == nil {
+		s.requests = make(map[HTTPKey]uint64)
+		s.latency = make(map[[2]string]*histogram)
+	}
+	s.requests[HTTPKey{Service: service, Endpoint: endpoint, Code: code}]++
+
+	key := [2]string{service, endpoint}
+	h, ok := s.latency[key]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(prometheus.DefBuckets))}
+		s.latency[key] = h
+	}
+	seconds := latency.Seconds()
+	h.count++
+	h.sum += seconds
+	for i, upper := range prometheus.DefBuckets {
+		if seconds <= upper {
+			h.buckets[i]++
+		}
+	}
+}
+
+// Requests returns a copy of the request counts.
+func (s *HTTPStats) Requests() map[HTTPKey]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests := make(map[HTTPKey]uint64, len(s.requests))
+	for key, n := range s.requests {
+		requests[key] = n
+	}
+	return requests
+}
+
+// collectLatency sends the latency histograms to ch.
+func (s *HTTPStats) collectLatency(desc *prometheus.Desc, ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, h := range s.latency {
+		buckets := make(map[float64]uint64, len(h.buckets))
+		for i, upper := range prometheus.DefBuckets {
+			buckets[upper] = h.buckets[i]
+		}
+		ch <- prometheus.MustNewConstHistogram(desc, h.count, h.sum, buckets, key[0], key[1])
+	}
+}
+
+// HTTP counts requests to Firefly, SimpleFIN and the AI provider
+var HTTP HTTPStats
@@ -0,0 +1,74 @@
+package prom_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/prom"
+)
+
+func TestEndpoint(t *testing.T) {
+	for path, want := range map[string]string{
+		"/api/v1/accounts/42/transactions":                          "/api/v1/accounts/{id}/transactions",
+		"/simplefin/accounts":                                       "/simplefin/accounts",
+		"/v1/chat/completions":                                      "/v1/chat/completions",
+		"/api/v1/transactions/9b2f6c1e-8d4a-4f3b-a1c2-7e5d3b9a0f12": "/api/v1/transactions/{id}",
+		"/openai/deployments/gpt4o/chat/completions":                "/openai/deployments/gpt4o/chat/completions",
+	} {
+		if got := prom.Endpoint(path); got != want {
+			t.Fatalf("Got %s for %s, wanted %s", got, path, want)
+		}
+	}
+}
+
+func TestTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/accounts/7" {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	client := prom.NewClient("test", time.Second)
+	for _, path := range []string{"/api/v1/accounts/7", "/api/v1/accounts/8", "/about"} {
+		resp, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("Got error %v, wanted none", err)
+		}
+		_ = resp.Body.Close()
+	}
+
+	requests := prom.HTTP.Requests()
+	if n := requests[prom.HTTPKey{Service: "test", Endpoint: "/api/v1/accounts/{id}", Code: "429"}]; n != 1 {
+		t.Fatalf("Got %d rate limited account requests, wanted 1", n)
+	}
+	if n := requests[prom.HTTPKey{Service: "test", Endpoint: "/api/v1/accounts/{id}", Code: "200"}]; n != 1 {
+		t.Fatalf("Got %d successful account requests, wanted 1", n)
+	}
+	if n := requests[prom.HTTPKey{Service: "test", Endpoint: "/about", Code: "200"}]; n != 1 {
+		t.Fatalf("Got %d about requests, wanted 1", n)
+	}
+}
+
+func TestTransportFixedEndpoint(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &prom.Transport{Service: "fixed", Endpoint: "ntfy"}}
+	resp, err := client.Post(server.URL+"/my-private-topic", "text/plain", nil)
+	if err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+	_ = resp.Body.Close()
+
+	for key := range prom.HTTP.Requests() {
+		if key.Service == "fixed" && key.Endpoint != "ntfy" {
+			t.Fatalf("Got endpoint %s, wanted the fixed ntfy label instead of the path", key.Endpoint)
+		}
+	}
+	if n := prom.HTTP.Requests()[prom.HTTPKey{Service: "fixed", Endpoint: "ntfy", Code: "200"}]; n != 1 {
+		t.Fatalf("Got %d requests labelled ntfy, wanted 1", n)
+	}
+}
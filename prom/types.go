@@ -1,6 +1,9 @@
 package prom
 
 import (
+	"sync"
+	"sync/atomic"
+
 	"github.com/helpcomp/firefly-iii-simplefin-importer/config"
 	"github.com/helpcomp/firefly-iii-simplefin-importer/firefly"
 	"github.com/helpcomp/firefly-iii-simplefin-importer/simplefin"
@@ -13,16 +16,41 @@ type Exporter struct {
 	AccountRefreshTime    *prometheus.Desc
 	OpenAITokens          *prometheus.Desc
 	OpenAIResponseFailure *prometheus.Desc
+	OpenAILatency         *prometheus.Desc
+	OpenAICost            *prometheus.Desc
+	OpenAIBudgetExceeded  *prometheus.Desc
+	OpenAIRetries         *prometheus.Desc
+	OpenAIFallbacks       *prometheus.Desc
+	OpenAICircuitOpen     *prometheus.Desc
 	APICalls              *prometheus.Desc
 	APIErrors             *prometheus.Desc
 	ProgramErrors         *prometheus.Desc
 	RefreshTime           *prometheus.Desc
 	RateLimit             *prometheus.Desc
+	HTTPRequests          *prometheus.Desc
+	HTTPRequestDuration   *prometheus.Desc
+	SyncDuration          *prometheus.Desc
+	SyncRuns              *prometheus.Desc
+	SyncTransactions      *prometheus.Desc
 	categoryActivity      *prometheus.Desc
 	categoryBalance       *prometheus.Desc
+	CategorizationCache   *prometheus.Desc
+	Redactions            *prometheus.Desc
+	ShadowComparisons     *prometheus.Desc
+	EmbeddingLookups      *prometheus.Desc
+	EmbeddingIndexSize    *prometheus.Desc
+	SnapshotTime          *prometheus.Desc
+	SnapshotAge           *prometheus.Desc
+	SnapshotDuration      *prometheus.Desc
+	InstitutionState      *prometheus.Desc
+	InstitutionSince      *prometheus.Desc
+	InstitutionBalanceAge *prometheus.Desc
+	InstitutionErrors     *prometheus.Desc
 	ff                    *firefly.Firefly
 	SimpleFinAccounts     []simplefin.Accounts
 	config                *config.MasterConfig
+	snapshot              atomic.Pointer[Snapshot]
+	mu                    sync.Mutex // Guards SimpleFinAccounts
 }
 
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
@@ -32,10 +60,35 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- e.APICalls
 	ch <- e.APIErrors
 	ch <- e.ProgramErrors
+	ch <- e.RefreshTime
+	ch <- e.RateLimit
+	ch <- e.HTTPRequests
+	ch <- e.HTTPRequestDuration
+	ch <- e.SyncDuration
+	ch <- e.SyncRuns
+	ch <- e.SyncTransactions
 	ch <- e.OpenAITokens
 	ch <- e.OpenAIResponseFailure
+	ch <- e.OpenAILatency
+	ch <- e.OpenAICost
+	ch <- e.OpenAIBudgetExceeded
+	ch <- e.OpenAIRetries
+	ch <- e.OpenAIFallbacks
+	ch <- e.OpenAICircuitOpen
 	ch <- e.categoryActivity
 	ch <- e.categoryBalance
+	ch <- e.CategorizationCache
+	ch <- e.Redactions
+	ch <- e.ShadowComparisons
+	ch <- e.EmbeddingLookups
+	ch <- e.EmbeddingIndexSize
+	ch <- e.SnapshotTime
+	ch <- e.SnapshotAge
+	ch <- e.SnapshotDuration
+	ch <- e.InstitutionState
+	ch <- e.InstitutionSince
+	ch <- e.InstitutionBalanceAge
+	ch <- e.InstitutionErrors
 }
 
 func NewExporter(namespace string, newFireFly *firefly.Firefly, config *config.MasterConfig, accounts []simplefin.Accounts) *Exporter {
@@ -66,10 +119,70 @@ func NewExporter(namespace string, newFireFly *firefly.Firefly, config *config.M
 				"openai",
 				"response_failure",
 			),
-			"Has OpenAI failed",
+			"Count of failed OpenAI requests",
+			[]string{"type"},
+			nil,
+		),
+		OpenAILatency: prometheus.NewDesc(
+			prometheus.BuildFQName(
+				namespace,
+				"openai",
+				"request_seconds",
+			),
+			"Time spent waiting on OpenAI requests",
 			[]string{},
 			nil,
 		),
+		OpenAICost: prometheus.NewDesc(
+			prometheus.BuildFQName(
+				namespace,
+				"openai",
+				"cost_dollars",
+			),
+			"Estimated cost of OpenAI requests, from the configured prices",
+			[]string{},
+			nil,
+		),
+		OpenAIBudgetExceeded: prometheus.NewDesc(
+			prometheus.BuildFQName(
+				namespace,
+				"openai",
+				"budget_exceeded",
+			),
+			"Is the monthly OpenAI budget used up",
+			[]string{},
+			nil,
+		),
+		OpenAIRetries: prometheus.NewDesc(
+			prometheus.BuildFQName(
+				namespace,
+				"openai",
+				"retries",
+			),
+			"Count of OpenAI requests retried after a rate limit, server or network error",
+			[]string{},
+			nil,
+		),
+		OpenAIFallbacks: prometheus.NewDesc(
+			prometheus.BuildFQName(
+				namespace,
+				"openai",
+				"fallbacks",
+			),
+			"Count of OpenAI requests answered by a fallback model",
+			[]string{},
+			nil,
+		),
+		OpenAICircuitOpen: prometheus.NewDesc(
+			prometheus.BuildFQName(
+				namespace,
+				"openai",
+				"circuit_open",
+			),
+			"Is the circuit breaker of the model open, skipping it",
+			[]string{"model"},
+			nil,
+		),
 		OpenAITokens: prometheus.NewDesc(
 			prometheus.BuildFQName(
 				namespace,
@@ -103,7 +216,67 @@ func NewExporter(namespace string, newFireFly *firefly.Firefly, config *config.M
 		ProgramErrors: prometheusFireflyStatsDesc(
 			namespace,
 			"program_errors",
-			"Current status of the system",
+			"Count of errors logged",
+		),
+		RefreshTime: prometheusFireflyStatsDesc(
+			namespace,
+			"refresh_time",
+			"Time the last successful sync finished (Unix Time / Epoch)",
+		),
+		RateLimit: prometheus.NewDesc(
+			prometheus.BuildFQName(
+				namespace,
+				"status",
+				"rate_limited",
+			),
+			"Count of API calls refused with 429 Too Many Requests",
+			[]string{"type"},
+			nil,
+		),
+		HTTPRequests: prometheus.NewDesc(
+			prometheus.BuildFQName(
+				namespace,
+				"http",
+				"requests",
+			),
+			"Count of HTTP requests by service, endpoint and status code",
+			[]string{"service", "endpoint", "code"},
+			nil,
+		),
+		HTTPRequestDuration: prometheus.NewDesc(
+			prometheus.BuildFQName(
+				namespace,
+				"http",
+				"request_duration_seconds",
+			),
+			"Time until the response headers of HTTP requests arrived",
+			[]string{"service", "endpoint"},
+			nil,
+		),
+		SyncDuration: prometheusFireflyStatsDesc(
+			namespace,
+			"sync_duration_seconds",
+			"How long the last sync took",
+		),
+		SyncRuns: prometheus.NewDesc(
+			prometheus.BuildFQName(
+				namespace,
+				"status",
+				"sync_runs",
+			),
+			"Count of syncs by result",
+			[]string{"result"},
+			nil,
+		),
+		SyncTransactions: prometheus.NewDesc(
+			prometheus.BuildFQName(
+				namespace,
+				"sync",
+				"transactions",
+			),
+			"Count of transactions created, updated or deleted by syncs",
+			[]string{"account_id", "account_name", "action"},
+			nil,
 		),
 		categoryActivity: prometheus.NewDesc(
 			prometheus.BuildFQName(
@@ -125,6 +298,96 @@ func NewExporter(namespace string, newFireFly *firefly.Firefly, config *config.M
 			[]string{"type"},
 			nil,
 		),
+		CategorizationCache: prometheus.NewDesc(
+			prometheus.BuildFQName(
+				namespace,
+				"categorization",
+				"cache_lookups",
+			),
+			"Count of categorization cache lookups",
+			[]string{"result"},
+			nil,
+		),
+		Redactions: prometheus.NewDesc(
+			prometheus.BuildFQName(
+				namespace,
+				"categorization",
+				"redactions",
+			),
+			"Count of personal information redacted from AI prompts",
+			[]string{"rule"},
+			nil,
+		),
+		ShadowComparisons: prometheus.NewDesc(
+			prometheus.BuildFQName(
+				namespace,
+				"categorization",
+				"shadow_comparisons",
+			),
+			"Count of shadow categorizer results that agreed or disagreed with the categorizer in use",
+			[]string{"category", "result"},
+			nil,
+		),
+		EmbeddingLookups: prometheus.NewDesc(
+			prometheus.BuildFQName(
+				namespace,
+				"categorization",
+				"embedding_lookups",
+			),
+			"Count of embedding index lookups that found labelled neighbours (hit) or not (miss)",
+			[]string{"result"},
+			nil,
+		),
+		EmbeddingIndexSize: prometheus.NewDesc(
+			prometheus.BuildFQName(
+				namespace,
+				"categorization",
+				"embedding_index_size",
+			),
+			"How many labelled transaction descriptions are in the embedding index",
+			[]string{},
+			nil,
+		),
+		SnapshotTime: prometheusFireflyStatsDesc(
+			namespace,
+			"snapshot_time",
+			"Time the account and category metrics were last read from Firefly (Unix Time / Epoch)",
+		),
+		SnapshotAge: prometheusFireflyStatsDesc(
+			namespace,
+			"snapshot_age_seconds",
+			"How old the account and category metrics are",
+		),
+		SnapshotDuration: prometheusFireflyStatsDesc(
+			namespace,
+			"snapshot_duration_seconds",
+			"How long reading the account and category metrics from Firefly took",
+		),
+		InstitutionState: prometheus.NewDesc(
+			prometheus.BuildFQName(
+				namespace,
+				"institution",
+				"state",
+			),
+			"Health of the bank connection behind SimpleFIN, 1 for its current state",
+			[]string{"institution_id", "institution", "state"},
+			nil,
+		),
+		InstitutionSince: prometheusInstitutionDesc(
+			namespace,
+			"state_since",
+			"Time the bank connection entered its current state (Unix Time / Epoch)",
+		),
+		InstitutionBalanceAge: prometheusInstitutionDesc(
+			namespace,
+			"balance_age_seconds",
+			"Age of the oldest account balance SimpleFIN reported for the institution",
+		),
+		InstitutionErrors: prometheusInstitutionDesc(
+			namespace,
+			"errors",
+			"Errors SimpleFIN reported for the institution in the last sync",
+		),
 		ff:                newFireFly,
 		config:            config,
 		SimpleFinAccounts: accounts,
@@ -144,6 +407,19 @@ func prometheusAccountStatsDesc(namespace string, metric string, help string) *p
 	)
 }
 
+func prometheusInstitutionDesc(namespace string, metric string, help string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(
+			namespace,
+			"institution",
+			metric,
+		),
+		help,
+		[]string{"institution_id", "institution"},
+		nil,
+	)
+}
+
 func prometheusFireflyStatsDesc(namespace string, metric string, help string) *prometheus.Desc {
 	return prometheus.NewDesc(
 		prometheus.BuildFQName(
@@ -0,0 +1,134 @@
+// Package reconcile keeps a per-account history of balance reconciliations
+package reconcile
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/httperror"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/internal/jsonfile"
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+const defaultHistorySize = 100
+
+// Reconciliation outcomes
+const (
+	StatusMatched  = "matched"  // Balances agree within the tolerance
+	StatusAdjusted = "adjusted" // An adjustment transaction was created to close the gap
+	StatusMismatch = "mismatch" // Balances disagree and no adjustment was made
+	StatusError    = "error"    // Reconciliation could not be completed
+)
+
+// Entry is a single reconciliation attempt for an account.
+type Entry struct {
+	AccountID        string          `json:"account_id"`
+	AccountName      string          `json:"account_name"`
+	SimplefinID      string          `json:"simplefin_id"`
+	Time             time.Time       `json:"time"`
+	BalanceDate      time.Time       `json:"balance_date"`
+	SimplefinBalance decimal.Decimal `json:"simplefin_balance"`
+	FireflyBalance   decimal.Decimal `json:"firefly_balance"`
+	PendingBalance   decimal.Decimal `json:"pending_balance"`
+	Difference       decimal.Decimal `json:"difference"`
+	Adjustment       decimal.Decimal `json:"adjustment"`
+	Reconciled       int             `json:"reconciled_transactions"`
+	Status           string          `json:"status"`
+	Error            string          `json:"error,omitempty"`
+}
+
+// History stores reconciliation entries per Firefly account and persists them as JSON.
+type History struct {
+	path    string
+	size    int
+	entries map[string][]Entry
+	mu      sync.Mutex
+}
+
+// NewHistory loads the reconciliation history kept at path, if there is one.
+// size limits how many entries are kept per account.
+func NewHistory(path string, size int) (*History, error) {
+	if size <= 0 {
+		size = defaultHistorySize
+	}
+
+	h := &History{
+		path:    path,
+		size:    size,
+		entries: make(map[string][]Entry),
+	}
+
+	if err := jsonfile.Load(path, &h.entries); err != nil {
+		return h, fmt.Errorf("could not load reconciliation history: %w", err)
+	}
+	return h, nil
+}
+
+// Record appends an entry to the account's history, trims it, and writes the history to disk.
+func (h *History) Record(e Entry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entries := append(h.entries[e.AccountID], e)
+	if len(entries) > h.size {
+		entries = entries[len(entries)-h.size:]
+	}
+	h.entries[e.AccountID] = entries
+
+	if err := h.save(); err != nil {
+		log.Error().Err(err).Msg("Could not save reconciliation history")
+	}
+}
+
+// Account returns a copy of the history for a single account, oldest first.
+func (h *History) Account(accountID string) []Entry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Entry(nil), h.entries[accountID]...)
+}
+
+// Last returns the most recent entry for an account.
+func (h *History) Last(accountID string) (Entry, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entries := h.entries[accountID]
+	if len(entries) == 0 {
+		return Entry{}, false
+	}
+	return entries[len(entries)-1], true
+}
+
+// save persists the history. Called with h.mu held.
+func (h *History) save() error {
+	return jsonfile.Save(h.path, h.entries)
+}
+
+// HandleHistory serves the reconciliation history as JSON. An optional account query
+// parameter limits the response to a single Firefly account.
+func (h *History) HandleHistory(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		httperror.Send(w, req, http.StatusNotImplemented, fmt.Sprintf("Unsupported method %s", req.Method))
+		return
+	}
+
+	result := make(map[string][]Entry)
+	if accountID := req.URL.Query().Get("account"); accountID != "" {
+		result[accountID] = h.Account(accountID)
+	} else {
+		h.mu.Lock()
+		for id, entries := range h.entries {
+			result[id] = append([]Entry(nil), entries...)
+		}
+		h.mu.Unlock()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Err(err).Msg("Failed to encode reconciliation history")
+	}
+}
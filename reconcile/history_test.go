@@ -0,0 +1,46 @@
+package reconcile_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/reconcile"
+	"github.com/shopspring/decimal"
+)
+
+func TestHistoryRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reconciliation.json")
+	h, err := reconcile.NewHistory(path, 2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s\n", err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		h.Record(reconcile.Entry{
+			AccountID:  "25",
+			Difference: decimal.NewFromInt(int64(i)),
+			Status:     reconcile.StatusMismatch,
+		})
+	}
+
+	entries := h.Account("25")
+	if len(entries) != 2 {
+		t.Fatalf("Got %d entries, wanted 2", len(entries))
+	}
+	if !entries[0].Difference.Equal(decimal.NewFromInt(2)) {
+		t.Fatalf("Got %s as oldest Difference, wanted 2", entries[0].Difference)
+	}
+
+	// Reload from disk
+	h, err = reconcile.NewHistory(path, 2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s\n", err)
+	}
+	last, ok := h.Last("25")
+	if !ok {
+		t.Fatalf("Missing history for account 25 after reload")
+	}
+	if !last.Difference.Equal(decimal.NewFromInt(3)) {
+		t.Fatalf("Got %s as last Difference, wanted 3", last.Difference)
+	}
+}
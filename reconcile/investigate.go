@@ -0,0 +1,265 @@
+package reconcile
+
+import (
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/firefly"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/simplefin"
+	"github.com/shopspring/decimal"
+	"golang.org/x/exp/slices"
+)
+
+// Investigation finding kinds
+const (
+	FindingMissingInFirefly   = "missing_in_firefly"   // SimpleFIN transaction with no Firefly counterpart
+	FindingMissingInSimplefin = "missing_in_simplefin" // Imported Firefly transaction SimpleFIN no longer reports
+	FindingManual             = "manual"               // Firefly transaction without an external ID
+	FindingSignFlip           = "sign_flip"            // Same transaction, opposite direction
+	FindingAmountMismatch     = "amount_mismatch"      // Same transaction, different amount
+	FindingDuplicate          = "duplicate"            // External ID imported more than once
+	FindingPendingDouble      = "pending_double"       // Pending copy still in Firefly next to its posted version
+)
+
+const (
+	maxExplainCandidates = 24 // Keeps the subset search fast
+	maxExplainSize       = 4  // Largest combination of findings tried when explaining the difference
+	pendingMatchWindow   = 5 * 24 * time.Hour
+)
+
+// Finding is a single discrepancy between SimpleFIN and Firefly. Impact is how much the Firefly
+// balance would move if the discrepancy was fixed.
+type Finding struct {
+	Kind        string          `json:"kind"`
+	ExternalID  string          `json:"external_id,omitempty"`
+	FireflyID   string          `json:"firefly_id,omitempty"`
+	Description string          `json:"description"`
+	Date        string          `json:"date"`
+	Amount      decimal.Decimal `json:"amount"`
+	Impact      decimal.Decimal `json:"impact"`
+}
+
+// Report is the result of investigating a balance mismatch for one account.
+type Report struct {
+	AccountID   string          `json:"account_id"`
+	AccountName string          `json:"account_name"`
+	Generated   time.Time       `json:"generated"`
+	Difference  decimal.Decimal `json:"difference"`
+	Findings    []Finding       `json:"findings"`
+	Explanation []Finding       `json:"explanation,omitempty"` // Smallest set of findings that adds up to Difference
+	Explained   bool            `json:"explained"`
+}
+
+// fireflySplit is a Firefly split with its amount signed from the point of view of the investigated account.
+type fireflySplit struct {
+	groupID string
+	trans   firefly.Transaction
+	signed  decimal.Decimal
+	date    time.Time
+}
+
+// Investigate compares the SimpleFIN transactions of an account with the Firefly transactions of the matching
+// asset account. difference is SimpleFIN's balance minus the balance Firefly was expected to have; findings whose
+// impacts add up to it (within tolerance) are reported as the explanation.
+func Investigate(acct simplefin.Accounts, accountID string, fireflyTxns []firefly.Transactions, difference, tolerance decimal.Decimal) Report {
+	report := Report{
+		AccountID:  accountID,
+		Generated:  time.Now(),
+		Difference: difference,
+	}
+
+	// Index the Firefly splits that touch this account
+	var splits []fireflySplit
+	byExternalID := make(map[string][]fireflySplit)
+	for _, group := range fireflyTxns {
+		for _, trans := range group.Attributes.Transactions {
+			var signed decimal.Decimal
+			switch accountID {
+			case trans.DestinationID:
+				signed = trans.Amount
+			case trans.SourceID:
+				signed = trans.Amount.Neg()
+			default:
+				continue
+			}
+			date, _ := time.Parse(time.RFC3339, trans.Date)
+			split := fireflySplit{groupID: group.ID, trans: trans, signed: signed, date: date}
+			splits = append(splits, split)
+			if trans.ExternalID != "" {
+				byExternalID[trans.ExternalID] = append(byExternalID[trans.ExternalID], split)
+			}
+		}
+	}
+
+	// SimpleFIN side
+	simplefinIDs := make(map[string]bool, len(acct.Transactions))
+	for _, trans := range acct.Transactions {
+		simplefinIDs[trans.ID] = true
+		date := time.Unix(trans.TransactedAt, 0).Format(time.DateOnly)
+
+		matches := byExternalID[trans.ID]
+		if len(matches) == 0 {
+			report.Findings = append(report.Findings, Finding{
+				Kind:        FindingMissingInFirefly,
+				ExternalID:  trans.ID,
+				Description: trans.Description,
+				Date:        date,
+				Amount:      trans.Amount,
+				Impact:      trans.Amount,
+			})
+			continue
+		}
+
+		match := matches[0]
+		switch {
+		case match.signed.Equal(trans.Amount):
+		case match.signed.Neg().Equal(trans.Amount):
+			report.Findings = append(report.Findings, Finding{
+				Kind:        FindingSignFlip,
+				ExternalID:  trans.ID,
+				FireflyID:   match.groupID,
+				Description: trans.Description,
+				Date:        date,
+				Amount:      match.signed,
+				Impact:      trans.Amount.Sub(match.signed),
+			})
+		default:
+			report.Findings = append(report.Findings, Finding{
+				Kind:        FindingAmountMismatch,
+				ExternalID:  trans.ID,
+				FireflyID:   match.groupID,
+				Description: trans.Description,
+				Date:        date,
+				Amount:      match.signed,
+				Impact:      trans.Amount.Sub(match.signed),
+			})
+		}
+
+		// Every copy after the first is counted twice
+		for _, dup := range matches[1:] {
+			report.Findings = append(report.Findings, Finding{
+				Kind:        FindingDuplicate,
+				ExternalID:  trans.ID,
+				FireflyID:   dup.groupID,
+				Description: dup.trans.Description,
+				Date:        dup.date.Format(time.DateOnly),
+				Amount:      dup.signed,
+				Impact:      dup.signed.Neg(),
+			})
+		}
+	}
+
+	// Firefly side
+	start, end := simplefinWindow(acct)
+	for _, split := range splits {
+		if split.date.Before(start) || split.date.After(end) {
+			continue
+		}
+
+		finding := Finding{
+			ExternalID:  split.trans.ExternalID,
+			FireflyID:   split.groupID,
+			Description: split.trans.Description,
+			Date:        split.date.Format(time.DateOnly),
+			Amount:      split.signed,
+			Impact:      split.signed.Neg(),
+		}
+
+		switch {
+		case split.trans.ExternalID == "":
+			finding.Kind = FindingManual
+		case simplefinIDs[split.trans.ExternalID]:
+			continue
+		case slices.Contains(split.trans.Tags, "Pending") && hasPostedTwin(split, splits):
+			finding.Kind = FindingPendingDouble
+		default:
+			finding.Kind = FindingMissingInSimplefin
+		}
+		report.Findings = append(report.Findings, finding)
+	}
+
+	report.Explanation, report.Explained = explain(report.Findings, difference, tolerance)
+	return report
+}
+
+// simplefinWindow returns the date range covered by the SimpleFIN transactions of an account.
+func simplefinWindow(acct simplefin.Accounts) (time.Time, time.Time) {
+	var start, end time.Time
+	for _, trans := range acct.Transactions {
+		t := time.Unix(trans.TransactedAt, 0)
+		if start.IsZero() || t.Before(start) {
+			start = t
+		}
+		if t.After(end) {
+			end = t
+		}
+	}
+	// Firefly stores dates without times, so widen the window to whole days
+	return start.Truncate(24 * time.Hour), end.Add(24 * time.Hour)
+}
+
+// hasPostedTwin reports whether a non-pending Firefly split with the same amount sits close to a pending one.
+func hasPostedTwin(pending fireflySplit, splits []fireflySplit) bool {
+	for _, other := range splits {
+		if other.groupID == pending.groupID || other.trans.ExternalID == pending.trans.ExternalID {
+			continue
+		}
+		if slices.Contains(other.trans.Tags, "Pending") || !other.signed.Equal(pending.signed) {
+			continue
+		}
+		gap := other.date.Sub(pending.date)
+		if gap >= -pendingMatchWindow && gap <= pendingMatchWindow {
+			return true
+		}
+	}
+	return false
+}
+
+// explain finds the smallest combination of findings whose impacts add up to the difference.
+func explain(findings []Finding, difference, tolerance decimal.Decimal) ([]Finding, bool) {
+	if difference.Abs().LessThanOrEqual(tolerance) {
+		return nil, true
+	}
+
+	var candidates []Finding
+	for _, f := range findings {
+		if !f.Impact.IsZero() {
+			candidates = append(candidates, f)
+		}
+		if len(candidates) == maxExplainCandidates {
+			break
+		}
+	}
+
+	for size := 1; size <= maxExplainSize && size <= len(candidates); size++ {
+		if found := searchSubset(candidates, make([]int, 0, size), 0, size, difference, tolerance); found != nil {
+			result := make([]Finding, 0, size)
+			for _, i := range found {
+				result = append(result, candidates[i])
+			}
+			return result, true
+		}
+	}
+	return nil, false
+}
+
+// searchSubset walks every combination of the given size in order and returns the first whose impacts sum to
+// the target.
+func searchSubset(candidates []Finding, chosen []int, next, size int, target, tolerance decimal.Decimal) []int {
+	if len(chosen) == size {
+		sum := decimal.Zero
+		for _, i := range chosen {
+			sum = sum.Add(candidates[i].Impact)
+		}
+		if sum.Sub(target).Abs().LessThanOrEqual(tolerance) {
+			return append([]int(nil), chosen...)
+		}
+		return nil
+	}
+
+	for i := next; i <= len(candidates)-(size-len(chosen)); i++ {
+		if found := searchSubset(candidates, append(chosen, i), i+1, size, target, tolerance); found != nil {
+			return found
+		}
+	}
+	return nil
+}
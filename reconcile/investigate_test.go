@@ -0,0 +1,60 @@
+package reconcile_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/firefly"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/reconcile"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/simplefin"
+	"github.com/shopspring/decimal"
+)
+
+func TestInvestigate(t *testing.T) {
+	day := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	acct := simplefin.Accounts{
+		ID: "ACT-1",
+		Transactions: []simplefin.Transactions{
+			{ID: "TRN-1", TransactedAt: day.Unix(), Amount: decimal.RequireFromString("-12.50"), Description: "COFFEE"},
+			{ID: "TRN-2", TransactedAt: day.Unix(), Amount: decimal.RequireFromString("-40.00"), Description: "GROCERY"},
+			{ID: "TRN-3", TransactedAt: day.Unix(), Amount: decimal.RequireFromString("-7.25"), Description: "PARKING"},
+		},
+	}
+	txns := []firefly.Transactions{
+		{ID: "100", Attributes: firefly.TransactionAttributes{Transactions: []firefly.Transaction{
+			{Date: "2025-03-10T00:00:00+00:00", Amount: decimal.RequireFromString("12.50"), SourceID: "25", DestinationName: "Coffee", ExternalID: "TRN-1"},
+		}}},
+		// Recorded as a deposit instead of a withdrawal
+		{ID: "101", Attributes: firefly.TransactionAttributes{Transactions: []firefly.Transaction{
+			{Date: "2025-03-10T00:00:00+00:00", Amount: decimal.RequireFromString("40.00"), DestinationID: "25", SourceName: "Grocery", ExternalID: "TRN-2"},
+		}}},
+		// Imported twice
+		{ID: "102", Attributes: firefly.TransactionAttributes{Transactions: []firefly.Transaction{
+			{Date: "2025-03-10T00:00:00+00:00", Amount: decimal.RequireFromString("12.50"), SourceID: "25", DestinationName: "Coffee", ExternalID: "TRN-1"},
+		}}},
+	}
+
+	// Firefly: -12.50 + 40.00 - 12.50 = 15.00; SimpleFIN: -59.75
+	difference := decimal.RequireFromString("-74.75")
+	report := reconcile.Investigate(acct, "25", txns, difference, decimal.RequireFromString("0.01"))
+
+	kinds := make(map[string]int)
+	for _, f := range report.Findings {
+		kinds[f.Kind]++
+	}
+	if kinds[reconcile.FindingMissingInFirefly] != 1 {
+		t.Fatalf("Got %d missing_in_firefly findings, wanted 1", kinds[reconcile.FindingMissingInFirefly])
+	}
+	if kinds[reconcile.FindingSignFlip] != 1 {
+		t.Fatalf("Got %d sign_flip findings, wanted 1", kinds[reconcile.FindingSignFlip])
+	}
+	if kinds[reconcile.FindingDuplicate] != 1 {
+		t.Fatalf("Got %d duplicate findings, wanted 1", kinds[reconcile.FindingDuplicate])
+	}
+	if !report.Explained {
+		t.Fatalf("Difference %s was not explained by %v", difference, report.Findings)
+	}
+	if len(report.Explanation) != 3 {
+		t.Fatalf("Got %d findings in the explanation, wanted 3", len(report.Explanation))
+	}
+}
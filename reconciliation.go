@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/config"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/duration"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/firefly"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/logging"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/reconcile"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/simplefin"
+	"github.com/shopspring/decimal"
+	"golang.org/x/exp/slices"
+)
+
+// ReconcileAccount compares the Firefly balance with the balance SimpleFIN reported at its BalanceDate.
+// When the balances agree within the configured tolerance, imported transactions up to BalanceDate are marked
+// as reconciled. When they do not agree and allowAdjust is set, an adjustment dated at BalanceDate closes the gap,
+// as long as it does not exceed the configured maximum. Every attempt is recorded in the reconciliation history.
+func ReconcileAccount(ctx context.Context, ff *firefly.Firefly, c *config.MasterConfig, history *reconcile.History, acct simplefin.Accounts, ffAccount firefly.Account, allowAdjust bool) reconcile.Entry {
+	_, nonAsset := c.NonAssetAccounts[c.Accounts[acct.ID]]
+
+	balanceDate := simplefinBalanceDate(acct)
+
+	entry := reconcile.Entry{
+		AccountID:        ffAccount.ID,
+		AccountName:      ffAccount.Attributes.Name,
+		SimplefinID:      acct.ID,
+		Time:             time.Now(),
+		BalanceDate:      balanceDate,
+		SimplefinBalance: acct.Balance,
+	}
+
+	defer func() {
+		if history != nil {
+			history.Record(entry)
+		}
+	}()
+
+	fireflyBalance, pending, err := expectedBalance(ctx, ff, c, acct, ffAccount.ID)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Str("ID", ffAccount.ID).Msg("Could not fetch account balance for reconciliation")
+		entry.Status = reconcile.StatusError
+		entry.Error = err.Error()
+		return entry
+	}
+	entry.FireflyBalance = fireflyBalance
+	entry.PendingBalance = pending
+	entry.Difference = acct.Balance.Sub(fireflyBalance.Sub(pending))
+
+	tolerance := decimal.NewFromFloat(c.Reconciliation.Tolerance)
+	maxAdjustment := decimal.NewFromFloat(c.Reconciliation.MaxAdjustment)
+
+	switch {
+	case entry.Difference.Abs().LessThanOrEqual(tolerance):
+		entry.Status = reconcile.StatusMatched
+	case !allowAdjust:
+		entry.Status = reconcile.StatusMismatch
+	case !nonAsset && maxAdjustment.IsPositive() && entry.Difference.Abs().GreaterThan(maxAdjustment):
+		// Large differences usually mean a missing or duplicated transaction; adjusting would hide it
+		logging.Ctx(ctx).Warn().
+			Str("Type", "Reconciliation").
+			Str("Name", ffAccount.Attributes.Name).
+			Float64("Difference", entry.Difference.InexactFloat64()).
+			Float64("MaxAdjustment", maxAdjustment.InexactFloat64()).
+			Msg("Difference exceeds the maximum automatic adjustment, not reconciling")
+		entry.Status = reconcile.StatusMismatch
+	default:
+		if err = createAdjustment(ctx, ff, c, acct, ffAccount, balanceDate, entry.Difference); err != nil {
+			logging.Ctx(ctx).Error().Err(err).Str("Name", ffAccount.Attributes.Name).Msg("Could not create reconciliation adjustment")
+			entry.Status = reconcile.StatusError
+			entry.Error = err.Error()
+			return entry
+		}
+		entry.Status = reconcile.StatusAdjusted
+		entry.Adjustment = entry.Difference
+	}
+
+	if entry.Status == reconcile.StatusMismatch {
+		return entry
+	}
+
+	if !nonAsset && c.Reconciliation.ShouldMarkReconciled() {
+		entry.Reconciled, err = markTransactionsReconciled(ctx, ff, ffAccount.ID, balanceDate)
+		if err != nil {
+			logging.Ctx(ctx).Error().Err(err).Str("Name", ffAccount.Attributes.Name).Msg("Could not mark transactions as reconciled")
+			entry.Error = err.Error()
+		}
+	}
+
+	logging.Ctx(ctx).Info().
+		Str("event", "account.reconciled").
+		Str("Type", "Reconciliation").
+		Str("Name", ffAccount.Attributes.Name).
+		Str("ID", ffAccount.ID).
+		Str("Status", entry.Status).
+		Float64("Balance", acct.Balance.InexactFloat64()).
+		Float64("Adjustment", entry.Adjustment.InexactFloat64()).
+		Int("Reconciled", entry.Reconciled).
+		Msgf("Reconciled Account %s", acct.Name)
+	return entry
+}
+
+// simplefinBalanceDate returns the time SimpleFIN's balance was taken, or now if it was not reported.
+func simplefinBalanceDate(acct simplefin.Accounts) time.Time {
+	if acct.BalanceDate > 0 {
+		return time.Unix(acct.BalanceDate, 0)
+	}
+	return time.Now()
+}
+
+// expectedBalance returns the Firefly balance of the account at the end of SimpleFIN's BalanceDate, and the
+// pending amount that Firefly already includes but SimpleFIN's balance does not.
+// Non-asset accounts never import transactions, so there is nothing pending to exclude for them.
+func expectedBalance(ctx context.Context, ff *firefly.Firefly, c *config.MasterConfig, acct simplefin.Accounts, accountID string) (balance decimal.Decimal, pending decimal.Decimal, err error) {
+	balanceDate := simplefinBalanceDate(acct)
+
+	atDate, err := ff.FetchAccount(ctx, accountID, balanceDate.Format(time.DateOnly))
+	if err != nil {
+		return decimal.Zero, decimal.Zero, err
+	}
+
+	if _, nonAsset := c.NonAssetAccounts[c.Accounts[acct.ID]]; nonAsset {
+		return atDate.Attributes.CurrentBalance, decimal.Zero, nil
+	}
+	return atDate.Attributes.CurrentBalance, pendingUpTo(acct, balanceDate), nil
+}
+
+// pendingUpTo sums the pending SimpleFIN transactions that happened on or before the balance date.
+func pendingUpTo(acct simplefin.Accounts, balanceDate time.Time) decimal.Decimal {
+	pending := decimal.Zero
+	for _, trans := range acct.Transactions {
+		if trans.Pending && !time.Unix(trans.TransactedAt, 0).After(balanceDate) {
+			pending = pending.Add(trans.Amount)
+		}
+	}
+	return pending
+}
+
+// createAdjustment creates a single transaction dated at the balance date for the given difference.
+// Accounts configured as "reconciliation" in non_asset_accounts use Firefly's reconciliation type;
+// all others get a plain deposit or withdrawal.
+func createAdjustment(ctx context.Context, ff *firefly.Firefly, c *config.MasterConfig, acct simplefin.Accounts, ffAccount firefly.Account, balanceDate time.Time, difference decimal.Decimal) error {
+	currency := acct.Currency
+	if currency == "" {
+		currency = "USD"
+	}
+	reconciliationAccount := fmt.Sprintf("%s reconciliation (%s)", ffAccount.Attributes.Name, currency)
+	useReconciliation := c.NonAssetAccounts[c.Accounts[acct.ID]] == "reconciliation"
+
+	adjustment := firefly.Transaction{
+		Date:          balanceDate.Format(time.DateOnly),
+		Amount:        difference.Abs(),
+		Description:   "Account Reconciliation",
+		DestinationID: ffAccount.ID,
+		SourceName:    reconciliationAccount,
+		Type:          "reconciliation",
+		Reconciled:    true,
+	}
+
+	if !useReconciliation {
+		adjustment.SourceName = defaultAccountName
+		adjustment.Type = "deposit"
+	}
+
+	if difference.IsNegative() {
+		adjustment.SourceID = ffAccount.ID
+		adjustment.SourceName = ""
+		adjustment.DestinationID = ""
+		adjustment.DestinationName = reconciliationAccount
+
+		if !useReconciliation {
+			adjustment.DestinationName = defaultAccountName
+			adjustment.Type = "withdrawal"
+		}
+	}
+
+	return ff.CreateTransaction(ctx, adjustment)
+}
+
+// markTransactionsReconciled flags every imported, settled transaction of the account up to the balance date
+// as reconciled. Transactions without an external ID were entered by hand and are left alone, and ones already
+// reconciled aren't sent again, so a sync only updates transactions imported since the last one.
+func markTransactionsReconciled(ctx context.Context, ff *firefly.Firefly, accountID string, balanceDate time.Time) (int, error) {
+	t, err := duration.ParseDuration(cli.SimplefinLoopbackDuration)
+	if err != nil {
+		return 0, err
+	}
+
+	txns, err := ff.AccountTransactionsRange(ctx, accountID, firefly.TransactionsKey{
+		Start: balanceDate.Add(-t).Format(time.DateOnly),
+		End:   balanceDate.Format(time.DateOnly),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	marked := 0
+	for _, group := range txns {
+		for _, trans := range group.Attributes.Transactions {
+			if trans.Reconciled || trans.ExternalID == "" || slices.Contains(trans.Tags, "Pending") {
+				continue
+			}
+			if err = ff.MarkReconciled(ctx, group.ID, trans.JournalID); err != nil {
+				return marked, err
+			}
+			marked++
+		}
+	}
+	return marked, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/config"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/firefly"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/reconcile"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/simplefin"
+	"github.com/shopspring/decimal"
+)
+
+// reconcileServer is a Firefly server with one account holding balance, whose transactions are txns. Every PUT and
+// POST is recorded.
+type reconcileServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	updates []string
+}
+
+func newReconcileServer(t *testing.T, balance string, txns []firefly.Transactions) *reconcileServer {
+	s := &reconcileServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/transactions"):
+			var resp firefly.TxnsResponse
+			resp.Data = txns
+			resp.Meta.Pagination.CurrentPage, resp.Meta.Pagination.TotalPages = 1, 1
+			_ = json.NewEncoder(w).Encode(resp)
+		case r.Method == "GET" && r.URL.Path == "/api/v1/accounts/25":
+			_ = json.NewEncoder(w).Encode(map[string]firefly.Account{"data": {
+				ID:         "25",
+				Attributes: firefly.AccountAttributes{Name: "Checking", Type: "asset", CurrentBalance: decimal.RequireFromString(balance)},
+			}})
+		case r.Method == "PUT" || r.Method == "POST":
+			s.mu.Lock()
+			s.updates = append(s.updates, r.Method+" "+r.URL.Path)
+			s.mu.Unlock()
+			_, _ = w.Write([]byte(`{"data":{"id":"1"}}`))
+		default:
+			t.Errorf("Got unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func reconcileFixture(t *testing.T) (*config.MasterConfig, simplefin.Accounts, firefly.Account, []firefly.Transactions) {
+	loopback := cli.SimplefinLoopbackDuration
+	cli.SimplefinLoopbackDuration = "10d"
+	t.Cleanup(func() { cli.SimplefinLoopbackDuration = loopback })
+
+	cfg := &config.MasterConfig{
+		Accounts:       map[string]string{"ACT-1": "25"},
+		Reconciliation: config.ReconciliationConfig{Tolerance: 0.01},
+	}
+	acct := simplefin.Accounts{ID: "ACT-1", Name: "Checking", Balance: decimal.RequireFromString("100.00"), BalanceDate: time.Now().Unix()}
+	ffAccount := firefly.Account{ID: "25", Attributes: firefly.AccountAttributes{Name: "Checking"}}
+	txns := []firefly.Transactions{
+		{ID: "1", Attributes: firefly.TransactionAttributes{Transactions: []firefly.Transaction{{JournalID: "11", ExternalID: "TRN-1"}}}},
+		{ID: "2", Attributes: firefly.TransactionAttributes{Transactions: []firefly.Transaction{{JournalID: "12", ExternalID: "TRN-2", Reconciled: true}}}},
+		{ID: "3", Attributes: firefly.TransactionAttributes{Transactions: []firefly.Transaction{{JournalID: "13"}}}},
+		{ID: "4", Attributes: firefly.TransactionAttributes{Transactions: []firefly.Transaction{{JournalID: "14", ExternalID: "TRN-4", Tags: []string{"Pending"}}}}},
+	}
+	return cfg, acct, ffAccount, txns
+}
+
+func TestReconcileAccountMatched(t *testing.T) {
+	cfg, acct, ffAccount, txns := reconcileFixture(t)
+	server := newReconcileServer(t, "100.00", txns)
+	ff := firefly.New(server.Client(), "token", server.URL)
+
+	entry := ReconcileAccount(t.Context(), ff, cfg, nil, acct, ffAccount, false)
+	if entry.Status != reconcile.StatusMatched || entry.Reconciled != 1 {
+		t.Fatalf("Got status %s with %d reconciled, wanted matched with 1", entry.Status, entry.Reconciled)
+	}
+	// Already reconciled, entered by hand and pending transactions are left alone
+	if len(server.updates) != 1 || server.updates[0] != "PUT /api/v1/transactions/1" {
+		t.Fatalf("Got updates %v, wanted only transaction 1 marked reconciled", server.updates)
+	}
+}
+
+func TestReconcileAccountMismatch(t *testing.T) {
+	cfg, acct, ffAccount, txns := reconcileFixture(t)
+	server := newReconcileServer(t, "90.00", txns)
+	ff := firefly.New(server.Client(), "token", server.URL)
+
+	entry := ReconcileAccount(t.Context(), ff, cfg, nil, acct, ffAccount, false)
+	if entry.Status != reconcile.StatusMismatch || !entry.Difference.Equal(decimal.NewFromInt(10)) {
+		t.Fatalf("Got status %s with difference %s, wanted a mismatch of 10", entry.Status, entry.Difference)
+	}
+	if len(server.updates) != 0 {
+		t.Fatalf("Got updates %v, wanted none without adjusting", server.updates)
+	}
+}
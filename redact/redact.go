@@ -0,0 +1,63 @@
+// Package redact removes personal information (emails, phone numbers, card and reference numbers) from text
+// before it is sent to an AI provider
+package redact
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Rule names, used as the redaction metric label
+const (
+	Email  = "email"
+	Phone  = "phone"
+	Digits = "digits"
+	Custom = "custom"
+)
+
+// Rule replaces every match of a pattern.
+type Rule struct {
+	Name        string
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// Builtin rules run in order, before any configured patterns. Phone numbers go before digit runs so they are
+// recognized whole.
+var Builtin = []Rule{
+	{Email, regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), "[EMAIL]"},
+	{Phone, regexp.MustCompile(`(\+?1[-. ]?)?\(?\b\d{3}\)?[-. ]\d{3}[-. ]\d{4}\b`), "[PHONE]"},
+	{Digits, regexp.MustCompile(`\d{4,}`), "[NUMBER]"},
+}
+
+// Redactor applies the built-in rules plus configured patterns.
+type Redactor struct {
+	rules []Rule
+}
+
+// New compiles extra patterns into rules that run after the built-in ones. Returns an error for an invalid pattern.
+func New(extra []string) (*Redactor, error) {
+	r := &Redactor{rules: append([]Rule{}, Builtin...)}
+	for _, p := range extra {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return r, fmt.Errorf("invalid redaction pattern %q: %w", p, err)
+		}
+		r.rules = append(r.rules, Rule{Custom, re, "[REDACTED]"})
+	}
+	return r, nil
+}
+
+// Redact returns text with personal information replaced, and how many replacements each rule made.
+func (r *Redactor) Redact(text string) (string, map[string]int) {
+	counts := make(map[string]int)
+	for _, rule := range r.rules {
+		n := len(rule.Pattern.FindAllStringIndex(text, -1))
+		if n == 0 {
+			continue
+		}
+		counts[rule.Name] += n
+		text = rule.Pattern.ReplaceAllLiteralString(text, rule.Replacement)
+	}
+	return text, counts
+}
@@ -0,0 +1,37 @@
+package redact_test
+
+import (
+	"testing"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/redact"
+)
+
+func TestRedact(t *testing.T) {
+	r, err := redact.New([]string{`(?i)ZELLE TO [A-Z]+ [A-Z]+`})
+	if err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+
+	tests := map[string]string{
+		"NETFLIX.COM 866-579-7172 CA":    "NETFLIX.COM [PHONE] CA",
+		"AMAZON XXXX1234 REF 998877665":  "AMAZON XXXX[NUMBER] REF [NUMBER]",
+		"PAYPAL *JANE.DOE@EXAMPLE.COM":   "PAYPAL *[EMAIL]",
+		"ZELLE TO JOHN SMITH CONF 12345": "[REDACTED] CONF [NUMBER]",
+		"CALL (555) 123-4567 FOR HELP":   "CALL [PHONE] FOR HELP",
+		"7-ELEVEN":                       "7-ELEVEN",
+	}
+	for text, want := range tests {
+		if got, _ := r.Redact(text); got != want {
+			t.Fatalf("Got %q for %q, wanted %q", got, text, want)
+		}
+	}
+
+	_, counts := r.Redact("8665797172 and 866-579-7172 and a@b.io")
+	if counts[redact.Digits] != 1 || counts[redact.Phone] != 1 || counts[redact.Email] != 1 {
+		t.Fatalf("Got counts %v, wanted one of each", counts)
+	}
+
+	if _, err = redact.New([]string{"("}); err == nil {
+		t.Fatalf("Got no error for an invalid pattern, wanted one")
+	}
+}
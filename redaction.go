@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/config"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/prom"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/redact"
+	"github.com/rs/zerolog/log"
+)
+
+// buildRedactor compiles the redaction rules, or returns nil if redaction is disabled.
+// Invalid configured patterns are logged and the built-in rules are used on their own.
+func buildRedactor(cfg *config.MasterConfig) *redact.Redactor {
+	if cfg.Redaction.Disabled {
+		return nil
+	}
+
+	r, err := redact.New(cfg.Redaction.Patterns)
+	if err != nil {
+		log.Error().Err(err).Msg("Ignoring configured redaction patterns")
+		r, _ = redact.New(nil)
+	}
+	return r
+}
+
+// redact removes personal information from text that is about to be sent to the AI provider, counting what was
+// removed.
+func (c *Categorizer) redact(text string) string {
+	return redactText(c.redactor, text)
+}
+
+// redactText removes personal information from text with r, which may be nil, counting what was removed.
+func redactText(r *redact.Redactor, text string) string {
+	if r == nil || text == "" {
+		return text
+	}
+
+	redacted, counts := r.Redact(text)
+	for rule, n := range counts {
+		prom.Redactions.Add(rule, uint64(n))
+	}
+	if redacted != text {
+		log.Debug().Str("Redacted", redacted).Msg("Redacted text sent to the AI provider")
+	}
+	return redacted
+}
+
+// isLocalEndpoint reports whether an AI provider URL is on this machine or the local network: localhost, a private
+// or link-local address, a .local/.lan/.internal name, or a single-label name such as a docker compose service.
+// Names are not resolved.
+func isLocalEndpoint(endpoint string) bool {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" {
+		return true
+	}
+	for _, suffix := range []string{".localhost", ".local", ".lan", ".internal"} {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast()
+	}
+	return !strings.Contains(host, ".")
+}
@@ -0,0 +1,229 @@
+// Package resilience keeps a flaky remote service from slowing everything down: a token-bucket rate limiter,
+// retries with exponential backoff and a circuit breaker
+package resilience
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrOpen is returned by Breaker.Do while the breaker is open.
+var ErrOpen = errors.New("circuit breaker open")
+
+// Limiter is a token bucket allowing a steady rate of calls with short bursts. A nil Limiter allows everything.
+type Limiter struct {
+	mu       sync.Mutex
+	interval time.Duration // Between tokens
+	burst    float64
+	tokens   float64
+	last     time.Time
+}
+
+// NewLimiter creates a Limiter allowing perMinute calls a minute, up to burst at once. It returns nil, no limit,
+// if perMinute isn't positive.
+func NewLimiter(perMinute float64, burst int) *Limiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		interval: time.Duration(float64(time.Minute) / perMinute),
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		last:     time.Now(),
+	}
+}
+
+// Wait blocks until a call is allowed, or ctx is done.
+func (l *Limiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	for {
+		wait := l.reserve()
+		if wait == 0 {
+			return nil
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// reserve takes a token if there is one, or returns how long until there is.
+func (l *Limiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+float64(now.Sub(l.last))/float64(l.interval))
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) * float64(l.interval))
+}
+
+// Backoff retries failed calls, waiting Initial before the first retry and twice as long before each one after,
+// up to Max, with some jitter.
+type Backoff struct {
+	Retries int
+	Initial time.Duration
+	Max     time.Duration // Default 30s
+}
+
+// Delay returns how long to wait before retry number attempt, counting from 1.
+func (b Backoff) Delay(attempt int) time.Duration {
+	limit := b.Max
+	if limit <= 0 {
+		limit = 30 * time.Second
+	}
+	d := b.Initial
+	for i := 1; i < attempt && d < limit; i++ {
+		d *= 2
+	}
+	d = min(d, limit)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1)) // Between half and all of it
+}
+
+// Retry calls fn until it succeeds, returns an error retryable doesn't accept, the retries run out or ctx is done.
+// onRetry, which may be nil, is called before each retry. The last error is returned.
+func (b Backoff) Retry(ctx context.Context, fn func() error, retryable func(error) bool, onRetry func(attempt int, err error)) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= b.Retries || !retryable(err) {
+			return err
+		}
+		if onRetry != nil {
+			onRetry(attempt+1, err)
+		}
+
+		timer := time.NewTimer(b.Delay(attempt + 1))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// Breaker states
+const (
+	Closed   = "closed"    // Calls go through
+	Open     = "open"      // Calls are refused until the cool-down is over
+	HalfOpen = "half-open" // One trial call goes through
+)
+
+// Breaker stops calls to a service after it failed Threshold times in a row, for a cool-down. After the
+// cool-down one trial call is let through: if it succeeds the breaker closes, otherwise it opens again.
+// A nil Breaker never opens.
+type Breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	state     string
+	openedAt  time.Time
+	trial     bool // A half-open trial call is in flight
+}
+
+// NewBreaker creates a closed Breaker. It returns nil, never open, if threshold isn't positive.
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &Breaker{threshold: threshold, cooldown: cooldown, state: Closed}
+}
+
+// Allow reports whether a call may be made now. Every allowed call must be followed by Success or Failure.
+func (b *Breaker) Allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open && time.Since(b.openedAt) >= b.cooldown {
+		b.state = HalfOpen
+	}
+	switch b.state {
+	case Open:
+		return false
+	case HalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+	}
+	return true
+}
+
+// Success records a successful call, closing the breaker.
+func (b *Breaker) Success() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures, b.trial, b.state = 0, false, Closed
+}
+
+// Failure records a failed call and reports whether it opened the breaker.
+func (b *Breaker) Failure() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == HalfOpen || b.failures >= b.threshold {
+		opened := b.state != Open
+		b.state, b.openedAt, b.trial = Open, time.Now(), false
+		return opened
+	}
+	return false
+}
+
+// State returns Closed, Open or HalfOpen.
+func (b *Breaker) State() string {
+	if b == nil {
+		return Closed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == Open && time.Since(b.openedAt) >= b.cooldown {
+		return HalfOpen
+	}
+	return b.state
+}
+
+// Do calls fn if the breaker allows it, recording whether it failed. Errors that failure doesn't accept, such as a
+// bad request, don't count against the service. ErrOpen is returned without calling fn while the breaker is open.
+func (b *Breaker) Do(fn func() error, failure func(error) bool) error {
+	if !b.Allow() {
+		return ErrOpen
+	}
+	err := fn()
+	if err != nil && failure(err) {
+		b.Failure()
+	} else {
+		b.Success()
+	}
+	return err
+}
@@ -0,0 +1,110 @@
+package resilience_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/resilience"
+)
+
+var errFlaky = errors.New("flaky")
+
+func TestLimiter(t *testing.T) {
+	l := resilience.NewLimiter(600, 2) // One token every 100ms
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatalf("Got error %v, wanted none", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Fatalf("Got 3 calls in %s, wanted the third to wait for a token", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Got error %v with an empty bucket and a cancelled context, wanted context.Canceled", err)
+	}
+
+	if err := resilience.NewLimiter(0, 0).Wait(context.Background()); err != nil {
+		t.Fatalf("Got error %v without a limit, wanted none", err)
+	}
+}
+
+func TestBackoffRetry(t *testing.T) {
+	b := resilience.Backoff{Retries: 2, Initial: time.Millisecond}
+	retryable := func(err error) bool { return errors.Is(err, errFlaky) }
+
+	calls, retries := 0, 0
+	err := b.Retry(context.Background(), func() error {
+		calls++
+		if calls < 3 {
+			return errFlaky
+		}
+		return nil
+	}, retryable, func(int, error) { retries++ })
+	if err != nil || calls != 3 || retries != 2 {
+		t.Fatalf("Got error %v after %d calls and %d retries, wanted success after 3 calls", err, calls, retries)
+	}
+
+	calls = 0
+	err = b.Retry(context.Background(), func() error { calls++; return errFlaky }, retryable, nil)
+	if !errors.Is(err, errFlaky) || calls != 3 {
+		t.Fatalf("Got error %v after %d calls, wanted the last error after 3 calls", err, calls)
+	}
+
+	calls = 0
+	permanent := errors.New("bad request")
+	if err = b.Retry(context.Background(), func() error { calls++; return permanent }, retryable, nil); err != permanent || calls != 1 {
+		t.Fatalf("Got error %v after %d calls, wanted a permanent error not to be retried", err, calls)
+	}
+
+	b = resilience.Backoff{Initial: time.Second, Max: 4 * time.Second}
+	if d := b.Delay(5); d < 2*time.Second || d > 4*time.Second {
+		t.Fatalf("Got delay %s, wanted it capped at 4s", d)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	b := resilience.NewBreaker(2, 50*time.Millisecond)
+	failure := func(err error) bool { return errors.Is(err, errFlaky) }
+	fail := func() error { return errFlaky }
+
+	_ = b.Do(fail, failure)
+	if b.State() != resilience.Closed {
+		t.Fatalf("Got state %s after one failure, wanted closed", b.State())
+	}
+	_ = b.Do(func() error { return errors.New("bad request") }, failure)
+	_ = b.Do(fail, failure)
+	if b.State() != resilience.Closed {
+		t.Fatalf("Got state %s, wanted errors that aren't failures to reset the count", b.State())
+	}
+	_ = b.Do(fail, failure)
+	if b.State() != resilience.Open {
+		t.Fatalf("Got state %s after two failures in a row, wanted open", b.State())
+	}
+
+	called := false
+	if err := b.Do(func() error { called = true; return nil }, failure); !errors.Is(err, resilience.ErrOpen) || called {
+		t.Fatalf("Got error %v (called %v) while open, wanted ErrOpen without a call", err, called)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatalf("Got no trial call after the cool-down, wanted one")
+	}
+	if b.Allow() {
+		t.Fatalf("Got a second call while the trial is in flight, wanted none")
+	}
+	if !b.Failure() || b.State() != resilience.Open {
+		t.Fatalf("Got state %s after a failed trial, wanted open again", b.State())
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := b.Do(func() error { return nil }, failure); err != nil || b.State() != resilience.Closed {
+		t.Fatalf("Got error %v and state %s after a successful trial, wanted closed", err, b.State())
+	}
+}
@@ -0,0 +1,111 @@
+// Package review keeps the queue of low-confidence categorizations waiting for a person to approve or correct them
+package review
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/internal/jsonfile"
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+// Item is a transaction whose categorization should be checked by a person.
+type Item struct {
+	TransactionID string          `json:"transaction_id"` // SimpleFIN transaction ID, the Firefly external ID
+	AccountID     string          `json:"account_id"`
+	Type          string          `json:"type"`
+	Description   string          `json:"description"`
+	Date          string          `json:"date"`
+	Amount        decimal.Decimal `json:"amount"`
+	Merchant      string          `json:"merchant"`
+	Category      string          `json:"category"`
+	Source        string          `json:"source"`
+	Confidence    float64         `json:"confidence"`
+	Created       time.Time       `json:"created"`
+}
+
+// Queue is the persistent review queue, keyed by SimpleFIN transaction ID.
+type Queue struct {
+	path  string
+	Items map[string]Item `json:"items"`
+	mu    sync.Mutex
+}
+
+// Open loads the review queue saved at path, or an empty one on first run.
+func Open(path string) (*Queue, error) {
+	q := &Queue{
+		path:  path,
+		Items: make(map[string]Item),
+	}
+
+	if err := jsonfile.Load(path, q); err != nil {
+		return q, fmt.Errorf("could not load review queue: %w", err)
+	}
+	if q.Items == nil {
+		q.Items = make(map[string]Item)
+	}
+	return q, nil
+}
+
+// Add puts an item on the queue, replacing any previous item for the same transaction.
+func (q *Queue) Add(item Item) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if old, ok := q.Items[item.TransactionID]; ok {
+		item.Created = old.Created
+	}
+	if item.Created.IsZero() {
+		item.Created = time.Now()
+	}
+	q.Items[item.TransactionID] = item
+	q.save()
+}
+
+// Get returns the queued item for a transaction.
+func (q *Queue) Get(transactionID string) (Item, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item, ok := q.Items[transactionID]
+	return item, ok
+}
+
+// Remove takes a transaction off the queue.
+func (q *Queue) Remove(transactionID string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.Items[transactionID]; !ok {
+		return
+	}
+	delete(q.Items, transactionID)
+	q.save()
+}
+
+// List returns the queued items, oldest first.
+func (q *Queue) List() []Item {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	items := make([]Item, 0, len(q.Items))
+	for _, item := range q.Items {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Created.Equal(items[j].Created) {
+			return items[i].TransactionID < items[j].TransactionID
+		}
+		return items[i].Created.Before(items[j].Created)
+	})
+	return items
+}
+
+// save persists the queue; q.mu must be held.
+func (q *Queue) save() {
+	if err := jsonfile.Save(q.path, q); err != nil {
+		log.Error().Err(err).Msg("Could not save review queue")
+	}
+}
@@ -0,0 +1,39 @@
+package review_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/review"
+)
+
+func TestQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "review.json")
+	q, err := review.Open(path)
+	if err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+
+	first := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	q.Add(review.Item{TransactionID: "TRN-2", Merchant: "Shell", Created: first.Add(time.Hour)})
+	q.Add(review.Item{TransactionID: "TRN-1", Merchant: "Netflix", Created: first})
+	q.Add(review.Item{TransactionID: "TRN-2", Merchant: "Exxon"}) // Keeps its place in line
+
+	q, err = review.Open(path)
+	if err != nil {
+		t.Fatalf("Got error %v reopening, wanted none", err)
+	}
+	items := q.List()
+	if len(items) != 2 || items[0].TransactionID != "TRN-1" || items[1].Merchant != "Exxon" {
+		t.Fatalf("Got %v, wanted TRN-1 then TRN-2 (Exxon)", items)
+	}
+	if !items[1].Created.Equal(first.Add(time.Hour)) {
+		t.Fatalf("Got created %v, wanted %v", items[1].Created, first.Add(time.Hour))
+	}
+
+	q.Remove("TRN-1")
+	if _, ok := q.Get("TRN-1"); ok {
+		t.Fatalf("Got TRN-1 after removing it, wanted it gone")
+	}
+}
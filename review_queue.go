@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/config"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/firefly"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/httperror"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/review"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/simplefin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultReviewThreshold = 0.7
+	defaultReviewTag       = "needs-review"
+)
+
+// Reviewer queues low-confidence categorizations and applies the approvals and corrections made on them.
+type Reviewer struct {
+	firefly     *firefly.Firefly
+	config      *config.MasterConfig
+	queue       *review.Queue
+	categorizer *Categorizer
+}
+
+// NewReviewer creates a Reviewer. Corrections are fed back to the categorizer.
+func NewReviewer(ff *firefly.Firefly, cfg *config.MasterConfig, queue *review.Queue, categorizer *Categorizer) *Reviewer {
+	return &Reviewer{
+		firefly:     ff,
+		config:      cfg,
+		queue:       queue,
+		categorizer: categorizer,
+	}
+}
+
+// Tag returns the Firefly tag given to transactions waiting on review.
+func (r *Reviewer) Tag() string {
+	if r == nil || r.config.Review.Tag == "" {
+		return defaultReviewTag
+	}
+	return r.config.Review.Tag
+}
+
+// NeedsReview reports whether a categorization is not confident enough to go unchecked.
+// Rules and earlier reviews are always trusted.
+func (r *Reviewer) NeedsReview(extracted ExtractedData) bool {
+	if r == nil || !r.config.Review.Enabled || extracted.Skip {
+		return false
+	}
+	if extracted.Source == sourceRule || extracted.Source == sourceReview {
+		return false
+	}
+
+	threshold := r.config.Review.Threshold
+	if threshold == 0 {
+		threshold = defaultReviewThreshold
+	}
+	return extracted.Confidence < threshold
+}
+
+// Track puts a transaction on the review queue if its categorization needs review, or takes it off otherwise.
+func (r *Reviewer) Track(trans simplefin.Transactions, ffTrans firefly.Transaction, extracted ExtractedData) {
+	if r == nil {
+		return
+	}
+	if !r.NeedsReview(extracted) {
+		r.queue.Remove(trans.ID)
+		return
+	}
+
+	categories, err := r.firefly.CachedCategories()
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting cached categories")
+	}
+
+	r.queue.Add(review.Item{
+		TransactionID: trans.ID,
+		AccountID:     assetAccountID(ffTrans),
+		Type:          ffTrans.Type,
+		Description:   trans.Description,
+		Date:          ffTrans.Date,
+		Amount:        trans.Amount,
+		Merchant:      extracted.Company,
+		Category:      categoryName(extracted.Category, categories),
+		Source:        extracted.Source,
+		Confidence:    extracted.Confidence,
+	})
+}
+
+// Resolve approves a queued transaction, or corrects it when merchant or category are given. Firefly is updated
+// and the review tag removed, and the answer is fed back to the categorizer.
+func (r *Reviewer) Resolve(ctx context.Context, transactionID, merchant, category string) error {
+	item, ok := r.queue.Get(transactionID)
+	if !ok {
+		return fmt.Errorf("transaction %s is not on the review queue", transactionID)
+	}
+
+	corrected := (merchant != "" && merchant != item.Merchant) || (category != "" && category != item.Category)
+	if merchant == "" {
+		merchant = item.Merchant
+	}
+	if category == "" {
+		category = item.Category
+	}
+
+	groupID, trans, err := r.findTransaction(ctx, item)
+	if err != nil {
+		return err
+	}
+
+	tags := append([]string{}, withoutTag(trans.Tags, r.Tag())...)
+	fields := map[string]any{"tags": tags}
+	if corrected {
+		fields["category_name"] = category
+		if item.Type == "deposit" {
+			fields["source_name"] = merchant
+		} else {
+			fields["destination_name"] = merchant
+		}
+	}
+	if err = r.firefly.PatchTransaction(ctx, groupID, trans.JournalID, fields); err != nil {
+		return err
+	}
+
+	r.categorizer.Learn(ctx, item.Description, item.Amount, item.AccountID, merchant, category)
+	r.queue.Remove(transactionID)
+
+	log.Info().Str("ID", transactionID).Str("Merchant", merchant).Str("Category", category).Bool("Corrected", corrected).Msg("✅ Reviewed categorization")
+	return nil
+}
+
+// findTransaction finds the Firefly transaction imported for a queued item.
+func (r *Reviewer) findTransaction(ctx context.Context, item review.Item) (string, firefly.Transaction, error) {
+	date, err := time.Parse(time.DateOnly, item.Date)
+	if err != nil {
+		return "", firefly.Transaction{}, err
+	}
+
+	txns, err := r.firefly.ListTransactions(ctx, firefly.TransactionsKey{
+		Start: date.AddDate(0, 0, -3).Format(time.DateOnly),
+		End:   date.AddDate(0, 0, 3).Format(time.DateOnly),
+	})
+	if err != nil {
+		return "", firefly.Transaction{}, err
+	}
+
+	for _, group := range txns {
+		for _, t := range group.Attributes.Transactions {
+			if t.ExternalID == item.TransactionID {
+				return group.ID, t, nil
+			}
+		}
+	}
+	return "", firefly.Transaction{}, fmt.Errorf("transaction %s was not found in Firefly", item.TransactionID)
+}
+
+// HandleReview lists the review queue (GET) or resolves an entry (POST with id, and merchant and/or category to
+// correct it).
+func (r *Reviewer) HandleReview(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(r.queue.List()); err != nil {
+			log.Err(err).Msg("Failed to encode review queue")
+		}
+	case "POST":
+		if err := r.Resolve(req.Context(), req.FormValue("id"), req.FormValue("merchant"), req.FormValue("category")); err != nil {
+			httperror.Send(w, req, http.StatusBadRequest, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		httperror.Send(w, req, http.StatusNotImplemented, fmt.Sprintf("Unsupported method %s", req.Method))
+	}
+}
+
+// runReviewListCommand prints the review queue to out.
+func runReviewListCommand(r *Reviewer, out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "ID\tDATE\tAMOUNT\tDESCRIPTION\tMERCHANT\tCATEGORY\tSOURCE\tCONFIDENCE")
+	for _, item := range r.queue.List() {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%.2f\n", item.TransactionID, item.Date, item.Amount, item.Description, item.Merchant, item.Category, item.Source, item.Confidence)
+	}
+	return w.Flush()
+}
+
+// categoryName returns the name of the category with the given ID, or an empty string.
+func categoryName(id string, categories []firefly.Category) string {
+	for _, cat := range categories {
+		if strconv.Itoa(cat.ID) == id {
+			return cat.Name
+		}
+	}
+	return ""
+}
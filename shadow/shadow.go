@@ -0,0 +1,159 @@
+// Package shadow records how often a secondary categorizer, run next to the one in use, agrees with it
+package shadow
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/internal/jsonfile"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/match"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/merchant"
+	"github.com/rs/zerolog/log"
+)
+
+const defaultSize = 200
+
+// Comparison is the categorization of one transaction by the categorizer in use and by the shadow.
+type Comparison struct {
+	TransactionID  string    `json:"transaction_id"`
+	Description    string    `json:"description"`
+	Merchant       string    `json:"merchant"`
+	Category       string    `json:"category"`
+	ShadowMerchant string    `json:"shadow_merchant"`
+	ShadowCategory string    `json:"shadow_category"`
+	ShadowSource   string    `json:"shadow_source"`
+	Time           time.Time `json:"time"`
+}
+
+// Agrees reports whether both picked the same merchant and category, ignoring case, emoji and punctuation.
+func (c Comparison) Agrees() bool {
+	return match.Normalize(c.Category) == match.Normalize(c.ShadowCategory) && merchant.Key(c.Merchant) == merchant.Key(c.ShadowMerchant)
+}
+
+// Counts is the agreement within one category.
+type Counts struct {
+	Agree    int `json:"agree"`
+	Disagree int `json:"disagree"`
+}
+
+// Rate is the share of comparisons that agreed.
+func (c Counts) Rate() float64 {
+	if c.Agree+c.Disagree == 0 {
+		return 0
+	}
+	return float64(c.Agree) / float64(c.Agree+c.Disagree)
+}
+
+// Log is the persistent record of comparisons: counts per category in use, and the latest disagreements.
+type Log struct {
+	path          string
+	size          int
+	Categories    map[string]Counts `json:"categories"`
+	Disagreements []Comparison      `json:"disagreements"` // Oldest first
+	dirty         bool              // Changed since the last save
+	mu            sync.Mutex
+}
+
+// Open loads the log stored at path, keeping up to size disagreements (0 = 200). Comparisons start over if nothing
+// was saved.
+func Open(path string, size int) (*Log, error) {
+	if size <= 0 {
+		size = defaultSize
+	}
+	l := &Log{
+		path:       path,
+		size:       size,
+		Categories: make(map[string]Counts),
+	}
+
+	if err := jsonfile.Load(path, l); err != nil {
+		return l, fmt.Errorf("could not load shadow log: %w", err)
+	}
+	if l.Categories == nil {
+		l.Categories = make(map[string]Counts)
+	}
+	return l, nil
+}
+
+// Record counts a comparison under the category in use, keeping it if they disagreed. Returns whether they agreed.
+// The comparison is written to disk by the next Flush.
+func (l *Log) Record(c Comparison) bool {
+	if c.Time.IsZero() {
+		c.Time = time.Now()
+	}
+	agrees := c.Agrees()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	counts := l.Categories[c.Category]
+	if agrees {
+		counts.Agree++
+	} else {
+		counts.Disagree++
+		l.Disagreements = append(l.Disagreements, c)
+		if len(l.Disagreements) > l.size {
+			l.Disagreements = l.Disagreements[len(l.Disagreements)-l.size:]
+		}
+	}
+	l.Categories[c.Category] = counts
+	l.dirty = true
+	return agrees
+}
+
+// Flush writes the log to disk if it changed since it was last written.
+func (l *Log) Flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.dirty {
+		l.save()
+	}
+}
+
+// CategoryCounts is the agreement within a category, for reports.
+type CategoryCounts struct {
+	Category string `json:"category"`
+	Counts
+	Rate float64 `json:"rate"`
+}
+
+// Report is the agreement per category and the latest disagreements.
+type Report struct {
+	Total         Counts           `json:"total"`
+	Categories    []CategoryCounts `json:"categories"`    // Least agreement first
+	Disagreements []Comparison     `json:"disagreements"` // Newest first
+}
+
+// Report summarizes the log.
+func (l *Log) Report() Report {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var r Report
+	for category, counts := range l.Categories {
+		r.Total.Agree += counts.Agree
+		r.Total.Disagree += counts.Disagree
+		r.Categories = append(r.Categories, CategoryCounts{Category: category, Counts: counts, Rate: counts.Rate()})
+	}
+	sort.Slice(r.Categories, func(i, j int) bool {
+		if r.Categories[i].Rate == r.Categories[j].Rate {
+			return r.Categories[i].Category < r.Categories[j].Category
+		}
+		return r.Categories[i].Rate < r.Categories[j].Rate
+	})
+	for i := len(l.Disagreements) - 1; i >= 0; i-- {
+		r.Disagreements = append(r.Disagreements, l.Disagreements[i])
+	}
+	return r
+}
+
+// save persists the comparisons; l.mu must be held.
+func (l *Log) save() {
+	if err := jsonfile.Save(l.path, l); err != nil {
+		log.Error().Err(err).Msg("Could not save shadow log")
+		return
+	}
+	l.dirty = false
+}
@@ -0,0 +1,39 @@
+package shadow_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/shadow"
+)
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shadow.json")
+	l, err := shadow.Open(path, 2)
+	if err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+
+	if !l.Record(shadow.Comparison{TransactionID: "1", Merchant: "Amazon", Category: "Shopping", ShadowMerchant: "Amazon.com", ShadowCategory: "🛍️ Shopping"}) {
+		t.Fatalf("Got a disagreement for the same merchant and category, wanted agreement")
+	}
+	for _, id := range []string{"2", "3", "4"} {
+		l.Record(shadow.Comparison{TransactionID: id, Merchant: "Shell", Category: "Gas & Fuel", ShadowMerchant: "Shell", ShadowCategory: "Travel"})
+	}
+
+	l.Flush()
+	l, err = shadow.Open(path, 2)
+	if err != nil {
+		t.Fatalf("Got error %v reopening, wanted none", err)
+	}
+	r := l.Report()
+	if r.Total.Agree != 1 || r.Total.Disagree != 3 {
+		t.Fatalf("Got %+v, wanted 1 agreement and 3 disagreements", r.Total)
+	}
+	if r.Categories[0].Category != "Gas & Fuel" || r.Categories[0].Rate != 0 {
+		t.Fatalf("Got %+v first, wanted Gas & Fuel with no agreement", r.Categories[0])
+	}
+	if len(r.Disagreements) != 2 || r.Disagreements[0].TransactionID != "4" {
+		t.Fatalf("Got disagreements %+v, wanted 4 then 3", r.Disagreements)
+	}
+}
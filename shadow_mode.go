@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"text/tabwriter"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/config"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/httperror"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/logging"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/prom"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/shadow"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/simplefin"
+	"github.com/rs/zerolog/log"
+)
+
+// Shadow runs a secondary categorizer chain on every transaction the sync categorizes, without using its result,
+// and records whether it agreed with the categorizer in use.
+type Shadow struct {
+	chain       string
+	categorizer *Categorizer
+	log         *shadow.Log
+}
+
+// NewShadow creates the shadow of categorizer, or returns nil if shadow mode is disabled or its chain isn't configured.
+func NewShadow(categorizer *Categorizer, cfg *config.MasterConfig, l *shadow.Log) *Shadow {
+	name := cfg.Shadow.Chain
+	if name == "" {
+		return nil
+	}
+	chain, ok := cfg.Chains[name]
+	if !ok {
+		log.Error().Str("Chain", name).Msg("Shadow chain is not configured under chains, shadow mode is disabled")
+		return nil
+	}
+	return &Shadow{chain: name, categorizer: categorizer.WithChain(chain), log: l}
+}
+
+// Prefetch categorizes transactions in batches for the shadow chain, see Categorizer.Prefetch.
+func (s *Shadow) Prefetch(ctx context.Context, items []batchItem) {
+	if s == nil {
+		return
+	}
+	s.categorizer.Prefetch(ctx, items)
+}
+
+// Compare categorizes a transaction with the shadow chain and records whether it agrees with used, what the
+// categorizer in use decided. accountType is the Firefly account type of the merchant.
+func (s *Shadow) Compare(ctx context.Context, trans simplefin.Transactions, accountID, accountType string, used ExtractedData) {
+	if s == nil || used.Skip {
+		return
+	}
+
+	shadowed := s.categorizer.CanonicalMerchant(ctx, s.categorizer.ExtractCompanyAndCategory(ctx, trans, accountID), accountType)
+	categories, err := s.categorizer.firefly.CachedCategories()
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Str("event", "shadow.failed").Msg("Error getting cached categories")
+		return
+	}
+
+	c := shadow.Comparison{
+		TransactionID:  trans.ID,
+		Description:    trans.Description,
+		Merchant:       used.Company,
+		Category:       categoryName(used.Category, categories),
+		ShadowMerchant: shadowed.Company,
+		ShadowCategory: categoryName(shadowed.Category, categories),
+		ShadowSource:   shadowed.Source,
+	}
+	if s.log.Record(c) {
+		prom.Shadow.Add(c.Category, "agree")
+		return
+	}
+	prom.Shadow.Add(c.Category, "disagree")
+	logging.Ctx(ctx).Info().
+		Str("event", "shadow.disagreed").
+		Str("Chain", s.chain).
+		Str("Description", c.Description).
+		Msgf("👥 [Shadow] Would have picked %s / %s instead of %s / %s", c.ShadowMerchant, c.ShadowCategory, c.Merchant, c.Category)
+}
+
+// HandleShadow serves the shadow report as JSON.
+func (s *Shadow) HandleShadow(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		httperror.Send(w, req, http.StatusNotImplemented, fmt.Sprintf("Unsupported method %s", req.Method))
+		return
+	}
+	if s == nil {
+		httperror.Send(w, req, http.StatusNotFound, "Shadow mode is disabled")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.log.Report()); err != nil {
+		log.Err(err).Msg("Failed to encode shadow report")
+	}
+}
+
+// runShadowReportCommand prints the agreement per category and the latest disagreements.
+func runShadowReportCommand(l *shadow.Log, chain string, out io.Writer) error {
+	r := l.Report()
+	_, _ = fmt.Fprintf(out, "Shadow chain %q agreed on %d of %d transactions (%.1f%%)\n\n", chain, r.Total.Agree, r.Total.Agree+r.Total.Disagree, 100*r.Total.Rate())
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "CATEGORY\tAGREE\tDISAGREE\tRATE")
+	for _, c := range r.Categories {
+		_, _ = fmt.Fprintf(w, "%s\t%d\t%d\t%.2f\n", c.Category, c.Agree, c.Disagree, c.Rate)
+	}
+	_, _ = fmt.Fprintln(w, "\nID\tDESCRIPTION\tMERCHANT\tCATEGORY\tSHADOW MERCHANT\tSHADOW CATEGORY")
+	for _, c := range r.Disagreements {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", c.TransactionID, c.Description, c.Merchant, c.Category, c.ShadowMerchant, c.ShadowCategory)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,214 @@
+// Package similar categorizes a transaction like the labelled transactions whose description embeddings are
+// nearest to it
+package similar
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/internal/jsonfile"
+)
+
+// Entry is a labelled transaction description and its embedding.
+type Entry struct {
+	Description string    `json:"description"`
+	Direction   string    `json:"direction"` // Firefly transaction type, withdrawal or deposit
+	Merchant    string    `json:"merchant"`
+	Category    string    `json:"category"`
+	Vector      []float32 `json:"vector"` // Normalized to unit length
+}
+
+// Neighbour is an entry near a description, with its cosine similarity between -1 and 1.
+type Neighbour struct {
+	Entry
+	Similarity float64
+}
+
+// Prediction is the merchant and category most of the nearest neighbours agree on.
+type Prediction struct {
+	Merchant   string
+	Category   string
+	Similarity float64 // Of the nearest neighbour with this merchant and category
+	Confidence float64 // Share of the neighbours' similarity behind it, between 0 and 1
+	Neighbours int     // How many neighbours were above the threshold
+}
+
+// Index is a persistent set of labelled embeddings, searched by brute force. Embeddings of different models can't
+// be compared, so the index is emptied when the model changes. Withdrawals and deposits are only compared with
+// entries of the same direction.
+type Index struct {
+	path    string
+	Model   string    `json:"model"`
+	Built   time.Time `json:"built"`
+	Entries []Entry   `json:"entries"`
+	mu      sync.RWMutex
+}
+
+// Open loads the index stored at path for model. A missing file, or one built with another model, starts an empty
+// index.
+func Open(path, model string) (*Index, error) {
+	idx := &Index{path: path, Model: model}
+
+	if err := jsonfile.Load(path, idx); err != nil {
+		return idx, fmt.Errorf("could not load embedding index: %w", err)
+	}
+	if idx.Model != model {
+		idx.Model, idx.Built, idx.Entries = model, time.Time{}, nil
+	}
+	return idx, nil
+}
+
+// Len returns the number of entries.
+func (idx *Index) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.Entries)
+}
+
+// BuiltAt returns when Replace was last called, zero if never.
+func (idx *Index) BuiltAt() time.Time {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.Built
+}
+
+// Vectors returns the embeddings already in the index by description, so a rebuild only embeds what is new.
+func (idx *Index) Vectors() map[string][]float32 {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	vectors := make(map[string][]float32, len(idx.Entries))
+	for _, e := range idx.Entries {
+		vectors[e.Description] = e.Vector
+	}
+	return vectors
+}
+
+// Replace swaps every entry for entries and saves the index.
+func (idx *Index) Replace(entries []Entry) error {
+	for i := range entries {
+		entries[i].Vector = Normalize(entries[i].Vector)
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.Entries = entries
+	idx.Built = time.Now()
+	return idx.save()
+}
+
+// Add adds an entry, replacing the label of an entry with the same description and direction, and saves the index.
+func (idx *Index) Add(e Entry) error {
+	e.Vector = Normalize(e.Vector)
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for i := range idx.Entries {
+		if idx.Entries[i].Description == e.Description && idx.Entries[i].Direction == e.Direction {
+			idx.Entries[i] = e
+			return idx.save()
+		}
+	}
+	idx.Entries = append(idx.Entries, e)
+	return idx.save()
+}
+
+// Nearest returns up to k entries of direction most similar to vector, most similar first.
+func (idx *Index) Nearest(vector []float32, direction string, k int) []Neighbour {
+	vector = Normalize(vector)
+
+	idx.mu.RLock()
+	neighbours := make([]Neighbour, 0, len(idx.Entries))
+	for _, e := range idx.Entries {
+		if len(e.Vector) != len(vector) || e.Direction != direction {
+			continue
+		}
+		neighbours = append(neighbours, Neighbour{Entry: e, Similarity: dot(vector, e.Vector)})
+	}
+	idx.mu.RUnlock()
+
+	sort.Slice(neighbours, func(i, j int) bool { return neighbours[i].Similarity > neighbours[j].Similarity })
+	if len(neighbours) > k {
+		neighbours = neighbours[:k]
+	}
+	return neighbours
+}
+
+// Predict returns the merchant and category with the most similarity among the k nearest entries of direction at
+// least threshold similar to vector. It returns false if none are.
+func (idx *Index) Predict(vector []float32, direction string, k int, threshold float64) (Prediction, bool) {
+	type vote struct {
+		merchant, category string
+		weight, best       float64
+	}
+	var votes []*vote
+	var total float64
+	var p Prediction
+
+	for _, n := range idx.Nearest(vector, direction, k) {
+		if n.Similarity < threshold {
+			break
+		}
+		p.Neighbours++
+		total += n.Similarity
+
+		var v *vote
+		for _, existing := range votes {
+			if existing.merchant == n.Merchant && existing.category == n.Category {
+				v = existing
+			}
+		}
+		if v == nil {
+			v = &vote{merchant: n.Merchant, category: n.Category, best: n.Similarity}
+			votes = append(votes, v)
+		}
+		v.weight += n.Similarity
+	}
+	if len(votes) == 0 {
+		return p, false
+	}
+
+	top := votes[0]
+	for _, v := range votes[1:] {
+		if v.weight > top.weight {
+			top = v
+		}
+	}
+	p.Merchant, p.Category, p.Similarity, p.Confidence = top.merchant, top.category, top.best, top.weight/total
+	return p, true
+}
+
+// Normalize scales a vector to unit length, so cosine similarity is a dot product.
+func Normalize(vector []float32) []float32 {
+	var sum float64
+	for _, x := range vector {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return vector
+	}
+	norm := math.Sqrt(sum)
+	if math.Abs(norm-1) < 1e-6 {
+		return vector
+	}
+	out := make([]float32, len(vector))
+	for i, x := range vector {
+		out[i] = float32(float64(x) / norm)
+	}
+	return out
+}
+
+func dot(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
+
+// save writes the index to disk. The caller holds mu.
+func (idx *Index) save() error {
+	return jsonfile.SaveCompact(idx.path, idx)
+}
@@ -0,0 +1,58 @@
+package similar_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/similar"
+)
+
+func TestIndexPredict(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "embeddings.json")
+	idx, err := similar.Open(path, "text-embedding-3-small")
+	if err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+	err = idx.Replace([]similar.Entry{
+		{Description: "SHELL OIL 1", Direction: "withdrawal", Merchant: "Shell", Category: "Gas & Fuel", Vector: []float32{1, 0.1, 0}},
+		{Description: "SHELL OIL 2", Direction: "withdrawal", Merchant: "Shell", Category: "Gas & Fuel", Vector: []float32{2, 0.1, 0}},
+		{Description: "SHELL SNACKS", Direction: "withdrawal", Merchant: "Shell", Category: "Groceries", Vector: []float32{1, 0.3, 0}},
+		{Description: "NETFLIX.COM", Direction: "withdrawal", Merchant: "Netflix", Category: "Entertainment", Vector: []float32{0, 0, 1}},
+		{Description: "SHELL REFUND", Direction: "deposit", Merchant: "Shell", Category: "Refunds", Vector: []float32{1, 0.15, 0}},
+	})
+	if err != nil {
+		t.Fatalf("Got error %v saving, wanted none", err)
+	}
+
+	idx, err = similar.Open(path, "text-embedding-3-small")
+	if err != nil || idx.Len() != 5 {
+		t.Fatalf("Got %d entries and error %v reopening, wanted 5", idx.Len(), err)
+	}
+	p, ok := idx.Predict([]float32{1, 0.15, 0}, "withdrawal", 3, 0.9)
+	if !ok || p.Merchant != "Shell" || p.Category != "Gas & Fuel" || p.Neighbours != 3 {
+		t.Fatalf("Got %+v (%v), wanted Shell / Gas & Fuel from 3 neighbours", p, ok)
+	}
+	if p.Confidence < 0.6 || p.Confidence > 0.7 {
+		t.Fatalf("Got confidence %.2f, wanted about 2 of 3 neighbours", p.Confidence)
+	}
+	if p, ok = idx.Predict([]float32{0.5, 0.5, 0.5}, "withdrawal", 3, 0.9); ok {
+		t.Fatalf("Got %+v for a description unlike any other, wanted nothing", p)
+	}
+	if p, ok = idx.Predict([]float32{1, 0.15, 0}, "deposit", 3, 0.9); !ok || p.Category != "Refunds" || p.Neighbours != 1 {
+		t.Fatalf("Got %+v (%v), wanted a deposit compared with deposits only", p, ok)
+	}
+	if p, ok = idx.Predict([]float32{0, 0, 1}, "deposit", 3, 0.9); ok {
+		t.Fatalf("Got %+v, wanted no deposit like a withdrawal-only description", p)
+	}
+
+	if err = idx.Add(similar.Entry{Description: "NETFLIX.COM", Direction: "withdrawal", Merchant: "Netflix", Category: "Streaming", Vector: []float32{0, 0, 3}}); err != nil {
+		t.Fatalf("Got error %v adding, wanted none", err)
+	}
+	if p, _ = idx.Predict([]float32{0, 0, 1}, "withdrawal", 3, 0.9); p.Category != "Streaming" || idx.Len() != 5 {
+		t.Fatalf("Got %+v and %d entries, wanted the entry relabelled", p, idx.Len())
+	}
+
+	if idx, _ = similar.Open(path, "nomic-embed-text"); idx.Len() != 0 {
+		t.Fatalf("Got %d entries for another model, wanted an empty index", idx.Len())
+	}
+}
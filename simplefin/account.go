@@ -1,10 +1,13 @@
 package simplefin
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"time"
@@ -14,6 +17,7 @@ import (
 )
 
 type Simplefin struct {
+	client    *http.Client
 	url       string
 	filter    Filter
 	CacheOnly bool
@@ -31,17 +35,29 @@ type AccountsResponse struct {
 }
 
 type Transactions struct {
-	ID           string          `json:"id"`
-	Posted       int64           `json:"posted"`
-	TransactedAt int64           `json:"transacted_at,omitempty"` // "2018-09-17T12:46:47+01:00"
-	Amount       decimal.Decimal `json:"amount"`
-	Description  string          `json:"description"`
-	Pending      bool            `json:"pending"`
-	Extra        []string        `json:"extra"`
+	ID             string          `json:"id"`
+	Posted         int64           `json:"posted"`
+	TransactedAt   int64           `json:"transacted_at,omitempty"` // "2018-09-17T12:46:47+01:00"
+	Amount         decimal.Decimal `json:"amount"`
+	Description    string          `json:"description"`
+	RawDescription string          `json:"-"` // Description as sent by the bank, before normalization
+	Pending        bool            `json:"pending"`
+	Extra          []string        `json:"extra"`
 }
+
+// Org is the institution an account is held at.
+type Org struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Domain  string `json:"domain"`
+	SFINURL string `json:"sfin-url"`
+	URL     string `json:"url"`
+}
+
 type Accounts struct {
 	ID               string          `json:"id"`
 	Name             string          `json:"name"`
+	Org              Org             `json:"org"`
 	Currency         string          `json:"currency"`
 	Balance          decimal.Decimal `json:"balance"`
 	AvailableBalance decimal.Decimal `json:"available-balance"`
@@ -55,14 +71,15 @@ type AccountJson struct {
 	ParsedTime time.Time
 }
 
-// New initializes and returns a new Simplefin instance with the provided accessUrl and cacheOnly settings.
-func New(accessUrl string, cacheOnly bool) *Simplefin {
+// New initializes and returns a new Simplefin instance with the provided HTTP client, accessUrl and cacheOnly settings.
+func New(client *http.Client, accessUrl string, cacheOnly bool) *Simplefin {
 	if cacheOnly {
 		log.Debug().Msg("Running in Cache Only Mode")
 	}
 
 	return &Simplefin{
-		url: accessUrl,
+		client: client,
+		url:    accessUrl,
 		filter: Filter{
 			Pending: false,
 		},
@@ -90,8 +107,33 @@ func (f *Simplefin) ToQuery() string {
 	return appendQuery
 }
 
+// Info checks that the SimpleFIN server answers its info endpoint, which doesn't need credentials and doesn't count
+// against the daily request quota. In cache only mode it always succeeds.
+func (f *Simplefin) Info() error {
+	if f.CacheOnly {
+		return nil
+	}
+
+	u, err := url.Parse(f.url)
+	if err != nil {
+		return errors.New("invalid access URL") // The parse error would repeat the credentials
+	}
+	u.User = nil
+	res, err := f.client.Get(u.String() + "/info")
+	if err != nil {
+		// Errors include the URL, which no longer has the credentials in it
+		return err
+	}
+	_ = res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("invalid HTTP Status Code %d", res.StatusCode)
+	}
+	return nil
+}
+
 // Accounts fetches account information from the Simplefin API or cache, returning an AccountsResponse or an error.
-func (f *Simplefin) Accounts() (AccountsResponse, error) {
+func (f *Simplefin) Accounts(ctx context.Context) (AccountsResponse, error) {
 	var accountsResponse AccountsResponse
 	var accountsResposneData AccountJson
 
@@ -129,7 +171,8 @@ func (f *Simplefin) Accounts() (AccountsResponse, error) {
 
 	postURL := f.url + "/accounts" + f.ToQuery()
 
-	res, err := http.Get(postURL)
+	req, _ := http.NewRequestWithContext(ctx, "GET", postURL, nil)
+	res, err := f.client.Do(req)
 	if err != nil {
 		return AccountsResponse{}, err
 	}
@@ -0,0 +1,33 @@
+package main
+
+import "time"
+
+// stateFlushInterval is how often state kept in memory between writes is saved to the data directory.
+const stateFlushInterval = time.Minute
+
+// flusher is state that is changed in memory and written to disk on Flush: the categorization cache, AI usage and
+// shadow comparisons.
+type flusher interface {
+	Flush()
+}
+
+// flushState writes every store to disk.
+func flushState(stores ...flusher) {
+	for _, s := range stores {
+		s.Flush()
+	}
+}
+
+// scheduleFlush writes the stores to disk every interval until quit is closed.
+func scheduleFlush(interval time.Duration, quit <-chan struct{}, stores ...flusher) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			flushState(stores...)
+		case <-quit:
+			return
+		}
+	}
+}
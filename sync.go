@@ -1,24 +1,32 @@
 package main
 
 import (
+	"context"
 	"time"
 
-	"github.com/helpcomp/firefly-iii-simplefin-importer/config"
 	"github.com/helpcomp/firefly-iii-simplefin-importer/duration"
 	"github.com/helpcomp/firefly-iii-simplefin-importer/firefly"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/institution"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/logging"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/prom"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/reconcile"
 	"github.com/helpcomp/firefly-iii-simplefin-importer/simplefin"
-	"github.com/rs/zerolog/log"
-	"github.com/sashabaranov/go-openai"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/tracing"
 	"github.com/shopspring/decimal"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // startUpdate initializes the process to update accounts and reconcile balances using Simplefin API and Firefly API.
-func startUpdate(sf *simplefin.Simplefin, ff *firefly.Firefly, c *config.MasterConfig, oai *openai.Client) []simplefin.Accounts {
-	log.Debug().Msg("Starting Simplefin Update")
+func startUpdate(ctx context.Context, sf *simplefin.Simplefin, syncApp *SyncApp) []simplefin.Accounts {
+	ff, c := syncApp.firefly, syncApp.config
+	start := time.Now()
+	ctx, span := tracing.Start(logging.With(ctx, "run_id", logging.NewRunID()), "sync")
+	defer span.End()
+	logging.Ctx(ctx).Info().Str("event", "sync.started").Msg("Starting Simplefin Update")
 	// Duration Configuration - How far back to check for transactions
 	StartTimeDur, err := duration.ParseDuration(cli.SimplefinLoopbackDuration)
 	if err != nil {
-		log.Fatal().Err(err)
+		logging.Ctx(ctx).Fatal().Err(err)
 	}
 	pendingTransfers := make(map[string]decimal.Decimal) // Reset Pending Transactions
 
@@ -27,44 +35,66 @@ func startUpdate(sf *simplefin.Simplefin, ff *firefly.Firefly, c *config.MasterC
 		Pending:   true,
 	})
 
-	log.Debug().Msgf("Retreiving Simplefin Account Data")
+	logging.Ctx(ctx).Debug().Msgf("Retreiving Simplefin Account Data")
 	// Get accounts from Simplefin
-	simpleFinAcctResp, err := sf.Accounts()
+	simpleFinAcctResp, err := sf.Accounts(ctx)
 	if err != nil {
-		log.Error().Err(err).Msg("Could not initialize SimpleFin API.")
+		logging.Ctx(ctx).Error().Err(err).Str("event", "sync.failed").Msg("Could not initialize SimpleFin API.")
+		notifySyncFailed(syncApp.notifier, err)
+		span.Fail(err)
+		prom.Sync.Finish(start, false)
 		return nil
 	}
 
 	// There are errors in the Simplefin accounts (Actions needed in Simplefin Bridge to restore proper communication)
 	for _, acctErr := range simpleFinAcctResp.Errors {
-		log.Error().Msgf("%s", acctErr)
+		logging.Ctx(ctx).Error().Str("event", "bank.error").Msgf("%s", acctErr)
 	}
+	// Track the health of each bank connection
+	updateInstitutions(ctx, syncApp.institutions, simpleFinAcctResp)
+	notifyBankErrors(syncApp.notifier, syncApp.institutions.Statuses(), syncApp.institutions.UnassignedErrors())
+
+	// Clean up bank descriptions before they are matched, categorized, or imported
+	syncApp.NormalizeDescriptions(simpleFinAcctResp.Accounts)
+
+	span.SetAttributes(attribute.Int("simplefin.accounts", len(simpleFinAcctResp.Accounts)), attribute.Int("simplefin.errors", len(simpleFinAcctResp.Errors)))
 
 	// Remove non-existent transactions before looping through new / updated transactions
 	// This also prevents balance mismatch
-	RemoveNonExistentTransactions(ff, simpleFinAcctResp)
+	phaseCtx, phase := tracing.Start(ctx, "remove_nonexistent")
+	RemoveNonExistentTransactions(phaseCtx, ff, simpleFinAcctResp, syncApp.duplicates)
+	phase.End()
 
-	// Create SyncApp once for all accounts (avoids rebuilding transferBypasses map for each account)
-	syncApp := NewSyncApp(ff, c, oai)
+	// Categorize every new transaction up front, in batches
+	phaseCtx, phase = tracing.Start(ctx, "prefetch_categories")
+	syncApp.PrefetchCategories(phaseCtx, simpleFinAcctResp.Accounts)
+	phase.End()
 
 	// Loop through Simplefin Accounts
+	var acctSpan *tracing.Span
 	for _, acct := range simpleFinAcctResp.Accounts {
+		acctSpan.End()
+		acctSpan = nil
 		if c.Accounts[acct.ID] == "0" {
 			continue
 		}
+		var acctCtx context.Context
+		acctCtx, acctSpan = tracing.Start(logging.With(ctx, "account_id", acct.ID), "account", attribute.String("simplefin.account.id", acct.ID), attribute.String("simplefin.account.name", acct.Name), attribute.String("firefly.account.id", c.Accounts[acct.ID]))
 		currentAccount, err := GetAccount(ff, c.Accounts[acct.ID])
 		if err != nil {
 
 			if err.Error() == "unable to find an account" {
-				log.Warn().Err(err).Str("AccountID", acct.ID).Str("AccountName", acct.Name).Msgf("Unable to get account from FireFly. If this is expected, please add the AccountID to the config.yaml file as %s: 0", acct.ID)
+				logging.Ctx(acctCtx).Warn().Err(err).Str("event", "account.missing").Str("AccountID", acct.ID).Str("AccountName", acct.Name).Msgf("Unable to get account from FireFly. If this is expected, please add the AccountID to the config.yaml file as %s: 0", acct.ID)
 				continue
 			}
 
-			log.Error().Err(err).Str("AccountID", acct.ID).Str("AccountName", acct.Name).Msgf("Error getting account from FireFly")
+			logging.Ctx(acctCtx).Error().Err(err).Str("event", "account.failed").Str("AccountID", acct.ID).Str("AccountName", acct.Name).Msgf("Error getting account from FireFly")
+			acctSpan.Fail(err)
 			continue
 		}
 
-		log.Info().
+		logging.Ctx(acctCtx).Info().
+			Str("event", "account.found").
 			Str("Type", "Account").
 			Str("Name", acct.Name).
 			Str("ID", acct.ID).
@@ -73,47 +103,15 @@ func startUpdate(sf *simplefin.Simplefin, ff *firefly.Firefly, c *config.MasterC
 
 		// Transactions //
 		/////////////////
-		accountHasPending, pendingBalance := CheckTransactions(syncApp, acct, pendingTransfers)
+		_, pendingBalance := CheckTransactions(acctCtx, syncApp, acct, pendingTransfers)
 
 		// Account Reconciliation //
 		///////////////////////////
 		_, ok := c.NonAssetAccounts[c.Accounts[acct.ID]]
-		// Reconcile Account if there are no pending transactions, the balance doesn't match, and EnableReconciliation is true
-		if !accountHasPending && !currentAccount.Attributes.CurrentBalance.Equal(acct.Balance) && (cli.FireflyEnableReconciliation || ok) {
-			balanceDifference := acct.Balance.Sub(currentAccount.Attributes.CurrentBalance)
-			reconcile := firefly.Transaction{
-				Date:          time.Now().Format(time.DateOnly),
-				Amount:        balanceDifference.Abs(),
-				Description:   "Account Reconciliation",
-				DestinationID: c.Accounts[acct.ID],
-				SourceName:    currentAccount.Attributes.Name + " reconciliation (USD)",
-				Type:          "reconciliation",
-			}
-
-			if c.NonAssetAccounts[c.Accounts[acct.ID]] != "reconciliation" {
-				reconcile.SourceName = defaultAccountName
-				reconcile.Type = "deposit"
-			}
-
-			if balanceDifference.LessThan(decimal.NewFromInt(0)) {
-				reconcile.SourceID = c.Accounts[acct.ID]
-				reconcile.SourceName = ""
-				reconcile.DestinationID = ""
-				reconcile.DestinationName = currentAccount.Attributes.Name + " reconciliation (USD)"
-
-				if c.NonAssetAccounts[c.Accounts[acct.ID]] != "reconciliation" {
-					reconcile.DestinationName = defaultAccountName
-					reconcile.Type = "withdrawal"
-				}
-			}
-
-			err = ff.CreateTransaction(reconcile)
-
-			if err != nil {
-				log.Error().Err(err).Msgf("%v : %v", reconcile, err.Error())
-				continue
-			}
-			log.Info().Str("Type", "Reconciliation").Str("Name", currentAccount.Attributes.Name).Str("ID", currentAccount.ID).Float64("Balance", acct.Balance.InexactFloat64()).Msgf("Reconciled Account %s", acct.Name)
+		// Compare against the balance at SimpleFIN's BalanceDate, adjusting only when EnableReconciliation is true or the account requires it
+		entry := ReconcileAccount(acctCtx, ff, c, syncApp.history, acct, currentAccount, cli.FireflyEnableReconciliation || ok)
+		acctSpan.SetAttributes(attribute.String("reconcile.status", entry.Status))
+		if entry.Status == reconcile.StatusMatched || entry.Status == reconcile.StatusAdjusted {
 			continue
 		}
 
@@ -126,9 +124,54 @@ func startUpdate(sf *simplefin.Simplefin, ff *firefly.Firefly, c *config.MasterC
 			}
 
 			if !acct.Balance.Equal(pendBal) {
-				log.Error().Str("Type", "BalanceMismatch").Str("Name", currentAccount.Attributes.Name).Str("ID", currentAccount.ID).Float64("Expected", ExpectedBalance.InexactFloat64()).Float64("Actual", acct.Balance.InexactFloat64()).Msgf("Balance Mismatch for %s!", currentAccount.Attributes.Name)
+				acctSpan.SetAttributes(attribute.Bool("balance.mismatch", true))
+				logging.Ctx(acctCtx).Error().Str("event", "balance.mismatch").Str("Type", "BalanceMismatch").Str("Name", currentAccount.Attributes.Name).Str("ID", currentAccount.ID).Float64("Expected", ExpectedBalance.InexactFloat64()).Float64("Actual", acct.Balance.InexactFloat64()).Msgf("Balance Mismatch for %s!", currentAccount.Attributes.Name)
+
+				// Find out why
+				report, err := syncApp.investigator.InvestigateAccount(acctCtx, acct)
+				if err != nil {
+					logging.Ctx(acctCtx).Error().Err(err).Str("Name", currentAccount.Attributes.Name).Msg("Could not investigate balance mismatch")
+					notifyBalanceMismatch(syncApp.notifier, currentAccount.Attributes.Name, currentAccount.ID, ExpectedBalance, acct.Balance, nil)
+					continue
+				}
+				LogReport(acctCtx, report)
+				notifyBalanceMismatch(syncApp.notifier, currentAccount.Attributes.Name, currentAccount.ID, ExpectedBalance, acct.Balance, &report)
 			}
 		}
 	}
+	acctSpan.End()
+
+	syncApp.investigator.SetAccounts(simpleFinAcctResp.Accounts)
+	prom.Sync.Finish(start, true)
+	logging.Ctx(ctx).Info().Str("event", "sync.finished").Dur("Duration", time.Since(start)).Msg("Finished Simplefin Update")
 	return simpleFinAcctResp.Accounts
 }
+
+// updateInstitutions records the health of each institution in a SimpleFIN response, logging the ones whose state
+// changed.
+func updateInstitutions(ctx context.Context, tracker *institution.Tracker, resp simplefin.AccountsResponse) {
+	for _, change := range tracker.Update(resp, time.Now()) {
+		event := logging.Ctx(ctx).Info()
+		if change.State != institution.Healthy {
+			event = logging.Ctx(ctx).Warn()
+		}
+		event.
+			Str("event", "institution.changed").
+			Str("Type", "Institution").
+			Str("Name", change.Name).
+			Str("From", change.From).
+			Str("State", change.State).
+			Strs("Errors", change.Errors).
+			Msgf("🏛️ %s is %s", change.Name, change.State)
+	}
+	prom.Institutions.Set(tracker.Statuses())
+}
+
+// countSync counts a transaction created, updated or deleted in a Firefly account, for the metrics.
+func countSync(ff *firefly.Firefly, accountID, action string) {
+	name := ""
+	if accounts, err := ff.CachedAccounts(); err == nil {
+		name = accounts.AccountsByID[accountID].Attributes.Name
+	}
+	prom.Sync.Count(accountID, name, action)
+}
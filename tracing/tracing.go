@@ -0,0 +1,180 @@
+// Package tracing records OpenTelemetry traces of sync runs. Spans are carried by the context passed down the sync
+// to the Firefly and SimpleFIN clients, so HTTP calls and log lines are attributed to the span they were made under.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentation = "github.com/helpcomp/firefly-iii-simplefin-importer"
+
+// Exporters
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"   // OTLP over HTTP
+	ExporterStdout = "stdout" // Pretty-printed JSON on stdout
+	ExporterFile   = "file"   // JSON lines in a file
+)
+
+// Options configure the trace exporter.
+type Options struct {
+	Exporter    string            // none, otlp, stdout or file
+	Endpoint    string            // otlp: URL or host:port (default OTEL_EXPORTER_OTLP_ENDPOINT, then localhost:4318)
+	Insecure    bool              // otlp: plain HTTP when Endpoint is host:port
+	Headers     map[string]string // otlp
+	File        string            // file: path to append to
+	SampleRatio float64           // Fraction of syncs traced (0 = all)
+	Service     string
+	Version     string
+}
+
+// Setup installs the global tracer provider, returning a function that flushes and stops it. With no exporter,
+// spans are not recorded.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	noop := func(context.Context) error { return nil }
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch opts.Exporter {
+	case "", ExporterNone:
+		return noop, nil
+	case ExporterOTLP:
+		var options []otlptracehttp.Option
+		switch {
+		case strings.Contains(opts.Endpoint, "://"):
+			options = append(options, otlptracehttp.WithEndpointURL(opts.Endpoint))
+		case opts.Endpoint != "":
+			options = append(options, otlptracehttp.WithEndpoint(opts.Endpoint))
+		}
+		if opts.Insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		if len(opts.Headers) > 0 {
+			options = append(options, otlptracehttp.WithHeaders(opts.Headers))
+		}
+		exporter, err = otlptracehttp.New(ctx, options...)
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case ExporterFile:
+		if opts.File == "" {
+			return noop, fmt.Errorf("the file exporter needs a file")
+		}
+		var f *os.File
+		if f, err = os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644); err != nil {
+			return noop, err
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+		if err == nil {
+			exporter = closingExporter{SpanExporter: exporter, closer: f}
+		}
+	default:
+		return noop, fmt.Errorf("unknown trace exporter %q", opts.Exporter)
+	}
+	if err != nil {
+		return noop, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", opts.Service),
+		attribute.String("service.version", opts.Version),
+	))
+	if err != nil {
+		return noop, err
+	}
+
+	sampler := sdktrace.AlwaysSample()
+	if opts.SampleRatio > 0 && opts.SampleRatio < 1 {
+		sampler = sdktrace.TraceIDRatioBased(opts.SampleRatio)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return provider.Shutdown, nil
+}
+
+// closingExporter closes the trace file when the exporter shuts down.
+type closingExporter struct {
+	sdktrace.SpanExporter
+	closer io.Closer
+}
+
+func (e closingExporter) Shutdown(ctx context.Context) error {
+	err := e.SpanExporter.Shutdown(ctx)
+	if cerr := e.closer.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Span is a span of the sync.
+type Span struct {
+	trace.Span
+}
+
+// End ends the span. Ending a nil span does nothing, so a loop can end the previous iteration's span before
+// starting its own.
+func (s *Span) End(options ...trace.SpanEndOption) {
+	if s == nil {
+		return
+	}
+	s.Span.End(options...)
+}
+
+// Fail marks the span as failed with err.
+func (s *Span) Fail(err error) {
+	if err == nil {
+		return
+	}
+	s.RecordError(err)
+	s.SetStatus(codes.Error, err.Error())
+}
+
+// Start starts a span under the span of ctx, or a new trace if there is none, returning a context carrying it.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, *Span) {
+	ctx, span := otel.Tracer(instrumentation).Start(ctx, name, trace.WithAttributes(attrs...))
+	return ctx, &Span{Span: span}
+}
+
+// StartRequest starts a client span for an outbound HTTP request, under the span of the request's context.
+func StartRequest(req *http.Request, service, endpoint string) trace.Span {
+	_, span := otel.Tracer(instrumentation).Start(req.Context(), req.Method+" "+endpoint,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("peer.service", service),
+			attribute.String("http.request.method", req.Method),
+			attribute.String("server.address", req.URL.Hostname()),
+			attribute.String("url.template", endpoint),
+		),
+	)
+	return span
+}
+
+// LogHook is a zerolog hook adding the trace and span IDs of the event's context to every log line.
+type LogHook struct{}
+
+// Run adds trace_id and span_id when there is a recording span.
+func (LogHook) Run(e *zerolog.Event, _ zerolog.Level, _ string) {
+	if sc := trace.SpanContextFromContext(e.GetCtx()); sc.IsValid() {
+		e.Str("trace_id", sc.TraceID().String()).Str("span_id", sc.SpanID().String())
+	}
+}
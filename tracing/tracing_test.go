@@ -0,0 +1,113 @@
+package tracing_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/prom"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/tracing"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func record(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+	return recorder
+}
+
+func TestSpans(t *testing.T) {
+	recorder := record(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	ctx, root := tracing.Start(t.Context(), "sync")
+	accountCtx, account := tracing.Start(ctx, "account")
+	// Spans started from the same context are siblings, whatever order they end in
+	_, other := tracing.Start(ctx, "other")
+	req, _ := http.NewRequestWithContext(accountCtx, "GET", server.URL+"/api/v1/accounts/12", nil)
+	if _, err := prom.NewClient("firefly", 0).Do(req); err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+	account.Fail(errors.New("balance mismatch"))
+	account.End()
+	other.End()
+	root.End()
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+	}
+	request, ok := spans["GET /api/v1/accounts/{id}"]
+	if !ok {
+		t.Fatalf("Got spans %v, wanted one for the request", spans)
+	}
+	if request.Parent().SpanID() != spans["account"].SpanContext().SpanID() || spans["account"].Parent().SpanID() != spans["sync"].SpanContext().SpanID() {
+		t.Fatalf("Got request under %s and account under %s, wanted request < account < sync", request.Parent().SpanID(), spans["account"].Parent().SpanID())
+	}
+	if spans["other"].Parent().SpanID() != spans["sync"].SpanContext().SpanID() {
+		t.Fatalf("Got other under %s, wanted it under sync", spans["other"].Parent().SpanID())
+	}
+	if request.Status().Code != codes.Error || spans["account"].Status().Code != codes.Error || spans["sync"].Status().Code == codes.Error {
+		t.Fatalf("Got statuses %v, %v and %v, wanted the 404 and the account failed", request.Status(), spans["account"].Status(), spans["sync"].Status())
+	}
+}
+
+func TestLogHook(t *testing.T) {
+	record(t)
+	var buf bytes.Buffer
+	logger := zerolog.New(&buf).Hook(tracing.LogHook{})
+
+	ctx, span := tracing.Start(t.Context(), "sync")
+	logger.Info().Msg("outside")
+	logger.Info().Ctx(ctx).Msg("inside")
+	span.End()
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	var outside, inside map[string]string
+	_ = json.Unmarshal(lines[0], &outside)
+	_ = json.Unmarshal(lines[1], &inside)
+	if _, ok := outside["trace_id"]; ok {
+		t.Fatalf("Got %v, wanted no trace ID without the span's context", outside)
+	}
+	if inside["trace_id"] != span.SpanContext().TraceID().String() || inside["span_id"] != span.SpanContext().SpanID().String() {
+		t.Fatalf("Got %v, wanted the span's trace and span IDs", inside)
+	}
+}
+
+func TestSetupFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+	shutdown, err := tracing.Setup(t.Context(), tracing.Options{Exporter: tracing.ExporterFile, File: path, Service: "test"})
+	if err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	_, span := tracing.Start(t.Context(), "sync")
+	span.End()
+	if err = shutdown(t.Context()); err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+	// Written once the exporter flushes on shutdown
+	data, _ := os.ReadFile(path)
+	if !bytes.Contains(data, []byte(`"Name":"sync"`)) {
+		t.Fatalf("Got %q, wanted the sync span", data)
+	}
+
+	if _, err = tracing.Setup(t.Context(), tracing.Options{Exporter: "zipkin"}); err == nil {
+		t.Fatalf("Got no error for an unknown exporter, wanted one")
+	}
+}
@@ -1,33 +1,57 @@
 package main
 
 import (
-	"strings"
+	"context"
 	"time"
 
 	"github.com/helpcomp/firefly-iii-simplefin-importer/config"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/dedupe"
 	"github.com/helpcomp/firefly-iii-simplefin-importer/duration"
 	"github.com/helpcomp/firefly-iii-simplefin-importer/firefly"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/institution"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/logging"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/normalize"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/notify"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/prom"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/reconcile"
 	"github.com/helpcomp/firefly-iii-simplefin-importer/simplefin"
+	"github.com/helpcomp/firefly-iii-simplefin-importer/tracing"
 	"github.com/rs/zerolog/log"
-	"github.com/sashabaranov/go-openai"
 	"github.com/shopspring/decimal"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/exp/slices"
 )
 
 type SyncApp struct {
 	firefly          *firefly.Firefly
 	config           *config.MasterConfig
-	oai              *openai.Client
+	categorizer      *Categorizer
+	history          *reconcile.History
+	investigator     *Investigator
+	duplicates       *dedupe.Store
+	reviews          *Reviewer
+	shadow           *Shadow
+	notifier         *notify.Notifier
+	institutions     *institution.Tracker
+	normalizer       *normalize.Normalizer
 	transferBypasses map[string]config.TransactionInfo
 }
 
-// NewSyncApp creates a new SyncApp instance with a pre-built transfer bypass map.
-// This should be created once and reused across multiple accounts for efficiency.
-func NewSyncApp(ff *firefly.Firefly, cfg *config.MasterConfig, oai *openai.Client) *SyncApp {
+// NewSyncApp creates a new SyncApp instance with a pre-built transfer bypass map and description normalizer.
+// This should be created once and reused across every sync for efficiency.
+func NewSyncApp(ff *firefly.Firefly, cfg *config.MasterConfig, categorizer *Categorizer, history *reconcile.History, investigator *Investigator, duplicates *dedupe.Store, reviews *Reviewer, shadow *Shadow, notifier *notify.Notifier, institutions *institution.Tracker) *SyncApp {
 	return &SyncApp{
 		firefly:          ff,
 		config:           cfg,
-		oai:              oai,
+		categorizer:      categorizer,
+		history:          history,
+		investigator:     investigator,
+		duplicates:       duplicates,
+		reviews:          reviews,
+		shadow:           shadow,
+		notifier:         notifier,
+		institutions:     institutions,
+		normalizer:       buildNormalizer(cfg),
 		transferBypasses: buildTransferBypassMap(cfg),
 	}
 }
@@ -56,9 +80,14 @@ func (s *SyncApp) DoesTransactionExist(newTrans firefly.Transaction, transIndex
 		return true, false, idx.TransactionID
 	}
 
+	// Adopted manual transactions keep what was entered by hand
+	if s.duplicates != nil && s.duplicates.IsAdopted(newTrans.ExternalID) {
+		return true, false, idx.TransactionID
+	}
+
 	if !idx.OldTrans.Amount.Equal(newTrans.Amount) ||
 		oldDate.Format(time.DateOnly) != newTrans.Date ||
-		!slices.Equal(idx.OldTrans.Tags, newTrans.Tags) ||
+		!slices.Equal(withoutTag(idx.OldTrans.Tags, s.reviews.Tag()), newTrans.Tags) ||
 		idx.OldTrans.CategoryName == "" ||
 		idx.OldTrans.DestinationName == defaultAccountName ||
 		idx.OldTrans.SourceName == defaultAccountName {
@@ -77,7 +106,7 @@ func (s *SyncApp) DoesTransactionExist(newTrans firefly.Transaction, transIndex
 // Returns:
 // - hasPendingTransactions: indicates if there are pending transactions in the account
 // - pendingBalance: the total balance associated with pending transactions
-func CheckTransactions(s *SyncApp, acct simplefin.Accounts, pendingTransfers map[string]decimal.Decimal) (hasPendingTransactions bool, pendingBalance decimal.Decimal) {
+func CheckTransactions(ctx context.Context, s *SyncApp, acct simplefin.Accounts, pendingTransfers map[string]decimal.Decimal) (hasPendingTransactions bool, pendingBalance decimal.Decimal) {
 	var err error
 	skipTransaction := false
 	accountHasPending := false
@@ -85,29 +114,22 @@ func CheckTransactions(s *SyncApp, acct simplefin.Accounts, pendingTransfers map
 	processedTransactions := 0
 
 	// Build Index
-	t, err := duration.ParseDuration(cli.SimplefinLoopbackDuration)
+	existing, err := s.existingTransactions(ctx)
 	if err != nil {
-		log.Fatal().Err(err).Msgf("Unable to parse duration")
-		return false, decimal.Zero
-	}
-	now := time.Now()
-	extendT, _ := duration.ParseDuration("-5d")
-	then := now.Add(-t).Add(extendT)
-
-	existing, err := s.firefly.CachedTransactions(firefly.TransactionsKey{
-		Start: then.Format(time.DateOnly),
-		End:   now.Format(time.DateOnly),
-	})
-	if err != nil {
-		log.Error().Err(err).Msg("Error getting cached transactions")
+		logging.Ctx(ctx).Error().Err(err).Msg("Error getting cached transactions")
 		return false, decimal.Zero
 	}
 
 	// Build the index once for all transactions
 	transIndex := buildTransactionIndex(existing)
+	claimed := make(map[string]bool) // Manual transactions adopted during this run
 
 	// Loop through all the gathered transactions for the Simplefin Account
+	var span *tracing.Span
 	for _, trans := range acct.Transactions {
+		span.End()
+		var transCtx context.Context
+		transCtx, span = tracing.Start(ctx, "transaction", attribute.String("simplefin.transaction.id", trans.ID), attribute.Bool("simplefin.transaction.pending", trans.Pending))
 		var tags []string
 		newTrans := firefly.Transaction{}
 
@@ -128,6 +150,7 @@ func CheckTransactions(s *SyncApp, acct simplefin.Accounts, pendingTransfers map
 			Date:            time.Unix(trans.TransactedAt, 0).Format(time.DateOnly),
 			Amount:          trans.Amount.Abs(),
 			Description:     trans.Description,
+			Notes:           rawDescriptionNote(trans),
 			SourceName:      acct.Name,
 			SourceID:        s.config.Accounts[acct.ID],
 			DestinationName: defaultAccountName,
@@ -153,7 +176,8 @@ func CheckTransactions(s *SyncApp, acct simplefin.Accounts, pendingTransfers map
 		// Debug Mode - Skip posting and updating transactions
 		if cli.DoNotUpdateTransactions {
 			if !exists {
-				extracted := ExtractCompanyAndCategory(s.firefly, s.config, s.oai, trans)
+				extracted := s.categorizer.ExtractCompanyAndCategory(transCtx, trans, s.config.Accounts[acct.ID])
+				extracted = s.categorizer.CanonicalMerchant(transCtx, extracted, counterpartType(newTrans))
 
 				if newTrans.SourceName == defaultAccountName {
 					newTrans.SourceName = extracted.Company
@@ -172,7 +196,8 @@ func CheckTransactions(s *SyncApp, acct simplefin.Accounts, pendingTransfers map
 				}
 			}
 
-			log.Info().
+			logging.Ctx(transCtx).Info().
+				Str("event", "transaction.found").
 				Str("Type", "Transaction").
 				Str("Description", trans.Description).
 				Str("ID", trans.ID).
@@ -186,7 +211,12 @@ func CheckTransactions(s *SyncApp, acct simplefin.Accounts, pendingTransfers map
 
 		// New Transaction
 		if !exists {
-			skipTransaction, err = s.PostTransaction(trans, newTrans)
+			// Entered by hand in Firefly already?
+			if s.MatchManualTransaction(transCtx, acct, trans, newTrans, existing, claimed) {
+				continue
+			}
+
+			skipTransaction, err = s.PostTransaction(transCtx, trans, newTrans)
 			if skipTransaction {
 				continue
 			}
@@ -196,7 +226,8 @@ func CheckTransactions(s *SyncApp, acct simplefin.Accounts, pendingTransfers map
 				pendingBalance = pendingBalance.Sub(trans.Amount)
 			}
 
-			log.Info().
+			logging.Ctx(transCtx).Info().
+				Str("event", "transaction.found").
 				Str("Type", "Transaction").
 				Str("Description", trans.Description).
 				Str("ID", trans.ID).
@@ -208,11 +239,15 @@ func CheckTransactions(s *SyncApp, acct simplefin.Accounts, pendingTransfers map
 
 			if err != nil {
 				// Error Posting Transaction
-				log.Error().Err(err).Msgf("🚨 transaction %s FAILED for %s - %v\n", trans.Description, acct.Name, err)
+				logging.Ctx(transCtx).Error().Err(err).Str("event", "transaction.failed").Str("Action", "create").Str("ID", trans.ID).Str("Description", trans.Description).Str("Account", acct.Name).Msg("🚨 Could not add transaction")
+				notifyTransactionFailed(s.notifier, "create", trans.ID, trans.Description, acct.Name, err)
+				span.Fail(err)
 				continue
 			}
+			span.SetAttributes(attribute.String("sync.action", prom.SyncCreated))
 			processedTransactions++
-			log.Info().Str("type", "Add").Str("transactionDescription", trans.Description).Str("accountName", acct.Name).Msgf("➕ Successfully added transaction")
+			countSync(s.firefly, s.config.Accounts[acct.ID], prom.SyncCreated)
+			logging.Ctx(transCtx).Info().Str("event", "transaction.created").Str("ID", trans.ID).Str("Description", trans.Description).Str("Account", acct.Name).Msg("➕ Successfully added transaction")
 			continue
 		}
 
@@ -221,19 +256,26 @@ func CheckTransactions(s *SyncApp, acct simplefin.Accounts, pendingTransfers map
 
 		// Existing Transaction that needs updated
 		if shouldUpdate && !trans.Pending {
-			err = s.UpdateTransaction(oldTransactionID, newTrans, trans)
+			err = s.UpdateTransaction(transCtx, oldTransactionID, newTrans, trans)
 
 			if err != nil {
 				// Error updating transaction
-				log.Error().Err(err).Msgf("🚨 transaction Update %s FAILED for %s\n", trans.Description, acct.Name)
+				logging.Ctx(transCtx).Error().Err(err).Str("event", "transaction.failed").Str("Action", "update").Str("ID", trans.ID).Str("Description", trans.Description).Str("Account", acct.Name).Msg("🚨 Could not update transaction")
+				notifyTransactionFailed(s.notifier, "update", trans.ID, trans.Description, acct.Name, err)
+				span.Fail(err)
 				continue
 			}
+			span.SetAttributes(attribute.String("sync.action", prom.SyncUpdated))
 
 			processedTransactions++
+			countSync(s.firefly, s.config.Accounts[acct.ID], prom.SyncUpdated)
+			logging.Ctx(transCtx).Info().Str("event", "transaction.updated").Str("ID", trans.ID).Str("Description", trans.Description).Str("Account", acct.Name).Msg("✏️ Updated transaction")
 		}
 	}
+	span.End()
 
-	log.Info().
+	logging.Ctx(ctx).Info().
+		Str("event", "account.processed").
 		Float64("PendingBalance", pendingBalance.InexactFloat64()).
 		Int("ProcessedTransactions", processedTransactions).
 		Str("Account", acct.Name).
@@ -243,6 +285,51 @@ func CheckTransactions(s *SyncApp, acct simplefin.Accounts, pendingTransfers map
 	return accountHasPending, pendingBalance
 }
 
+// existingTransactions returns the Firefly transactions within the SimpleFIN loopback duration, plus 5 days.
+func (s *SyncApp) existingTransactions(ctx context.Context) ([]firefly.Transactions, error) {
+	t, err := duration.ParseDuration(cli.SimplefinLoopbackDuration)
+	if err != nil {
+		logging.Ctx(ctx).Fatal().Err(err).Msgf("Unable to parse duration")
+		return nil, err
+	}
+	now := time.Now()
+	extendT, _ := duration.ParseDuration("-5d")
+	then := now.Add(-t).Add(extendT)
+
+	return s.firefly.CachedTransactions(ctx, firefly.TransactionsKey{
+		Start: then.Format(time.DateOnly),
+		End:   now.Format(time.DateOnly),
+	})
+}
+
+// PrefetchCategories categorizes the new transactions of every account in batches before they are synced,
+// so the sync itself rarely has to wait on the AI provider.
+func (s *SyncApp) PrefetchCategories(ctx context.Context, accounts []simplefin.Accounts) {
+	existing, err := s.existingTransactions(ctx)
+	if err != nil {
+		logging.Ctx(ctx).Error().Err(err).Msg("Error getting cached transactions")
+		return
+	}
+	transIndex := buildTransactionIndex(existing)
+
+	var items []batchItem
+	for _, acct := range accounts {
+		accountID := s.config.Accounts[acct.ID]
+		if _, nonAsset := s.config.NonAssetAccounts[accountID]; accountID == "" || accountID == "0" || nonAsset {
+			continue
+		}
+		for _, trans := range acct.Transactions {
+			if _, found := transIndex[trans.ID]; found {
+				continue
+			}
+			items = append(items, batchItem{Transaction: trans, AccountID: accountID})
+		}
+	}
+
+	s.categorizer.Prefetch(ctx, items)
+	s.shadow.Prefetch(ctx, items)
+}
+
 // CalculatePendingBalance computes the pending balance for a given account by analyzing its transactions and pending transfers.
 // Transactions that are marked as pending or transfers bypassed by configuration are included in the calculation.
 // Returns the updated pending balance as a decimal.Decimal value.
@@ -254,7 +341,11 @@ func (s *SyncApp) CalculatePendingBalance(trans simplefin.Transactions, account
 		pendingTransfers[s.config.Accounts[account.ID]] = pendingTransfers[s.config.Accounts[account.ID]].Add(trans.Amount)
 	}
 
-	if bypassResp, found := s.transferBypasses[trans.Description]; found {
+	bypassResp, found := s.transferBypasses[trans.Description]
+	if !found && trans.RawDescription != "" {
+		bypassResp, found = s.transferBypasses[trans.RawDescription]
+	}
+	if found {
 		pendingTransfers[bypassResp.SourceAccount] = pendingTransfers[bypassResp.SourceAccount].Add(trans.Amount)
 		if trans.Pending {
 			pendingBalance = pendingBalance.Sub(trans.Amount)
@@ -268,8 +359,10 @@ func (s *SyncApp) CalculatePendingBalance(trans simplefin.Transactions, account
 // UpdateTransaction updates an existing transaction in Firefly by applying updated details such as source, destination, and category.
 // It uses the provided simplefinTransaction to extract company and category data and modifies the ffTransaction accordingly.
 // The updated transaction is then sent to Firefly identified by the oldTransactionID. Returns an error if the update fails.
-func (s *SyncApp) UpdateTransaction(oldTransactionID string, ffTransaction firefly.Transaction, simplefinTransaction simplefin.Transactions) error {
-	extracted := ExtractCompanyAndCategory(s.firefly, s.config, s.oai, simplefinTransaction)
+func (s *SyncApp) UpdateTransaction(ctx context.Context, oldTransactionID string, ffTransaction firefly.Transaction, simplefinTransaction simplefin.Transactions) error {
+	extracted := s.categorizer.ExtractCompanyAndCategory(ctx, simplefinTransaction, assetAccountID(ffTransaction))
+	extracted = s.categorizer.CanonicalMerchant(ctx, extracted, counterpartType(ffTransaction))
+	s.shadow.Compare(ctx, simplefinTransaction, assetAccountID(ffTransaction), counterpartType(ffTransaction), extracted)
 
 	if ffTransaction.SourceName == defaultAccountName {
 		ffTransaction.SourceName = extracted.Company
@@ -280,20 +373,29 @@ func (s *SyncApp) UpdateTransaction(oldTransactionID string, ffTransaction firef
 	}
 	ffTransaction.CategoryID = extracted.Category
 	ffTransaction.Tags = make([]string, 0) // Remove Tag
+	if s.reviews.NeedsReview(extracted) {
+		ffTransaction.Tags = append(ffTransaction.Tags, s.reviews.Tag())
+	}
 
-	return s.firefly.UpdateTransaction(oldTransactionID, ffTransaction)
+	if err := s.firefly.UpdateTransaction(ctx, oldTransactionID, ffTransaction); err != nil {
+		return err
+	}
+	s.reviews.Track(simplefinTransaction, ffTransaction, extracted)
+	return nil
 }
 
 // PostTransaction processes transactions between SimpleFIN and Firefly and creates or skips them based on specific criteria.
 // It extracts merchant and category information, updates transaction details, and applies configuration rules as needed.
 // Returns true if the transaction is skipped; otherwise, attempts to create the transaction and returns success status or an error.
-func (s *SyncApp) PostTransaction(simplefinTrans simplefin.Transactions, ffTransaction firefly.Transaction) (bool, error) {
-	extracted := ExtractCompanyAndCategory(s.firefly, s.config, s.oai, simplefinTrans)
+func (s *SyncApp) PostTransaction(ctx context.Context, simplefinTrans simplefin.Transactions, ffTransaction firefly.Transaction) (bool, error) {
+	extracted := s.categorizer.ExtractCompanyAndCategory(ctx, simplefinTrans, assetAccountID(ffTransaction))
+	extracted = s.categorizer.CanonicalMerchant(ctx, extracted, counterpartType(ffTransaction))
 
 	if extracted.Skip {
 		// Skip posting this transaction
 		return true, nil
 	}
+	s.shadow.Compare(ctx, simplefinTrans, assetAccountID(ffTransaction), counterpartType(ffTransaction), extracted)
 
 	if ffTransaction.SourceName == defaultAccountName {
 		ffTransaction.SourceName = extracted.Company
@@ -303,11 +405,14 @@ func (s *SyncApp) PostTransaction(simplefinTrans simplefin.Transactions, ffTrans
 		ffTransaction.DestinationID = extracted.CompanyID
 	}
 	ffTransaction.CategoryID = extracted.Category
+	if s.reviews.NeedsReview(extracted) {
+		ffTransaction.Tags = append(ffTransaction.Tags, s.reviews.Tag())
+	}
 
 	// Update Transaction based on Config Data - If Applicable
 	for _, transBypasses := range s.config.TransactionBypassResponse {
 		for key, configResp := range transBypasses {
-			if strings.Contains(simplefinTrans.Description, key) {
+			if descriptionContains(simplefinTrans, key) {
 				// Update Type
 				if configResp.Type != "" {
 					ffTransaction.Type = configResp.Type
@@ -329,7 +434,119 @@ func (s *SyncApp) PostTransaction(simplefinTrans simplefin.Transactions, ffTrans
 		}
 	}
 
-	return false, s.firefly.CreateTransaction(ffTransaction)
+	if err := s.firefly.CreateTransaction(ctx, ffTransaction); err != nil {
+		return false, err
+	}
+	s.reviews.Track(simplefinTrans, ffTransaction, extracted)
+	return false, nil
+}
+
+// MatchManualTransaction looks for a transaction entered by hand in Firefly that is the same as a new SimpleFIN
+// transaction. A single match is adopted: it receives the SimpleFIN ID as its external ID (and a category, if
+// enrichment is enabled and it has none) instead of a duplicate being created. Several matches put the transaction
+// on the review list until someone decides. Returns true if the transaction should not be posted.
+func (s *SyncApp) MatchManualTransaction(ctx context.Context, acct simplefin.Accounts, trans simplefin.Transactions, newTrans firefly.Transaction, existing []firefly.Transactions, claimed map[string]bool) bool {
+	// Pending transactions change ID once posted, so only posted ones are adopted
+	if !s.config.ManualMatching.Enabled || s.duplicates == nil || trans.Pending {
+		return false
+	}
+
+	days := s.config.ManualMatching.Days
+	if days <= 0 {
+		days = 3
+	}
+	accountID := s.config.Accounts[acct.ID]
+	candidates := dedupe.FindCandidates(existing, accountID, newTrans, days, claimed)
+
+	// A decision was made on the review list
+	if res, ok := s.duplicates.Resolution(trans.ID); ok {
+		if res.Action == dedupe.ActionImport {
+			s.duplicates.Imported(trans.ID)
+			return false
+		}
+		for _, c := range candidates {
+			if c.FireflyID == res.FireflyID {
+				return s.adoptManualTransaction(ctx, trans, accountID, c, claimed)
+			}
+		}
+		logging.Ctx(ctx).Warn().Str("ID", trans.ID).Str("FireflyID", res.FireflyID).Msg("Chosen manual transaction is no longer a match, reviewing again")
+	}
+
+	switch len(candidates) {
+	case 0:
+		return false
+	case 1:
+		return s.adoptManualTransaction(ctx, trans, accountID, candidates[0], claimed)
+	default:
+		logging.Ctx(ctx).Warn().
+			Str("event", "transaction.review").
+			Str("Type", "Transaction").
+			Str("Description", trans.Description).
+			Str("ID", trans.ID).
+			Int("Candidates", len(candidates)).
+			Msg("🔁 Several manual transactions match, added to the duplicate review list")
+		s.duplicates.AddReview(dedupe.Review{
+			TransactionID: trans.ID,
+			AccountID:     accountID,
+			Description:   trans.Description,
+			Date:          newTrans.Date,
+			Amount:        trans.Amount,
+			Candidates:    candidates,
+			Created:       time.Now(),
+		})
+		return true
+	}
+}
+
+// adoptManualTransaction sets the SimpleFIN ID on a manual Firefly transaction so it is treated as imported.
+func (s *SyncApp) adoptManualTransaction(ctx context.Context, trans simplefin.Transactions, accountID string, c dedupe.Candidate, claimed map[string]bool) bool {
+	fields := map[string]any{"external_id": trans.ID}
+
+	if s.config.ManualMatching.Enrich && c.CategoryName == "" {
+		extracted := s.categorizer.ExtractCompanyAndCategory(ctx, trans, accountID)
+		if extracted.Category != "" {
+			fields["category_id"] = extracted.Category
+		}
+	}
+
+	if err := s.firefly.PatchTransaction(ctx, c.FireflyID, c.JournalID, fields); err != nil {
+		// Leave it to be imported normally rather than lose the transaction
+		logging.Ctx(ctx).Error().Err(err).Str("ID", trans.ID).Str("FireflyID", c.FireflyID).Msg("Could not adopt manual transaction")
+		return false
+	}
+
+	claimed[c.FireflyID] = true
+	s.duplicates.Adopt(trans.ID, c.FireflyID)
+	logging.Ctx(ctx).Info().
+		Str("event", "transaction.adopted").
+		Str("Type", "Transaction").
+		Str("Description", trans.Description).
+		Str("ID", trans.ID).
+		Str("FireflyID", c.FireflyID).
+		Str("ManualDescription", c.Description).
+		Int("DaysApart", c.DaysApart).
+		Msg("🔗 Adopted manually entered transaction instead of creating a duplicate")
+	return true
+}
+
+// assetAccountID returns the SimpleFIN-backed account of a transaction built from SimpleFIN data:
+// the destination of a deposit, the source of anything else.
+func assetAccountID(t firefly.Transaction) string {
+	if t.Type == "deposit" {
+		return t.DestinationID
+	}
+	return t.SourceID
+}
+
+// withoutTag returns tags without the given tag.
+func withoutTag(tags []string, tag string) []string {
+	var kept []string
+	for _, t := range tags {
+		if t != tag {
+			kept = append(kept, t)
+		}
+	}
+	return kept
 }
 
 func buildTransactionIndex(existing []firefly.Transactions) map[string]TransactionIndex {
@@ -3,16 +3,37 @@ package main
 // Shared constants
 const defaultAccountName = "(no name)"
 
+// Categorization sources
+const (
+	sourceNone      = "none"      // Nothing matched, defaults were used
+	sourceRule      = "rule"      // transactionBypass rule
+	sourceDetector  = "detector"  // Income detector (interest, dividends, payroll, refunds)
+	sourceLearned   = "learned"   // Learned categorizer
+	sourceEmbedding = "embedding" // Nearest labelled transactions by description embedding
+	sourceCache     = "cache"     // Categorization cache
+	sourceAI        = "ai"        // OpenAI / Azure
+	sourceReview    = "review"    // Approved or corrected by hand
+)
+
+// defaultMatchThreshold is how close an AI answer must be to a Firefly name to be mapped onto it.
+const defaultMatchThreshold = 0.8
+
+// unratedConfidence is assumed for AI answers that came without a confidence.
+const unratedConfidence = 0.5
+
 // OpenAIResponse represents the JSON response from OpenAI API
 type OpenAIResponse struct {
-	Merchant string `json:"Merchant"`
-	Category string `json:"Category"`
+	Merchant   string  `json:"Merchant"`
+	Category   string  `json:"Category"`
+	Confidence float64 `json:"Confidence"`
 }
 
 // ExtractedData holds the extracted company and category information from a transaction
 type ExtractedData struct {
-	Company   string
-	Category  string
-	Skip      bool
-	CompanyID string
+	Company    string
+	Category   string
+	Skip       bool
+	CompanyID  string
+	Confidence float64 // How sure the source is, between 0 and 1
+	Source     string  // Which categorizer answered, see the source constants
 }
@@ -0,0 +1,127 @@
+// Package usage keeps count of the AI provider tokens and dollars spent this calendar month, against a budget
+package usage
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/internal/jsonfile"
+	"github.com/rs/zerolog/log"
+)
+
+const monthFormat = "2006-01"
+
+// Limits is a monthly budget. Zero means no limit.
+type Limits struct {
+	Tokens  int64
+	Dollars float64
+}
+
+// Usage is what one request used.
+type Usage struct {
+	At               time.Time // Defaults to now
+	PromptTokens     int
+	CompletionTokens int
+	Cost             float64 // Dollars
+}
+
+// Month is the usage of one calendar month.
+type Month struct {
+	Month            string  `json:"month"` // YYYY-MM
+	Requests         int64   `json:"requests"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// Tokens is the total of prompt and completion tokens.
+func (m Month) Tokens() int64 {
+	return m.PromptTokens + m.CompletionTokens
+}
+
+// Tracker is the persistent usage of the current month.
+type Tracker struct {
+	path    string
+	limits  Limits
+	Current Month `json:"current"`
+	dirty   bool  // Changed since the last save
+	mu      sync.Mutex
+}
+
+// Open loads the usage recorded at path. Nothing recorded yet means nothing spent.
+func Open(path string, limits Limits) (*Tracker, error) {
+	t := &Tracker{path: path, limits: limits}
+
+	if err := jsonfile.Load(path, t); err != nil {
+		return t, fmt.Errorf("could not load AI usage: %w", err)
+	}
+	return t, nil
+}
+
+// Record adds a request to the month it was made in, starting a new month if needed. Returns true if this request
+// took the month over budget. Usage is written to disk by the next Flush.
+func (t *Tracker) Record(u Usage) bool {
+	if u.At.IsZero() {
+		u.At = time.Now()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.roll(u.At)
+	before := t.exceeded()
+	t.Current.Requests++
+	t.Current.PromptTokens += int64(u.PromptTokens)
+	t.Current.CompletionTokens += int64(u.CompletionTokens)
+	t.Current.Cost += u.Cost
+	t.dirty = true
+	return !before && t.exceeded()
+}
+
+// Flush writes the usage to disk if it changed since it was last written.
+func (t *Tracker) Flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.dirty {
+		t.save()
+	}
+}
+
+// Exceeded reports whether this month's usage is over budget.
+func (t *Tracker) Exceeded() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.roll(time.Now())
+	return t.exceeded()
+}
+
+// Month returns this month's usage.
+func (t *Tracker) Month() Month {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.roll(time.Now())
+	return t.Current
+}
+
+// roll starts a new month when at is in a later month than the current one.
+func (t *Tracker) roll(at time.Time) {
+	if month := at.Format(monthFormat); month > t.Current.Month {
+		t.Current = Month{Month: month}
+	}
+}
+
+// exceeded reports whether the current month is over budget, with t.mu held.
+func (t *Tracker) exceeded() bool {
+	return (t.limits.Tokens > 0 && t.Current.Tokens() >= t.limits.Tokens) ||
+		(t.limits.Dollars > 0 && t.Current.Cost >= t.limits.Dollars)
+}
+
+// save persists the usage; t.mu must be held.
+func (t *Tracker) save() {
+	if err := jsonfile.Save(t.path, t); err != nil {
+		log.Error().Err(err).Msg("Could not save AI usage")
+		return
+	}
+	t.dirty = false
+}
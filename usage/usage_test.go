@@ -0,0 +1,42 @@
+package usage_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/helpcomp/firefly-iii-simplefin-importer/usage"
+)
+
+func TestTracker(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ai_usage.json")
+	tr, err := usage.Open(path, usage.Limits{Tokens: 1000})
+	if err != nil {
+		t.Fatalf("Got error %v, wanted none", err)
+	}
+
+	march := time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)
+	if tr.Record(usage.Usage{At: march, PromptTokens: 600, CompletionTokens: 100, Cost: 0.01}) {
+		t.Fatalf("Got over budget at 700 tokens, wanted under")
+	}
+	if !tr.Record(usage.Usage{At: march, PromptTokens: 250, CompletionTokens: 50}) {
+		t.Fatalf("Got under budget at 1000 tokens, wanted over")
+	}
+	if tr.Record(usage.Usage{At: march, PromptTokens: 10}) {
+		t.Fatalf("Got the budget crossed twice, wanted only once")
+	}
+
+	tr.Flush()
+	tr, err = usage.Open(path, usage.Limits{Tokens: 1000})
+	if err != nil {
+		t.Fatalf("Got error %v reopening, wanted none", err)
+	}
+	if tr.Current.Requests != 3 || tr.Current.Tokens() != 1010 || tr.Current.Month != "2025-03" {
+		t.Fatalf("Got %+v, wanted 3 requests and 1010 tokens in 2025-03", tr.Current)
+	}
+
+	// A new month starts from zero
+	if tr.Record(usage.Usage{At: march.AddDate(0, 1, 0), PromptTokens: 10}); tr.Current.Tokens() != 10 {
+		t.Fatalf("Got %d tokens in April, wanted 10", tr.Current.Tokens())
+	}
+}